@@ -0,0 +1,187 @@
+package consensus
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+
+	"github.com/hashicorp/raft"
+	"github.com/txix-open/walx/v2"
+)
+
+const (
+	restoreBatchSize = 1024
+)
+
+var (
+	ErrCompactedSnapshot = errors.New("snapshot doesn't contain compacted entries")
+)
+
+type applyResult struct {
+	index uint64
+}
+
+// fsm copies committed raft entries to the state WAL, so the state applies only committed events.
+// Entries are appended in commit order, thus the WAL index of an entry is determined by the number of
+// entries committed before it, and entries replayed by raft after restart are skipped.
+// If an entry can't be written, the mapping is broken, so the fsm rejects all further entries and calls onFailure
+type fsm struct {
+	log       *walx.Log
+	applied   *atomic.Uint64
+	failure   *atomic.Pointer[error]
+	onFailure func(err error)
+}
+
+func newFsm(log *walx.Log, onFailure func(err error)) *fsm {
+	return &fsm{
+		log:       log,
+		applied:   &atomic.Uint64{},
+		failure:   &atomic.Pointer[error]{},
+		onFailure: onFailure,
+	}
+}
+
+func (f *fsm) Apply(log *raft.Log) any {
+	failure := f.failure.Load()
+	if failure != nil {
+		return *failure
+	}
+
+	index := f.applied.Load() + 1
+	if index <= f.log.LastIndex() {
+		f.applied.Store(index)
+		return applyResult{index: index}
+	}
+
+	index, err := f.log.Write(log.Data, func(index uint64) {})
+	if err != nil {
+		err = fmt.Errorf("write committed entry %d: %w", log.Index, err)
+		f.failure.Store(&err)
+		f.onFailure(err)
+		return err
+	}
+	f.applied.Store(index)
+	return applyResult{index: index}
+}
+
+func (f *fsm) Snapshot() (raft.FSMSnapshot, error) {
+	first, err := f.log.FirstIndex()
+	if err != nil {
+		return nil, err
+	}
+	return &walSnapshot{
+		log:        f.log,
+		firstIndex: first,
+		lastIndex:  f.applied.Load(),
+	}, nil
+}
+
+// Restore appends entries from the snapshot missing in the local WAL.
+// If the snapshot starts after the end of the local WAL, the entries in between were compacted on the leader
+// and can't be received, the restore fails with ErrCompactedSnapshot and the WAL must be copied from the leader
+func (f *fsm) Restore(snapshot io.ReadCloser) error {
+	defer snapshot.Close()
+
+	reader := bufio.NewReader(snapshot)
+	header := make([]byte, 16)
+	_, err := io.ReadFull(reader, header)
+	if err != nil {
+		return fmt.Errorf("read snapshot header: %w", err)
+	}
+	first := binary.BigEndian.Uint64(header)
+	last := binary.BigEndian.Uint64(header[8:])
+
+	if first > f.log.LastIndex()+1 {
+		return fmt.Errorf("%w: snapshot starts from entry %d, local wal ends at %d", ErrCompactedSnapshot, first, f.log.LastIndex())
+	}
+
+	batch := make(walx.Entries, 0, restoreBatchSize)
+	for index := first; index <= last && first > 0; index++ {
+		size, err := binary.ReadUvarint(reader)
+		if err != nil {
+			return fmt.Errorf("read snapshot entry %d: %w", index, err)
+		}
+		data := make([]byte, size)
+		_, err = io.ReadFull(reader, data)
+		if err != nil {
+			return fmt.Errorf("read snapshot entry %d: %w", index, err)
+		}
+		if index <= f.log.LastIndex() {
+			continue
+		}
+
+		batch = append(batch, walx.Entry{Index: index, Data: data})
+		if len(batch) == restoreBatchSize {
+			err = f.log.WriteEntries(batch)
+			if err != nil {
+				return fmt.Errorf("write snapshot entries: %w", err)
+			}
+			batch = batch[:0]
+		}
+	}
+	err = f.log.WriteEntries(batch)
+	if err != nil {
+		return fmt.Errorf("write snapshot entries: %w", err)
+	}
+
+	f.applied.Store(last)
+	return nil
+}
+
+// walSnapshot contains retained state WAL entries up to the last applied one, so truncating the front
+// of the state WAL bounds its size. It is encoded as first and last indexes followed by length prefixed entries
+type walSnapshot struct {
+	log        *walx.Log
+	firstIndex uint64
+	lastIndex  uint64
+}
+
+func (s *walSnapshot) Persist(sink raft.SnapshotSink) error {
+	err := s.persist(sink)
+	if err != nil {
+		_ = sink.Cancel()
+		return err
+	}
+	return sink.Close()
+}
+
+func (s *walSnapshot) Release() {
+}
+
+func (s *walSnapshot) persist(sink io.Writer) error {
+	writer := bufio.NewWriter(sink)
+	first := s.firstIndex
+	if s.lastIndex == 0 {
+		first = 0
+	}
+	header := binary.BigEndian.AppendUint64(nil, first)
+	header = binary.BigEndian.AppendUint64(header, s.lastIndex)
+	_, err := writer.Write(header)
+	if err != nil {
+		return fmt.Errorf("write snapshot header: %w", err)
+	}
+
+	if s.lastIndex > 0 {
+		reader := s.log.OpenReader(first - 1)
+		defer reader.Close()
+		for index := first; index <= s.lastIndex; index++ {
+			entry, err := reader.Read(context.Background())
+			if err != nil {
+				return fmt.Errorf("read wal entry %d: %w", index, err)
+			}
+			_, err = writer.Write(binary.AppendUvarint(nil, uint64(len(entry.Data))))
+			if err != nil {
+				return fmt.Errorf("write snapshot entry %d: %w", index, err)
+			}
+			_, err = writer.Write(entry.Data)
+			if err != nil {
+				return fmt.Errorf("write snapshot entry %d: %w", index, err)
+			}
+		}
+	}
+	return writer.Flush()
+}
@@ -0,0 +1,288 @@
+package consensus
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/txix-open/walx/v2"
+)
+
+const (
+	entriesDir = "entries"
+	offsetFile = "offset"
+)
+
+var (
+	errCorruptedLog = errors.New("corrupted raft log entry")
+)
+
+// LogStore is raft.LogStore backed by walx.Log.
+// Raft indexes are mapped to WAL indexes with an offset, so the log may start from any index
+// after it was compacted by a snapshot or cleared entirely
+type LogStore struct {
+	dir     string
+	options []walx.Option
+	log     *walx.Log
+	offset  uint64
+	lock    sync.Locker
+}
+
+// OpenLogStore opens the log in dir, every write is synced to disk unless FsyncThreshold is overridden by opts
+func OpenLogStore(dir string, opts ...walx.Option) (*LogStore, error) {
+	s := &LogStore{
+		dir:     dir,
+		options: append([]walx.Option{walx.FsyncThreshold(0)}, opts...),
+		lock:    &sync.Mutex{},
+	}
+
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("create dir: %w", err)
+	}
+	offset, err := os.ReadFile(filepath.Join(dir, offsetFile))
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return nil, fmt.Errorf("read offset: %w", err)
+	default:
+		s.offset, err = strconv.ParseUint(string(offset), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("parse offset: %w", err)
+		}
+	}
+
+	s.log, err = walx.Open(filepath.Join(dir, entriesDir), s.options...)
+	if err != nil {
+		return nil, fmt.Errorf("open entries: %w", err)
+	}
+	return s, nil
+}
+
+func (s *LogStore) FirstIndex() (uint64, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.firstIndex()
+}
+
+func (s *LogStore) LastIndex() (uint64, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.lastIndex(), nil
+}
+
+func (s *LogStore) GetLog(index uint64, log *raft.Log) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if index <= s.offset || index > s.lastIndex() {
+		return raft.ErrLogNotFound
+	}
+	data, err := s.log.Read(index - s.offset)
+	if errors.Is(err, walx.ErrNotFound) {
+		return raft.ErrLogNotFound
+	}
+	if err != nil {
+		return err
+	}
+	err = decodeLog(data, log)
+	if err != nil {
+		return fmt.Errorf("decode log %d: %w", index, err)
+	}
+	log.Index = index
+	return nil
+}
+
+func (s *LogStore) StoreLog(log *raft.Log) error {
+	return s.StoreLogs([]*raft.Log{log})
+}
+
+func (s *LogStore) StoreLogs(logs []*raft.Log) error {
+	if len(logs) == 0 {
+		return nil
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	next := s.lastIndex() + 1
+	if next == 1 {
+		next = logs[0].Index
+		err := s.setOffset(next - 1)
+		if err != nil {
+			return err
+		}
+	}
+
+	entries := make(walx.Entries, 0, len(logs))
+	for _, log := range logs {
+		if log.Index != next {
+			return fmt.Errorf("out of order log: expected index %d, got %d", next, log.Index)
+		}
+		entries = append(entries, walx.Entry{
+			Index: log.Index - s.offset,
+			Data:  encodeLog(log),
+		})
+		next++
+	}
+	return s.log.WriteEntries(entries)
+}
+
+// DeleteRange removes logs in [min, max], the range must be either a prefix or a suffix of the log
+func (s *LogStore) DeleteRange(min uint64, max uint64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	first, err := s.firstIndex()
+	if err != nil {
+		return err
+	}
+	last := s.lastIndex()
+	if last == 0 || min > last || max < first {
+		return nil
+	}
+
+	switch {
+	case min <= first && max >= last:
+		return s.reset()
+	case min <= first:
+		return s.log.TruncateFront(max + 1 - s.offset)
+	case max >= last:
+		return s.log.TruncateBack(min - 1 - s.offset)
+	default:
+		return fmt.Errorf("delete range [%d, %d] in the middle of the log [%d, %d] is not supported", min, max, first, last)
+	}
+}
+
+func (s *LogStore) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.log.Close()
+}
+
+func (s *LogStore) firstIndex() (uint64, error) {
+	if s.log.LastIndex() == 0 {
+		return 0, nil
+	}
+	first, err := s.log.FirstIndex()
+	if err != nil {
+		return 0, err
+	}
+	return first + s.offset, nil
+}
+
+func (s *LogStore) lastIndex() uint64 {
+	last := s.log.LastIndex()
+	if last == 0 {
+		return 0
+	}
+	return last + s.offset
+}
+
+// reset removes all entries, WAL can't be truncated to empty, so it is recreated
+func (s *LogStore) reset() error {
+	err := s.log.Close()
+	if err != nil {
+		return err
+	}
+	err = os.RemoveAll(filepath.Join(s.dir, entriesDir))
+	if err != nil {
+		return fmt.Errorf("remove entries: %w", err)
+	}
+	s.log, err = walx.Open(filepath.Join(s.dir, entriesDir), s.options...)
+	if err != nil {
+		return fmt.Errorf("open entries: %w", err)
+	}
+	return nil
+}
+
+func (s *LogStore) setOffset(offset uint64) error {
+	if offset == s.offset {
+		return nil
+	}
+	err := writeFileSync(filepath.Join(s.dir, offsetFile), []byte(strconv.FormatUint(offset, 10)))
+	if err != nil {
+		return fmt.Errorf("write offset: %w", err)
+	}
+	s.offset = offset
+	return nil
+}
+
+func encodeLog(log *raft.Log) []byte {
+	data := make([]byte, 0, len(log.Data)+len(log.Extensions)+32)
+	data = binary.BigEndian.AppendUint64(data, log.Term)
+	data = append(data, byte(log.Type))
+	data = binary.AppendVarint(data, log.AppendedAt.UnixNano())
+	data = binary.AppendUvarint(data, uint64(len(log.Data)))
+	data = append(data, log.Data...)
+	data = binary.AppendUvarint(data, uint64(len(log.Extensions)))
+	data = append(data, log.Extensions...)
+	return data
+}
+
+func decodeLog(data []byte, log *raft.Log) error {
+	if len(data) < 9 {
+		return errCorruptedLog
+	}
+	log.Term = binary.BigEndian.Uint64(data)
+	log.Type = raft.LogType(data[8])
+	data = data[9:]
+
+	appendedAt, n := binary.Varint(data)
+	if n <= 0 {
+		return errCorruptedLog
+	}
+	log.AppendedAt = time.Unix(0, appendedAt)
+	data = data[n:]
+
+	var err error
+	log.Data, data, err = readBytes(data)
+	if err != nil {
+		return err
+	}
+	log.Extensions, _, err = readBytes(data)
+	return err
+}
+
+func readBytes(data []byte) ([]byte, []byte, error) {
+	size, n := binary.Uvarint(data)
+	if n <= 0 || uint64(len(data)-n) < size {
+		return nil, nil, errCorruptedLog
+	}
+	data = data[n:]
+	if size == 0 {
+		return nil, data, nil
+	}
+	value := make([]byte, size)
+	copy(value, data[:size])
+	return value, data[size:], nil
+}
+
+func writeFileSync(path string, data []byte) error {
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	closeErr := file.Close()
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,116 @@
+package consensus_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/require"
+	"github.com/txix-open/walx/v2/consensus"
+)
+
+func TestLogStore(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	dir := t.TempDir()
+	store, err := consensus.OpenLogStore(dir)
+	require.NoError(err)
+	requireRange(require, store, 0, 0)
+
+	err = store.StoreLogs(raftLogs(1, 10))
+	require.NoError(err)
+	requireRange(require, store, 1, 10)
+
+	log := raft.Log{}
+	err = store.GetLog(5, &log)
+	require.NoError(err)
+	require.EqualValues(5, log.Index)
+	require.EqualValues(2, log.Term)
+	require.Equal(raft.LogCommand, log.Type)
+	require.Equal([]byte{5}, log.Data)
+	require.Equal([]byte("ext"), log.Extensions)
+	require.Equal(time.Unix(5, 0), log.AppendedAt)
+	err = store.GetLog(11, &log)
+	require.ErrorIs(err, raft.ErrLogNotFound)
+
+	err = store.DeleteRange(1, 3)
+	require.NoError(err)
+	requireRange(require, store, 4, 10)
+	err = store.GetLog(3, &log)
+	require.ErrorIs(err, raft.ErrLogNotFound)
+
+	err = store.DeleteRange(8, 10)
+	require.NoError(err)
+	requireRange(require, store, 4, 7)
+	err = store.StoreLog(raftLogs(8, 8)[0])
+	require.NoError(err)
+	err = store.StoreLog(raftLogs(10, 10)[0])
+	require.Error(err)
+
+	err = store.DeleteRange(4, 8)
+	require.NoError(err)
+	requireRange(require, store, 0, 0)
+	err = store.StoreLogs(raftLogs(100, 105))
+	require.NoError(err)
+	requireRange(require, store, 100, 105)
+
+	err = store.Close()
+	require.NoError(err)
+	store, err = consensus.OpenLogStore(dir)
+	require.NoError(err)
+	requireRange(require, store, 100, 105)
+	err = store.GetLog(103, &log)
+	require.NoError(err)
+	require.Equal([]byte{103}, log.Data)
+	require.NoError(store.Close())
+}
+
+func TestStableStore(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	path := t.TempDir() + "/stable.json"
+	store, err := consensus.OpenStableStore(path)
+	require.NoError(err)
+	_, err = store.GetUint64([]byte("term"))
+	require.EqualError(err, "not found")
+
+	err = store.SetUint64([]byte("term"), 5)
+	require.NoError(err)
+	err = store.Set([]byte("vote"), []byte("node-1"))
+	require.NoError(err)
+
+	store, err = consensus.OpenStableStore(path)
+	require.NoError(err)
+	term, err := store.GetUint64([]byte("term"))
+	require.NoError(err)
+	require.EqualValues(5, term)
+	vote, err := store.Get([]byte("vote"))
+	require.NoError(err)
+	require.Equal([]byte("node-1"), vote)
+}
+
+func requireRange(require *require.Assertions, store *consensus.LogStore, first uint64, last uint64) {
+	firstIndex, err := store.FirstIndex()
+	require.NoError(err)
+	require.Equal(first, firstIndex)
+	lastIndex, err := store.LastIndex()
+	require.NoError(err)
+	require.Equal(last, lastIndex)
+}
+
+func raftLogs(from uint64, to uint64) []*raft.Log {
+	logs := make([]*raft.Log, 0)
+	for i := from; i <= to; i++ {
+		logs = append(logs, &raft.Log{
+			Index:      i,
+			Term:       2,
+			Type:       raft.LogCommand,
+			Data:       []byte{byte(i)},
+			Extensions: []byte("ext"),
+			AppendedAt: time.Unix(int64(i), 0),
+		})
+	}
+	return logs
+}
@@ -0,0 +1,183 @@
+package consensus
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/raft"
+	"github.com/txix-open/walx/v2/state"
+)
+
+const (
+	logStoreDir     = "log"
+	stableStoreFile = "stable.json"
+)
+
+var (
+	ErrNotLeader = errors.New("node is not a leader")
+)
+
+type Server struct {
+	Id      string
+	Address string
+}
+
+// Node replicates the state WAL using raft. Events are appended to the state WAL only after they are
+// committed by the quorum, so State.Apply on the leader completes when the event is durable in the cluster.
+// The state becomes read only for direct writes, State.Apply on followers fails with ErrNotLeader.
+// The node stops if a committed entry can't be written to the state WAL.
+type Node struct {
+	id       string
+	raft     *raft.Raft
+	fsm      *fsm
+	logStore *LogStore
+	options  *options
+}
+
+// NewNode starts raft node storing its log and snapshots in dir.
+// The state must be recovered and running before, otherwise entries committed during startup are not applied
+func NewNode(dir string, id string, s *state.State, transport raft.Transport, opts ...Option) (*Node, error) {
+	options := newOptions()
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	config := raft.DefaultConfig()
+	config.LocalID = raft.ServerID(id)
+	config.Logger = hclog.New(&hclog.LoggerOptions{
+		Name:  fmt.Sprintf("raft/%s", id),
+		Level: hclog.Warn,
+	})
+	for _, configure := range options.configureRaft {
+		configure(config)
+	}
+
+	logStore, err := OpenLogStore(filepath.Join(dir, logStoreDir), options.logStoreOptions...)
+	if err != nil {
+		return nil, fmt.Errorf("open log store: %w", err)
+	}
+	stableStore, err := OpenStableStore(filepath.Join(dir, stableStoreFile))
+	if err != nil {
+		_ = logStore.Close()
+		return nil, fmt.Errorf("open stable store: %w", err)
+	}
+	snapshotStore, err := raft.NewFileSnapshotStoreWithLogger(dir, options.retainSnapshots, config.Logger)
+	if err != nil {
+		_ = logStore.Close()
+		return nil, fmt.Errorf("open snapshot store: %w", err)
+	}
+
+	n := &Node{
+		id:       id,
+		logStore: logStore,
+		options:  options,
+	}
+	n.fsm = newFsm(s.Log, func(err error) {
+		config.Logger.Error("stop node, committed entry can't be written to the state wal", "error", err)
+		// shutdown waits for the fsm, so it can't be called from it
+		go n.raft.Shutdown()
+	})
+	s.SetReadOnly(n)
+	n.raft, err = raft.NewRaft(config, n.fsm, logStore, stableStore, snapshotStore, transport)
+	if err != nil {
+		_ = logStore.Close()
+		return nil, fmt.Errorf("new raft: %w", err)
+	}
+	return n, nil
+}
+
+// Bootstrap initializes a new cluster with servers, it does nothing if the node already has a state
+func (n *Node) Bootstrap(servers ...Server) error {
+	configuration := raft.Configuration{}
+	for _, server := range servers {
+		configuration.Servers = append(configuration.Servers, raft.Server{
+			Suffrage: raft.Voter,
+			ID:       raft.ServerID(server.Id),
+			Address:  raft.ServerAddress(server.Address),
+		})
+	}
+	err := n.raft.BootstrapCluster(configuration).Error()
+	if errors.Is(err, raft.ErrCantBootstrap) {
+		return nil
+	}
+	return err
+}
+
+// Forward appends data to the raft log and waits until it is committed and written to the state WAL
+func (n *Node) Forward(data []byte) (uint64, error) {
+	future := n.raft.Apply(data, n.options.applyTimeout)
+	err := future.Error()
+	if errors.Is(err, raft.ErrNotLeader) {
+		return 0, ErrNotLeader
+	}
+	if err != nil {
+		return 0, fmt.Errorf("raft apply: %w", err)
+	}
+
+	switch result := future.Response().(type) {
+	case applyResult:
+		return result.index, nil
+	case error:
+		return 0, result
+	default:
+		return 0, fmt.Errorf("unexpected raft apply result %T", result)
+	}
+}
+
+func (n *Node) AddVoter(id string, address string) error {
+	return n.raft.AddVoter(raft.ServerID(id), raft.ServerAddress(address), 0, n.options.applyTimeout).Error()
+}
+
+func (n *Node) RemoveServer(id string) error {
+	return n.raft.RemoveServer(raft.ServerID(id), 0, n.options.applyTimeout).Error()
+}
+
+func (n *Node) Members() ([]Server, error) {
+	future := n.raft.GetConfiguration()
+	err := future.Error()
+	if err != nil {
+		return nil, err
+	}
+	servers := make([]Server, 0)
+	for _, server := range future.Configuration().Servers {
+		servers = append(servers, Server{
+			Id:      string(server.ID),
+			Address: string(server.Address),
+		})
+	}
+	return servers, nil
+}
+
+// Leader returns the current leader, it is empty if the leader is unknown
+func (n *Node) Leader() Server {
+	address, id := n.raft.LeaderWithID()
+	return Server{
+		Id:      string(id),
+		Address: string(address),
+	}
+}
+
+func (n *Node) IsLeader() bool {
+	return n.raft.State() == raft.Leader
+}
+
+// AppliedIndex returns the index of the last committed entry written to the state WAL
+func (n *Node) AppliedIndex() uint64 {
+	return n.fsm.applied.Load()
+}
+
+// Snapshot takes a snapshot and compacts the raft log
+func (n *Node) Snapshot() error {
+	return n.raft.Snapshot().Error()
+}
+
+// Close stops the node, the state is left read only and must be closed separately
+func (n *Node) Close() error {
+	err := n.raft.Shutdown().Error()
+	if err != nil {
+		return fmt.Errorf("shutdown raft: %w", err)
+	}
+	return n.logStore.Close()
+}
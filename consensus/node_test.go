@@ -0,0 +1,150 @@
+package consensus_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/require"
+	"github.com/txix-open/walx/v2/consensus"
+	"github.com/txix-open/walx/v2/consensus/tconsensus"
+	"github.com/txix-open/walx/v2/state"
+)
+
+type AddRequest struct {
+	Value int
+}
+
+type counter struct {
+	lock  sync.Locker
+	value int
+}
+
+func newCounter() *counter {
+	return &counter{
+		lock: &sync.Mutex{},
+	}
+}
+
+func (c *counter) SetMutator(mutator state.Mutator) {
+}
+
+func (c *counter) Apply(log state.Log) (any, error) {
+	req, err := state.UnmarshalEvent[AddRequest](log)
+	if err != nil {
+		return nil, err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.value += req.Value
+	return c.value, nil
+}
+
+func (c *counter) Value() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.value
+}
+
+func TestNode(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	cluster := tconsensus.NewCluster(t, 3, newCounter, consensus.ConfigureRaft(fastRaft))
+	leader := cluster.Leader()
+	for i := 1; i <= 5; i++ {
+		response, err := leader.State.Apply(AddRequest{Value: 1}, nil)
+		require.NoError(err)
+		require.EqualValues(i, response)
+	}
+	requireValue(t, cluster, 5)
+	for _, member := range cluster.Members() {
+		if member == leader {
+			continue
+		}
+		_, err := member.State.Apply(AddRequest{Value: 1}, nil)
+		require.ErrorIs(err, consensus.ErrNotLeader)
+	}
+
+	err := leader.Node.Snapshot()
+	require.NoError(err)
+	added := cluster.Add("node-3")
+	require.Eventually(func() bool {
+		return added.Business.Value() == 5
+	}, 10*time.Second, 10*time.Millisecond)
+	members, err := leader.Node.Members()
+	require.NoError(err)
+	require.Len(members, 4)
+
+	cluster.Stop(leader)
+	newLeader := cluster.Leader()
+	require.NotEqual(leader.Id, newLeader.Id)
+	response, err := newLeader.State.Apply(AddRequest{Value: 1}, nil)
+	require.NoError(err)
+	require.EqualValues(6, response)
+
+	restarted := cluster.Restart(leader)
+	requireValue(t, cluster, 6)
+	require.Eventually(func() bool {
+		return restarted.State.LastIndex() == newLeader.State.LastIndex()
+	}, 10*time.Second, 10*time.Millisecond)
+	require.EqualValues(6, restarted.State.LastIndex())
+	require.Equal(newLeader.Id, restarted.Node.Leader().Id)
+
+	err = newLeader.Node.RemoveServer(added.Id)
+	require.NoError(err)
+	members, err = newLeader.Node.Members()
+	require.NoError(err)
+	require.Len(members, 3)
+	require.NotContains(members, consensus.Server{Id: added.Id, Address: added.Id})
+}
+
+func TestNode_CompactedSnapshot(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	cluster := tconsensus.NewCluster(t, 3, newCounter, consensus.ConfigureRaft(fastRaft))
+	leader := cluster.Leader()
+	for range 5 {
+		_, err := leader.State.Apply(AddRequest{Value: 1}, nil)
+		require.NoError(err)
+	}
+	requireValue(t, cluster, 5)
+
+	err := leader.State.Log.TruncateFront(4)
+	require.NoError(err)
+	err = leader.Node.Snapshot()
+	require.NoError(err)
+
+	// entries before 4 can't be received, the added node must not have partial state
+	added := cluster.Add("node-3")
+	require.Never(func() bool {
+		return added.State.LastIndex() > 0 || added.Business.Value() > 0
+	}, time.Second, 10*time.Millisecond)
+
+	response, err := leader.State.Apply(AddRequest{Value: 1}, nil)
+	require.NoError(err)
+	require.EqualValues(6, response)
+}
+
+func requireValue(t *testing.T, cluster *tconsensus.Cluster[*counter], value int) {
+	t.Helper()
+
+	for _, member := range cluster.Members() {
+		require.Eventually(t, func() bool {
+			return member.Business.Value() == value
+		}, 10*time.Second, 10*time.Millisecond, member.Id)
+	}
+}
+
+func fastRaft(config *raft.Config) {
+	config.HeartbeatTimeout = 100 * time.Millisecond
+	config.ElectionTimeout = 100 * time.Millisecond
+	config.LeaderLeaseTimeout = 50 * time.Millisecond
+	config.CommitTimeout = 5 * time.Millisecond
+	config.TrailingLogs = 1
+}
@@ -0,0 +1,50 @@
+package consensus
+
+import (
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/txix-open/walx/v2"
+)
+
+type options struct {
+	applyTimeout    time.Duration
+	retainSnapshots int
+	logStoreOptions []walx.Option
+	configureRaft   []func(config *raft.Config)
+}
+
+func newOptions() *options {
+	return &options{
+		applyTimeout:    10 * time.Second,
+		retainSnapshots: 2,
+	}
+}
+
+type Option func(o *options)
+
+// ApplyTimeout limits the time to wait for the entry to be committed by the quorum
+func ApplyTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.applyTimeout = timeout
+	}
+}
+
+func RetainSnapshots(count int) Option {
+	return func(o *options) {
+		o.retainSnapshots = count
+	}
+}
+
+func WithLogStoreOptions(opts ...walx.Option) Option {
+	return func(o *options) {
+		o.logStoreOptions = append(o.logStoreOptions, opts...)
+	}
+}
+
+// ConfigureRaft changes raft config, e.g. timeouts and snapshot thresholds
+func ConfigureRaft(configure func(config *raft.Config)) Option {
+	return func(o *options) {
+		o.configureRaft = append(o.configureRaft, configure)
+	}
+}
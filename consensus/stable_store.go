@@ -0,0 +1,80 @@
+package consensus
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+var (
+	// errKeyNotFound message is checked by raft
+	errKeyNotFound = errors.New("not found")
+)
+
+// StableStore is raft.StableStore keeping values in a json file rewritten atomically on every change
+type StableStore struct {
+	path   string
+	values map[string][]byte
+	lock   sync.Locker
+}
+
+func OpenStableStore(path string) (*StableStore, error) {
+	s := &StableStore{
+		path:   path,
+		values: make(map[string][]byte),
+		lock:   &sync.Mutex{},
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read stable store: %w", err)
+	}
+	err = json.Unmarshal(data, &s.values)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal stable store: %w", err)
+	}
+	return s, nil
+}
+
+func (s *StableStore) Set(key []byte, value []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.values[string(key)] = append([]byte(nil), value...)
+	data, err := json.Marshal(s.values)
+	if err != nil {
+		return fmt.Errorf("marshal stable store: %w", err)
+	}
+	return writeFileSync(s.path, data)
+}
+
+func (s *StableStore) Get(key []byte) ([]byte, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	value, ok := s.values[string(key)]
+	if !ok {
+		return nil, errKeyNotFound
+	}
+	return append([]byte(nil), value...), nil
+}
+
+func (s *StableStore) SetUint64(key []byte, value uint64) error {
+	return s.Set(key, binary.BigEndian.AppendUint64(nil, value))
+}
+
+func (s *StableStore) GetUint64(key []byte) (uint64, error) {
+	value, err := s.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	if len(value) != 8 {
+		return 0, fmt.Errorf("invalid uint64 value of key %s", key)
+	}
+	return binary.BigEndian.Uint64(value), nil
+}
@@ -0,0 +1,173 @@
+package tconsensus
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/require"
+	"github.com/txix-open/walx/v2"
+	"github.com/txix-open/walx/v2/consensus"
+	"github.com/txix-open/walx/v2/state"
+	"github.com/txix-open/walx/v2/state/codec/json"
+)
+
+type Member[T state.BusinessState] struct {
+	Id       string
+	Business T
+	State    *state.State
+	Node     *consensus.Node
+
+	dir       string
+	transport *raft.InmemTransport
+	stopped   bool
+}
+
+// Cluster runs raft nodes in-process connected with in-memory transport
+type Cluster[T state.BusinessState] struct {
+	t        testing.TB
+	name     string
+	newState func() T
+	opts     []consensus.Option
+	members  []*Member[T]
+	lock     sync.Locker
+}
+
+// NewCluster bootstraps the cluster of size members, each member has its own business state created by newState
+func NewCluster[T state.BusinessState](t testing.TB, size int, newState func() T, opts ...consensus.Option) *Cluster[T] {
+	t.Helper()
+
+	c := &Cluster[T]{
+		t:        t,
+		name:     t.Name(),
+		newState: newState,
+		opts:     opts,
+		lock:     &sync.Mutex{},
+	}
+	servers := make([]consensus.Server, 0, size)
+	for i := range size {
+		member := c.start(fmt.Sprintf("node-%d", i), t.TempDir())
+		servers = append(servers, consensus.Server{Id: member.Id, Address: member.Id})
+	}
+	err := c.members[0].Node.Bootstrap(servers...)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		for _, member := range c.Members() {
+			c.Stop(member)
+		}
+	})
+	return c
+}
+
+func (c *Cluster[T]) Members() []*Member[T] {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return append([]*Member[T]{}, c.members...)
+}
+
+// Leader waits until the leader is elected among running members
+func (c *Cluster[T]) Leader() *Member[T] {
+	c.t.Helper()
+
+	var leader *Member[T]
+	require.Eventually(c.t, func() bool {
+		for _, member := range c.Members() {
+			if !member.stopped && member.Node.IsLeader() {
+				leader = member
+				return true
+			}
+		}
+		return false
+	}, 10*time.Second, 10*time.Millisecond)
+	return leader
+}
+
+// Add starts a new member and adds it to the cluster as a voter
+func (c *Cluster[T]) Add(id string) *Member[T] {
+	c.t.Helper()
+
+	member := c.start(id, c.t.TempDir())
+	err := c.Leader().Node.AddVoter(member.Id, member.Id)
+	require.NoError(c.t, err)
+	return member
+}
+
+// Stop shuts the member down keeping its data, so it can be restarted
+func (c *Cluster[T]) Stop(member *Member[T]) {
+	c.t.Helper()
+
+	if member.stopped {
+		return
+	}
+	member.stopped = true
+	member.transport.DisconnectAll()
+	for _, other := range c.Members() {
+		other.transport.Disconnect(raft.ServerAddress(member.Id))
+	}
+	err := member.Node.Close()
+	require.NoError(c.t, err)
+	err = member.State.Close()
+	require.NoError(c.t, err)
+}
+
+// Restart starts the stopped member from its data
+func (c *Cluster[T]) Restart(member *Member[T]) *Member[T] {
+	c.t.Helper()
+
+	c.lock.Lock()
+	for i, m := range c.members {
+		if m == member {
+			c.members = append(c.members[:i], c.members[i+1:]...)
+			break
+		}
+	}
+	c.lock.Unlock()
+	return c.start(member.Id, member.dir)
+}
+
+func (c *Cluster[T]) start(id string, dir string) *Member[T] {
+	c.t.Helper()
+	require := require.New(c.t)
+
+	log, err := walx.Open(filepath.Join(dir, "wal"))
+	require.NoError(err)
+	business := c.newState()
+	s := state.New(log, business, json.NewCodec(), c.name)
+	business.SetMutator(s)
+	err = s.Recovery(context.Background())
+	require.NoError(err)
+	go func() {
+		_ = s.Run(context.Background())
+	}()
+	time.Sleep(100 * time.Millisecond) // we must run state before raft starts applying entries
+
+	_, transport := raft.NewInmemTransport(raft.ServerAddress(id))
+	node, err := consensus.NewNode(filepath.Join(dir, "raft"), id, s, transport, c.opts...)
+	require.NoError(err)
+
+	member := &Member[T]{
+		Id:        id,
+		Business:  business,
+		State:     s,
+		Node:      node,
+		dir:       dir,
+		transport: transport,
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, other := range c.members {
+		if other.stopped {
+			continue
+		}
+		other.transport.Connect(raft.ServerAddress(id), transport)
+		transport.Connect(raft.ServerAddress(other.Id), other.transport)
+	}
+	c.members = append(c.members, member)
+	return member
+}
@@ -0,0 +1,247 @@
+package admin
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/txix-open/walx/v2/crud"
+	"github.com/txix-open/walx/v2/state"
+)
+
+const (
+	defaultLimit = 100
+	maxLimit     = 1000
+)
+
+type errorResponse struct {
+	Error string
+}
+
+type listResponse[T any] struct {
+	Items []T
+	Next  string `json:",omitempty"`
+}
+
+// Admin serves JSON API over registered crud states:
+//
+//	GET    /{state}/items?limit=&after=  list items ordered by id
+//	GET    /{state}/items/{id}           get item, version is returned in ETag
+//	POST   /{state}/items                insert item
+//	PUT    /{state}/items/{id}           update item, or upsert with ?upsert=true
+//	DELETE /{state}/items/{id}           delete item
+//
+// PUT and DELETE respect If-Match header with the version from ETag, upsert doesn't accept it.
+// Bodies are encoded with the state codec, so items have the same shape as in the WAL
+type Admin struct {
+	mux    *http.ServeMux
+	codec  state.Codec
+	states []string
+}
+
+func New(codec state.Codec) *Admin {
+	a := &Admin{
+		mux:   http.NewServeMux(),
+		codec: codec,
+	}
+	a.mux.HandleFunc("GET /{$}", a.list)
+	return a
+}
+
+func (a *Admin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mux.ServeHTTP(w, r)
+}
+
+func (a *Admin) list(w http.ResponseWriter, _ *http.Request) {
+	writeJson(w, a.codec, http.StatusOK, a.states)
+}
+
+func Register[T crud.WithId](a *Admin, s *crud.State[T]) {
+	h := handler[T]{state: s, codec: a.codec}
+	prefix := "/" + s.StateName() + "/items"
+	a.mux.HandleFunc("GET "+prefix, h.list)
+	a.mux.HandleFunc("POST "+prefix, h.insert)
+	a.mux.HandleFunc("GET "+prefix+"/{id}", h.get)
+	a.mux.HandleFunc("PUT "+prefix+"/{id}", h.update)
+	a.mux.HandleFunc("DELETE "+prefix+"/{id}", h.delete)
+	a.states = append(a.states, s.StateName())
+}
+
+type handler[T crud.WithId] struct {
+	state *crud.State[T]
+	codec state.Codec
+}
+
+func (h handler[T]) list(w http.ResponseWriter, r *http.Request) {
+	limit := defaultLimit
+	rawLimit := r.URL.Query().Get("limit")
+	if rawLimit != "" {
+		value, err := strconv.Atoi(rawLimit)
+		if err != nil || value <= 0 || value > maxLimit {
+			h.writeError(w, http.StatusBadRequest, fmt.Errorf("limit must be in range [1, %d]", maxLimit))
+			return
+		}
+		limit = value
+	}
+
+	query := h.state.Query().Limit(limit + 1)
+	after := r.URL.Query().Get("after")
+	if after != "" {
+		query = query.Where(crud.Match(func(item *T) bool {
+			return (*item).GetId() > after
+		}))
+	}
+	items, err := query.Items()
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	response := listResponse[T]{
+		Items: make([]T, 0, limit),
+	}
+	for item := range items {
+		if len(response.Items) == limit {
+			response.Next = response.Items[limit-1].GetId()
+			break
+		}
+		response.Items = append(response.Items, item)
+	}
+	writeJson(w, h.codec, http.StatusOK, response)
+}
+
+func (h handler[T]) get(w http.ResponseWriter, r *http.Request) {
+	item, version := h.state.GetWithVersion(r.PathValue("id"))
+	if item == nil {
+		h.writeError(w, http.StatusNotFound, crud.ErrNotFound)
+		return
+	}
+	w.Header().Set("ETag", formatVersion(version))
+	writeJson(w, h.codec, http.StatusOK, item)
+}
+
+func (h handler[T]) insert(w http.ResponseWriter, r *http.Request) {
+	item, ok := h.readItem(w, r)
+	if !ok {
+		return
+	}
+	err := h.state.Insert(item)
+	if err != nil {
+		h.writeStateError(w, err)
+		return
+	}
+	writeJson(w, h.codec, http.StatusCreated, item)
+}
+
+func (h handler[T]) update(w http.ResponseWriter, r *http.Request) {
+	item, ok := h.readItem(w, r)
+	if !ok {
+		return
+	}
+	if item.GetId() != r.PathValue("id") {
+		h.writeError(w, http.StatusBadRequest, errors.New("item id does not match path"))
+		return
+	}
+	version, ok := h.readVersion(w, r)
+	if !ok {
+		return
+	}
+	upsert := r.URL.Query().Get("upsert") == "true"
+	if upsert && version != 0 {
+		h.writeError(w, http.StatusBadRequest, errors.New("If-Match header is not supported with upsert"))
+		return
+	}
+
+	var err error
+	switch {
+	case upsert:
+		err = h.state.Upsert(item)
+	default:
+		err = h.state.UpdateIfVersion(item, version)
+	}
+	if err != nil {
+		h.writeStateError(w, err)
+		return
+	}
+	writeJson(w, h.codec, http.StatusOK, item)
+}
+
+func (h handler[T]) delete(w http.ResponseWriter, r *http.Request) {
+	version, ok := h.readVersion(w, r)
+	if !ok {
+		return
+	}
+	item, err := h.state.DeleteIfVersion(r.PathValue("id"), version)
+	if err != nil {
+		h.writeStateError(w, err)
+		return
+	}
+	writeJson(w, h.codec, http.StatusOK, item)
+}
+
+func (h handler[T]) readItem(w http.ResponseWriter, r *http.Request) (T, bool) {
+	var item T
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, fmt.Errorf("read item: %w", err))
+		return item, false
+	}
+	err = h.codec.Decode(data, &item)
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, fmt.Errorf("decode item: %w", err))
+		return item, false
+	}
+	if item.GetId() == "" {
+		h.writeError(w, http.StatusBadRequest, errors.New("item id is required"))
+		return item, false
+	}
+	return item, true
+}
+
+// readVersion returns version from If-Match header or zero if it is not set
+func (h handler[T]) readVersion(w http.ResponseWriter, r *http.Request) (uint64, bool) {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return 0, true
+	}
+	version, err := strconv.ParseUint(strings.Trim(header, `"`), 10, 64)
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, errors.New("invalid If-Match header"))
+		return 0, false
+	}
+	return version, true
+}
+
+func formatVersion(version uint64) string {
+	return strconv.Quote(strconv.FormatUint(version, 10))
+}
+
+func (h handler[T]) writeStateError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, crud.ErrNotFound):
+		h.writeError(w, http.StatusNotFound, err)
+	case errors.Is(err, crud.ErrAlreadyExists), errors.Is(err, crud.ErrConstraintViolation):
+		h.writeError(w, http.StatusConflict, err)
+	case errors.Is(err, crud.ErrVersionConflict):
+		h.writeError(w, http.StatusPreconditionFailed, err)
+	case errors.Is(err, state.ErrReadOnly),
+		errors.Is(err, state.ErrForwardTimeout),
+		errors.Is(err, state.ErrForwardCanceled):
+		h.writeError(w, http.StatusServiceUnavailable, err)
+	default:
+		h.writeError(w, http.StatusInternalServerError, err)
+	}
+}
+
+func (h handler[T]) writeError(w http.ResponseWriter, status int, err error) {
+	writeJson(w, h.codec, status, errorResponse{Error: err.Error()})
+}
+
+func writeJson(w http.ResponseWriter, codec state.Codec, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = codec.Encode(w, value)
+}
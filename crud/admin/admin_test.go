@@ -0,0 +1,124 @@
+package admin_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/txix-open/walx/v2/crud"
+	"github.com/txix-open/walx/v2/crud/admin"
+	"github.com/txix-open/walx/v2/state"
+	"github.com/txix-open/walx/v2/state/codec/json"
+	"github.com/txix-open/walx/v2/tstate"
+)
+
+type User struct {
+	Id   string
+	Name string
+}
+
+func (u User) GetId() string {
+	return u.Id
+}
+
+type Group struct {
+	Id string
+}
+
+func (g Group) GetId() string {
+	return g.Id
+}
+
+type client struct {
+	t       *testing.T
+	handler http.Handler
+}
+
+func (c client) do(method string, path string, body any, headers ...string) *httptest.ResponseRecorder {
+	c.t.Helper()
+
+	reader := bytes.NewReader(nil)
+	if body != nil {
+		data, err := state.MarshalEvent(json.NewCodec(), body)
+		require.NoError(c.t, err)
+		reader = bytes.NewReader(data)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	recorder := httptest.NewRecorder()
+	c.handler.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestAdmin(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	users := crud.New[User]("users")
+	groups := crud.New[Group]("groups")
+	_, s := tstate.ServeStateWithState(t, state.ComposeV2(users, groups), t.Name())
+	handler := admin.New(json.NewCodec())
+	admin.Register(handler, users)
+	admin.Register(handler, groups)
+	c := client{t: t, handler: handler}
+
+	resp := c.do(http.MethodGet, "/", nil)
+	require.EqualValues(http.StatusOK, resp.Code)
+	require.JSONEq(`["users", "groups"]`, resp.Body.String())
+
+	resp = c.do(http.MethodPost, "/users/items", User{Id: "1", Name: "John"})
+	require.EqualValues(http.StatusCreated, resp.Code)
+	resp = c.do(http.MethodPost, "/users/items", User{Id: "1", Name: "John"})
+	require.EqualValues(http.StatusConflict, resp.Code)
+	resp = c.do(http.MethodPost, "/users/items", User{Name: "John"})
+	require.EqualValues(http.StatusBadRequest, resp.Code)
+
+	resp = c.do(http.MethodGet, "/users/items/1", nil)
+	require.EqualValues(http.StatusOK, resp.Code)
+	require.JSONEq(`{"id": "1", "name": "John"}`, resp.Body.String())
+	etag := resp.Header().Get("ETag")
+	require.NotEmpty(etag)
+	resp = c.do(http.MethodGet, "/users/items/2", nil)
+	require.EqualValues(http.StatusNotFound, resp.Code)
+	resp = c.do(http.MethodGet, "/groups/items/1", nil)
+	require.EqualValues(http.StatusNotFound, resp.Code)
+
+	resp = c.do(http.MethodPut, "/users/items/2", User{Id: "2", Name: "Jane"})
+	require.EqualValues(http.StatusNotFound, resp.Code)
+	resp = c.do(http.MethodPut, "/users/items/2?upsert=true", User{Id: "2", Name: "Jane"})
+	require.EqualValues(http.StatusOK, resp.Code)
+	resp = c.do(http.MethodPut, "/users/items/1", User{Id: "2"})
+	require.EqualValues(http.StatusBadRequest, resp.Code)
+
+	resp = c.do(http.MethodPut, "/users/items/1", User{Id: "1", Name: "Bob"}, "If-Match", etag)
+	require.EqualValues(http.StatusOK, resp.Code)
+	resp = c.do(http.MethodPut, "/users/items/1", User{Id: "1", Name: "Alice"}, "If-Match", etag)
+	require.EqualValues(http.StatusPreconditionFailed, resp.Code)
+	require.EqualValues("Bob", users.Get("1").Name)
+	resp = c.do(http.MethodPut, "/users/items/1?upsert=true", User{Id: "1", Name: "Alice"}, "If-Match", etag)
+	require.EqualValues(http.StatusBadRequest, resp.Code)
+	require.EqualValues("Bob", users.Get("1").Name)
+
+	resp = c.do(http.MethodGet, "/users/items?limit=1", nil)
+	require.EqualValues(http.StatusOK, resp.Code)
+	require.JSONEq(`{"items": [{"id": "1", "name": "Bob"}], "next": "1"}`, resp.Body.String())
+	resp = c.do(http.MethodGet, "/users/items?limit=1&after=1", nil)
+	require.JSONEq(`{"items": [{"id": "2", "name": "Jane"}]}`, resp.Body.String())
+	resp = c.do(http.MethodGet, "/users/items?limit=0", nil)
+	require.EqualValues(http.StatusBadRequest, resp.Code)
+
+	resp = c.do(http.MethodDelete, "/users/items/1", nil)
+	require.EqualValues(http.StatusOK, resp.Code)
+	require.JSONEq(`{"id": "1", "name": "Bob"}`, resp.Body.String())
+	resp = c.do(http.MethodDelete, "/users/items/1", nil)
+	require.EqualValues(http.StatusNotFound, resp.Code)
+
+	s.SetReadOnly(nil)
+	resp = c.do(http.MethodPost, "/users/items", User{Id: "3", Name: "Tom"})
+	require.EqualValues(http.StatusServiceUnavailable, resp.Code)
+	require.Contains(resp.Body.String(), `"error"`)
+}
@@ -0,0 +1,84 @@
+package aggregate
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/txix-open/walx/v2/crud"
+	"github.com/txix-open/walx/v2/metric"
+)
+
+// All groups items into the single group with empty key
+func All[T crud.WithId](*T) string {
+	return ""
+}
+
+type accumulator[T crud.WithId] interface {
+	add(item *T)
+	remove(item *T)
+}
+
+// hooks keeps accumulator up to date: updated items are removed with their old values and added with new ones
+func hooks[T crud.WithId](lock sync.Locker, acc accumulator[T]) crud.Hooks[T] {
+	apply := func(old *T, item *T) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		if old != nil {
+			acc.remove(old)
+		}
+		if item != nil {
+			acc.add(item)
+		}
+	}
+	return crud.Hooks[T]{
+		InsertHooks: []crud.InsertHook[T]{func(item *T, inserted bool) {
+			if inserted {
+				apply(nil, item)
+			}
+		}},
+		UpdateHooks: []crud.UpdateHook[T]{func(old *T, item *T, updated bool) {
+			if updated {
+				apply(old, item)
+			}
+		}},
+		UpsertHooks: []crud.UpsertHook[T]{func(old *T, item *T, updated bool) {
+			apply(old, item)
+		}},
+		DeleteHooks: []crud.DeleteHook[T]{func(item *T, deleted bool, _ crud.DeleteReason) {
+			if deleted {
+				apply(item, nil)
+			}
+		}},
+	}
+}
+
+func newMetric(name string, description string, label string, values func() map[string]float64) metric.Metric {
+	labels := make([]string, 0)
+	if label != "" {
+		labels = append(labels, label)
+	}
+	return metric.Metric{
+		Name:        name,
+		Description: description,
+		Labels:      labels,
+		Collect: func() []metric.Value {
+			byGroup := values()
+			groups := make([]string, 0, len(byGroup))
+			for group := range byGroup {
+				groups = append(groups, group)
+			}
+			sort.Strings(groups)
+
+			result := make([]metric.Value, 0, len(groups))
+			for _, group := range groups {
+				value := metric.Value{Value: byGroup[group]}
+				if label != "" {
+					value.Labels = []string{group}
+				}
+				result = append(result, value)
+			}
+			return result
+		},
+	}
+}
@@ -0,0 +1,144 @@
+package aggregate_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/txix-open/walx/v2/crud"
+	"github.com/txix-open/walx/v2/crud/aggregate"
+	"github.com/txix-open/walx/v2/metric"
+	"github.com/txix-open/walx/v2/tstate"
+)
+
+type Order struct {
+	Id     string
+	Status string
+	Amount float64
+}
+
+func (o Order) GetId() string {
+	return o.Id
+}
+
+func status(o *Order) string {
+	return o.Status
+}
+
+func amount(o *Order) float64 {
+	return o.Amount
+}
+
+func TestAggregates(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	state := crud.New[Order]("orders")
+	count := aggregate.NewCount[Order](status)
+	total := aggregate.NewSum[Order](aggregate.All[Order], amount)
+	totalByStatus := aggregate.NewSum[Order](status, amount)
+	minAmount := aggregate.NewMin[Order](status, amount)
+	maxAmount := aggregate.NewMax[Order](status, amount)
+	state.SetHooks(crud.MergeHooks(count.Hooks(), total.Hooks(), totalByStatus.Hooks(), minAmount.Hooks(), maxAmount.Hooks()))
+	tstate.ServeState(t, state)
+
+	err := state.Insert(Order{Id: "1", Status: "new", Amount: 10})
+	require.NoError(err)
+	err = state.Insert(Order{Id: "2", Status: "new", Amount: 30})
+	require.NoError(err)
+	err = state.Insert(Order{Id: "3", Status: "paid", Amount: 20})
+	require.NoError(err)
+	err = state.Insert(Order{Id: "3", Status: "paid", Amount: 100})
+	require.ErrorIs(err, crud.ErrAlreadyExists)
+
+	require.Equal(map[string]int{"new": 2, "paid": 1}, count.All())
+	require.InDelta(60, total.Get(""), 1e-9)
+	requireValue(t, minAmount, "new", 10)
+	requireValue(t, maxAmount, "new", 30)
+	requireValue(t, maxAmount, "paid", 20)
+
+	err = state.Update(Order{Id: "2", Status: "paid", Amount: 5})
+	require.NoError(err)
+	require.Equal(map[string]int{"new": 1, "paid": 2}, count.All())
+	require.InDelta(35, total.Get(""), 1e-9)
+	requireValue(t, maxAmount, "new", 10)
+	requireValue(t, minAmount, "paid", 5)
+	requireValue(t, maxAmount, "paid", 20)
+
+	err = state.Upsert(Order{Id: "3", Status: "paid", Amount: 1})
+	require.NoError(err)
+	requireValue(t, maxAmount, "paid", 5)
+	requireValue(t, minAmount, "paid", 1)
+
+	_, err = state.Delete("1")
+	require.NoError(err)
+	require.Equal(map[string]int{"paid": 2}, count.All())
+	require.NotContains(totalByStatus.All(), "new")
+	_, ok := minAmount.Get("new")
+	require.False(ok)
+
+	err = state.DeleteAll()
+	require.NoError(err)
+	require.Equal(0, count.Get("paid"))
+	require.Empty(count.All())
+	require.Empty(total.All())
+	require.Empty(totalByStatus.All())
+	require.Empty(maxAmount.All())
+}
+
+func TestAggregateMetric(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	state := crud.New[Order]("orders")
+	count := aggregate.NewCount[Order](status)
+	total := aggregate.NewSum[Order](aggregate.All[Order], amount)
+	state.SetHooks(crud.MergeHooks(count.Hooks(), total.Hooks()))
+	tstate.ServeState(t, state)
+
+	err := state.BulkUpsert([]Order{
+		{Id: "1", Status: "paid", Amount: 1.5},
+		{Id: "2", Status: "new", Amount: 2},
+		{Id: "3", Status: "paid", Amount: 3},
+	})
+	require.NoError(err)
+
+	m := count.Metric("orders_count", "Orders by status", "status")
+	require.Equal([]string{"status"}, m.Labels)
+	require.Equal([]metric.Value{
+		{Labels: []string{"new"}, Value: 1},
+		{Labels: []string{"paid"}, Value: 2},
+	}, m.Collect())
+
+	m = total.Metric("orders_amount", "Total amount of orders", "")
+	require.Empty(m.Labels)
+	require.Equal([]metric.Value{{Value: 6.5}}, m.Collect())
+}
+
+func requireValue(t *testing.T, extreme *aggregate.Extreme[Order], group string, expected float64) {
+	t.Helper()
+
+	value, ok := extreme.Get(group)
+	require.True(t, ok)
+	require.InDelta(t, expected, value, 1e-9)
+}
+
+func TestSum_Residue(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	state := crud.New[Order]("orders")
+	total := aggregate.NewSum[Order](status, amount)
+	state.SetHooks(total.Hooks())
+	tstate.ServeState(t, state)
+
+	err := state.BulkUpsert([]Order{
+		{Id: "1", Status: "new", Amount: 0.1},
+		{Id: "2", Status: "new", Amount: 0.2},
+	})
+	require.NoError(err)
+	_, err = state.Delete("2")
+	require.NoError(err)
+	_, err = state.Delete("1")
+	require.NoError(err)
+	require.Empty(total.All())
+}
@@ -0,0 +1,68 @@
+package aggregate
+
+import (
+	"maps"
+	"sync"
+
+	"github.com/txix-open/walx/v2/crud"
+	"github.com/txix-open/walx/v2/metric"
+)
+
+type Count[T crud.WithId] struct {
+	groupBy   func(item *T) string
+	counts    map[string]int
+	readLock  sync.Locker
+	writeLock sync.Locker
+}
+
+func NewCount[T crud.WithId](groupBy func(item *T) string) *Count[T] {
+	mu := &sync.RWMutex{}
+	return &Count[T]{
+		groupBy:   groupBy,
+		counts:    make(map[string]int),
+		readLock:  mu.RLocker(),
+		writeLock: mu,
+	}
+}
+
+func (c *Count[T]) Get(group string) int {
+	c.readLock.Lock()
+	defer c.readLock.Unlock()
+
+	return c.counts[group]
+}
+
+// All returns counts by group, groups without items are omitted
+func (c *Count[T]) All() map[string]int {
+	c.readLock.Lock()
+	defer c.readLock.Unlock()
+
+	return maps.Clone(c.counts)
+}
+
+func (c *Count[T]) Hooks() crud.Hooks[T] {
+	return hooks[T](c.writeLock, c)
+}
+
+// Metric exposes counts for metric.Collector, label is omitted for ungrouped aggregation
+func (c *Count[T]) Metric(name string, description string, label string) metric.Metric {
+	return newMetric(name, description, label, func() map[string]float64 {
+		result := make(map[string]float64)
+		for group, count := range c.All() {
+			result[group] = float64(count)
+		}
+		return result
+	})
+}
+
+func (c *Count[T]) add(item *T) {
+	c.counts[c.groupBy(item)]++
+}
+
+func (c *Count[T]) remove(item *T) {
+	group := c.groupBy(item)
+	c.counts[group]--
+	if c.counts[group] <= 0 {
+		delete(c.counts, group)
+	}
+}
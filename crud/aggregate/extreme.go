@@ -0,0 +1,157 @@
+package aggregate
+
+import (
+	"container/heap"
+	"sync"
+
+	"github.com/txix-open/walx/v2/crud"
+	"github.com/txix-open/walx/v2/metric"
+)
+
+type heapEntry struct {
+	id    string
+	value float64
+	index int
+}
+
+type valueHeap struct {
+	entries []*heapEntry
+	less    func(a float64, b float64) bool
+}
+
+func (h *valueHeap) Len() int {
+	return len(h.entries)
+}
+
+func (h *valueHeap) Less(i, j int) bool {
+	return h.less(h.entries[i].value, h.entries[j].value)
+}
+
+func (h *valueHeap) Swap(i, j int) {
+	h.entries[i], h.entries[j] = h.entries[j], h.entries[i]
+	h.entries[i].index = i
+	h.entries[j].index = j
+}
+
+func (h *valueHeap) Push(x any) {
+	entry := x.(*heapEntry)
+	entry.index = len(h.entries)
+	h.entries = append(h.entries, entry)
+}
+
+func (h *valueHeap) Pop() any {
+	last := len(h.entries) - 1
+	entry := h.entries[last]
+	h.entries[last] = nil
+	h.entries = h.entries[:last]
+	return entry
+}
+
+// Extreme tracks minimum or maximum value by group using a heap per group,
+// so removing the current extreme does not require rescanning items
+type Extreme[T crud.WithId] struct {
+	groupBy   func(item *T) string
+	value     func(item *T) float64
+	less      func(a float64, b float64) bool
+	heaps     map[string]*valueHeap
+	entries   map[string]*heapEntry
+	readLock  sync.Locker
+	writeLock sync.Locker
+}
+
+func NewMin[T crud.WithId](groupBy func(item *T) string, value func(item *T) float64) *Extreme[T] {
+	return newExtreme(groupBy, value, func(a float64, b float64) bool {
+		return a < b
+	})
+}
+
+func NewMax[T crud.WithId](groupBy func(item *T) string, value func(item *T) float64) *Extreme[T] {
+	return newExtreme(groupBy, value, func(a float64, b float64) bool {
+		return a > b
+	})
+}
+
+func newExtreme[T crud.WithId](
+	groupBy func(item *T) string,
+	value func(item *T) float64,
+	less func(a float64, b float64) bool,
+) *Extreme[T] {
+	mu := &sync.RWMutex{}
+	return &Extreme[T]{
+		groupBy:   groupBy,
+		value:     value,
+		less:      less,
+		heaps:     make(map[string]*valueHeap),
+		entries:   make(map[string]*heapEntry),
+		readLock:  mu.RLocker(),
+		writeLock: mu,
+	}
+}
+
+// Get returns the extreme value of the group, false means the group is empty
+func (e *Extreme[T]) Get(group string) (float64, bool) {
+	e.readLock.Lock()
+	defer e.readLock.Unlock()
+
+	h, ok := e.heaps[group]
+	if !ok {
+		return 0, false
+	}
+	return h.entries[0].value, true
+}
+
+// All returns extreme values of non-empty groups
+func (e *Extreme[T]) All() map[string]float64 {
+	e.readLock.Lock()
+	defer e.readLock.Unlock()
+
+	result := make(map[string]float64, len(e.heaps))
+	for group, h := range e.heaps {
+		result[group] = h.entries[0].value
+	}
+	return result
+}
+
+func (e *Extreme[T]) Hooks() crud.Hooks[T] {
+	return hooks[T](e.writeLock, e)
+}
+
+// Metric exposes extreme values for metric.Collector, label is omitted for ungrouped aggregation
+func (e *Extreme[T]) Metric(name string, description string, label string) metric.Metric {
+	return newMetric(name, description, label, e.All)
+}
+
+func (e *Extreme[T]) add(item *T) {
+	group := e.groupBy(item)
+	h, ok := e.heaps[group]
+	if !ok {
+		h = &valueHeap{less: e.less}
+		e.heaps[group] = h
+	}
+	entry := &heapEntry{
+		id:    (*item).GetId(),
+		value: e.value(item),
+	}
+	e.entries[entryKey(group, entry.id)] = entry
+	heap.Push(h, entry)
+}
+
+func (e *Extreme[T]) remove(item *T) {
+	group := e.groupBy(item)
+	key := entryKey(group, (*item).GetId())
+	entry, ok := e.entries[key]
+	if !ok {
+		return
+	}
+	delete(e.entries, key)
+
+	h := e.heaps[group]
+	heap.Remove(h, entry.index)
+	if h.Len() == 0 {
+		delete(e.heaps, group)
+	}
+}
+
+func entryKey(group string, id string) string {
+	return group + "\x00" + id
+}
@@ -0,0 +1,71 @@
+package aggregate
+
+import (
+	"maps"
+	"sync"
+
+	"github.com/txix-open/walx/v2/crud"
+	"github.com/txix-open/walx/v2/metric"
+)
+
+type Sum[T crud.WithId] struct {
+	groupBy   func(item *T) string
+	value     func(item *T) float64
+	sums      map[string]float64
+	counts    map[string]int
+	readLock  sync.Locker
+	writeLock sync.Locker
+}
+
+func NewSum[T crud.WithId](groupBy func(item *T) string, value func(item *T) float64) *Sum[T] {
+	mu := &sync.RWMutex{}
+	return &Sum[T]{
+		groupBy:   groupBy,
+		value:     value,
+		sums:      make(map[string]float64),
+		counts:    make(map[string]int),
+		readLock:  mu.RLocker(),
+		writeLock: mu,
+	}
+}
+
+func (s *Sum[T]) Get(group string) float64 {
+	s.readLock.Lock()
+	defer s.readLock.Unlock()
+
+	return s.sums[group]
+}
+
+// All returns sums by group, groups without items are omitted
+func (s *Sum[T]) All() map[string]float64 {
+	s.readLock.Lock()
+	defer s.readLock.Unlock()
+
+	return maps.Clone(s.sums)
+}
+
+func (s *Sum[T]) Hooks() crud.Hooks[T] {
+	return hooks[T](s.writeLock, s)
+}
+
+// Metric exposes sums for metric.Collector, label is omitted for ungrouped aggregation
+func (s *Sum[T]) Metric(name string, description string, label string) metric.Metric {
+	return newMetric(name, description, label, s.All)
+}
+
+func (s *Sum[T]) add(item *T) {
+	group := s.groupBy(item)
+	s.sums[group] += s.value(item)
+	s.counts[group]++
+}
+
+func (s *Sum[T]) remove(item *T) {
+	group := s.groupBy(item)
+	s.sums[group] -= s.value(item)
+	s.counts[group]--
+	// items are counted separately, float subtraction may not return the sum to exact zero
+	if s.counts[group] <= 0 {
+		delete(s.sums, group)
+		delete(s.counts, group)
+	}
+}
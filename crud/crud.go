@@ -1,141 +1,233 @@
 package crud
 
 import (
+	"encoding/json"
 	"errors"
-	"sync"
+	"fmt"
+	"sync/atomic"
+	"time"
 
 	"github.com/txix-open/walx/v2/state"
 )
 
 var (
-	ErrNotFound      = errors.New("not found")
-	ErrAlreadyExists = errors.New("already exists")
+	ErrNotFound            = errors.New("not found")
+	ErrAlreadyExists       = errors.New("already exists")
+	ErrConstraintViolation = errors.New("constraint violation")
+	ErrVersionConflict     = errors.New("version conflict")
+	ErrNoIdGenerator       = errors.New("id generator is not set")
 )
 
-type UpdateHook[T WithId] func(t *T, updated bool)
+type ConstraintViolationError struct {
+	Constraint string
+	Key        string
+	Id         string
+	ConflictId string
+}
+
+func (e ConstraintViolationError) Error() string {
+	return fmt.Sprintf(
+		"%s: '%s' key '%s' of item '%s' is already used by item '%s'",
+		ErrConstraintViolation, e.Constraint, e.Key, e.Id, e.ConflictId,
+	)
+}
+
+func (e ConstraintViolationError) Is(target error) bool {
+	return target == ErrConstraintViolation
+}
+
+// old is the previously stored item or nil if there was no item with the same id
+type UpdateHook[T WithId] func(old *T, t *T, updated bool)
 type InsertHook[T WithId] func(t *T, inserted bool)
-type DeleteHook[T WithId] func(t *T, deleted bool)
-type UpsertHook[T WithId] func(t *T, updated bool)
+type DeleteHook[T WithId] func(t *T, deleted bool, reason DeleteReason)
+type UpsertHook[T WithId] func(old *T, t *T, updated bool)
+
+// Constraint validates items before they are written.
+// Stored returns the item currently stored under id, including pending writes of the transaction,
+// or nil if there is no such item
+type Constraint[T WithId] func(items []*T, stored func(id string) *T) error
+
 type Hooks[T WithId] struct {
 	UpdateHooks []UpdateHook[T]
 	InsertHooks []InsertHook[T]
 	DeleteHooks []DeleteHook[T]
 	UpsertHooks []UpsertHook[T]
+	Constraints []Constraint[T]
+}
+
+func MergeHooks[T WithId](hooks ...Hooks[T]) Hooks[T] {
+	result := Hooks[T]{}
+	for _, h := range hooks {
+		result.UpdateHooks = append(result.UpdateHooks, h.UpdateHooks...)
+		result.InsertHooks = append(result.InsertHooks, h.InsertHooks...)
+		result.DeleteHooks = append(result.DeleteHooks, h.DeleteHooks...)
+		result.UpsertHooks = append(result.UpsertHooks, h.UpsertHooks...)
+		result.Constraints = append(result.Constraints, h.Constraints...)
+	}
+	return result
 }
 
 type nothing struct{}
 
 type request[T WithId] struct {
-	UpsertRequest     *T     `json:",omitempty"`
-	UpdateRequest     *T     `json:",omitempty"`
-	InsertRequest     *T     `json:",omitempty"`
-	DeleteRequest     string `json:",omitempty"`
-	DeleteAllRequest  bool   `json:",omitempty"`
-	BulkUpsertRequest []T    `json:",omitempty"`
+	UpsertRequest     *T             `json:",omitempty"`
+	UpdateRequest     *T             `json:",omitempty"`
+	InsertRequest     *T             `json:",omitempty"`
+	DeleteRequest     string         `json:",omitempty"`
+	DeleteAllRequest  bool           `json:",omitempty"`
+	BulkUpsertRequest []T            `json:",omitempty"`
+	PatchRequest      *patchRequest  `json:",omitempty"`
+	ExpireRequest     *expireRequest `json:",omitempty"`
+	TxRequest         []request[T]   `json:",omitempty"`
+	SequenceRequest   uint64         `json:",omitempty"`
+	ExpectedVersion   uint64         `json:",omitempty"`
+	GenerateId        bool           `json:",omitempty"`
+	ExpiresAt         *time.Time     `json:",omitempty"`
+	Timestamp         *time.Time     `json:",omitempty"`
+}
+
+type patchRequest struct {
+	Id    string
+	Patch json.RawMessage
 }
 
 type WithId interface {
 	GetId() string
 }
 
+type record[T WithId] struct {
+	item      *T
+	version   uint64
+	expiresAt time.Time
+}
+
+func (r record[T]) isExpired(now time.Time) bool {
+	return !r.expiresAt.IsZero() && !now.Before(r.expiresAt)
+}
+
+func (r record[T]) expiration() *time.Time {
+	if r.expiresAt.IsZero() {
+		return nil
+	}
+	return &r.expiresAt
+}
+
 type State[T WithId] struct {
-	mutator   state.Mutator
-	items     map[string]*T
-	name      string
-	hooks     Hooks[T]
-	readLock  sync.Locker
-	writeLock sync.Locker
+	mutator    state.Mutator
+	records    hamt[record[T]]
+	snapshot   atomic.Pointer[Snapshot[T]]
+	version    uint64
+	sequence   uint64
+	index      uint64
+	feed       *feed[T]
+	name       string
+	hooks      Hooks[T]
+	indexes    map[string]Index[T]
+	generateId func(item *T, seq uint64)
+	checkId    func(item *T) error
+	tx         *txState[T]
+	now        *time.Time
+	options    *options
 }
 
-func New[T WithId](name string) *State[T] {
-	mu := &sync.RWMutex{}
-	return &State[T]{
-		name:      name,
-		items:     map[string]*T{},
-		readLock:  mu.RLocker(),
-		writeLock: mu,
+func New[T WithId](name string, opts ...Option) *State[T] {
+	options := newOptions()
+	for _, opt := range opts {
+		opt(options)
+	}
+	s := &State[T]{
+		name:    name,
+		feed:    newFeed[T](options.watchBufferSize),
+		options: options,
 	}
+	s.publish()
+	return s
 }
 
 func (s *State[T]) SetHooks(hooks Hooks[T]) {
 	s.hooks = hooks
 }
 
+// SetIdGenerator sets the function assigning id to items inserted by InsertWithNextId.
+// It is called inside the FSM with the next value of the state sequence, so leader and replicas assign identical ids
+func (s *State[T]) SetIdGenerator(generateId func(item *T, seq uint64)) {
+	s.generateId = generateId
+}
+
 func (s *State[T]) SetMutator(mutator state.Mutator) {
 	s.mutator = mutator
 }
 
 func (s *State[T]) All() []T {
-	return s.Find(func(elem *T) bool {
-		return true
-	})
+	return s.Snapshot().All()
 }
 
 func (s *State[T]) Find(filter func(elem *T) bool) []T {
-	s.readLock.Lock()
-	defer s.readLock.Unlock()
-
-	arr := make([]T, 0)
-	for _, item := range s.items {
-		if filter(item) {
-			arr = append(arr, *item)
-		}
-	}
-	return arr
+	return s.Snapshot().Find(filter)
 }
 
 func (s *State[T]) ForEach(f func(elem *T)) {
-	s.readLock.Lock()
-	defer s.readLock.Unlock()
-
-	for _, item := range s.items {
-		f(item)
-	}
+	s.Snapshot().ForEach(f)
 }
 
 func (s *State[T]) Get(id string) *T {
-	s.readLock.Lock()
-	defer s.readLock.Unlock()
+	return s.Snapshot().Get(id)
+}
 
-	item := s.items[id]
-	return item
+// GetWithVersion returns the item and its version.
+// Version is increased on every write to the state and never repeats, even if the item is deleted and created again
+func (s *State[T]) GetWithVersion(id string) (*T, uint64) {
+	return s.Snapshot().GetWithVersion(id)
 }
 
-func (s *State[T]) Upsert(item T) error {
+func (s *State[T]) Upsert(item T, opts ...WriteOption) error {
 	_, err := state.Apply[nothing](s.mutator, request[T]{
 		UpsertRequest: &item,
+		ExpiresAt:     s.expiresAt(opts),
+		Timestamp:     s.timestamp(),
 	})
 	return err
 }
 
-func (s *State[T]) upsert(item T) (any, error) {
-	_, updated := s.items[item.GetId()]
-	s.items[item.GetId()] = &item
-	for _, hook := range s.hooks.UpsertHooks {
-		hook(&item, updated)
+func (s *State[T]) upsert(item T, expiresAt *time.Time) (any, error) {
+	err := s.checkConstraints(&item)
+	if err != nil {
+		return nil, err
 	}
+
+	old := s.lookup(item.GetId())
+	s.put(&item, expiresAt)
+	s.fireUpsert(old.item, &item)
 	return nothing{}, nil
 }
 
 func (s *State[T]) Delete(id string) (*T, error) {
 	val, err := state.Apply[T](s.mutator, request[T]{
 		DeleteRequest: id,
+		Timestamp:     s.timestamp(),
 	})
 	return val, err
 }
 
-func (s *State[T]) delete(id string) (any, error) {
-	item, ok := s.items[id]
-	defer func() {
-		for _, hook := range s.hooks.DeleteHooks {
-			hook(item, ok)
-		}
-	}()
-	if ok {
-		delete(s.items, id)
-		return *item, nil
+func (s *State[T]) DeleteIfVersion(id string, version uint64) (*T, error) {
+	return state.Apply[T](s.mutator, request[T]{
+		DeleteRequest:   id,
+		ExpectedVersion: version,
+		Timestamp:       s.timestamp(),
+	})
+}
+
+func (s *State[T]) delete(id string, expectedVersion uint64) (any, error) {
+	current, ok := s.records.get(id)
+	if ok && expectedVersion != 0 && current.version != expectedVersion {
+		return nil, ErrVersionConflict
 	}
-	return nil, ErrNotFound
+	if !ok {
+		s.fireDelete(nil, false, DeleteReasonRequested)
+		return nil, ErrNotFound
+	}
+	s.remove(id, DeleteReasonRequested)
+	return *current.item, nil
 }
 
 func (s *State[T]) DeleteAll() error {
@@ -149,78 +241,284 @@ func (s *State[T]) DeleteAll() error {
 }
 
 func (s *State[T]) deleteAll() (any, error) {
-	old := s.items
-	s.items = map[string]*T{}
-	for _, item := range old {
-		for _, hook := range s.hooks.DeleteHooks {
-			hook(item, true)
-		}
-	}
+	old := s.records
+	s.records = hamt[record[T]]{}
+	old.forEach(func(id string, r record[T]) bool {
+		s.fireDelete(r.item, true, DeleteReasonRequested)
+		s.recordChange(Change[T]{
+			Type:   ChangeDelete,
+			Id:     id,
+			Old:    r.item,
+			Reason: DeleteReasonRequested,
+		})
+		return true
+	})
 	return nothing{}, nil
 }
 
 func (s *State[T]) Update(item T) error {
 	_, err := state.Apply[nothing](s.mutator, request[T]{
 		UpdateRequest: &item,
+		Timestamp:     s.timestamp(),
 	})
 	return err
 }
 
-func (s *State[T]) update(item T) (any, error) {
+func (s *State[T]) UpdateIfVersion(item T, version uint64) error {
+	_, err := state.Apply[nothing](s.mutator, request[T]{
+		UpdateRequest:   &item,
+		ExpectedVersion: version,
+		Timestamp:       s.timestamp(),
+	})
+	return err
+}
+
+func (s *State[T]) update(item T, expectedVersion uint64) (any, error) {
 	id := item.GetId()
-	_, ok := s.items[id]
+	current, ok := s.records.get(id)
 	defer func() {
-		for _, hook := range s.hooks.UpdateHooks {
-			hook(&item, ok)
-		}
+		s.fireUpdate(current.item, &item, ok)
 	}()
 	if !ok {
 		return nil, ErrNotFound
 	}
-	s.items[id] = &item
+	if expectedVersion != 0 && current.version != expectedVersion {
+		ok = false
+		return nil, ErrVersionConflict
+	}
+	err := s.checkConstraints(&item)
+	if err != nil {
+		ok = false
+		return nil, err
+	}
+	s.put(&item, current.expiration())
 	return nothing{}, nil
 }
 
-func (s *State[T]) Insert(item T) error {
+// Patch applies json merge patch (RFC 7396) to the item and returns the result.
+// Field names in patch must match the ones produced by the state codec
+func (s *State[T]) Patch(id string, patch json.RawMessage) (*T, error) {
+	return state.Apply[T](s.mutator, request[T]{
+		PatchRequest: &patchRequest{
+			Id:    id,
+			Patch: patch,
+		},
+		Timestamp: s.timestamp(),
+	})
+}
+
+func (s *State[T]) patch(codec state.Codec, req patchRequest) (any, error) {
+	current, ok := s.records.get(req.Id)
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	data, err := marshalLossless(codec, current.item)
+	if err != nil {
+		return nil, fmt.Errorf("marshal current item: %w", err)
+	}
+	patched, err := mergePatch(data, req.Patch)
+	if err != nil {
+		return nil, fmt.Errorf("merge patch: %w", err)
+	}
+	var item T
+	err = codec.Decode(patched, &item)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal patched item: %w", err)
+	}
+	if item.GetId() != req.Id {
+		return nil, errors.New("patch must not change item id")
+	}
+
+	err = s.checkConstraints(&item)
+	if err != nil {
+		return nil, err
+	}
+
+	s.put(&item, current.expiration())
+	s.fireUpdate(current.item, &item, true)
+	return item, nil
+}
+
+func (s *State[T]) Insert(item T, opts ...WriteOption) error {
 	_, err := state.Apply[nothing](s.mutator, request[T]{
 		InsertRequest: &item,
+		ExpiresAt:     s.expiresAt(opts),
+		Timestamp:     s.timestamp(),
 	})
 	return err
 }
 
-func (s *State[T]) insert(item T) (any, error) {
+func (s *State[T]) insert(item T, expiresAt *time.Time) (any, error) {
 	id := item.GetId()
-	_, ok := s.items[id]
+	_, ok := s.records.get(id)
 	defer func() {
-		for _, hook := range s.hooks.InsertHooks {
-			hook(&item, !ok)
-		}
+		s.fireInsert(&item, !ok)
 	}()
 	if ok {
 		return nil, ErrAlreadyExists
 	}
-	s.items[id] = &item
+	err := s.checkConstraints(&item)
+	if err != nil {
+		ok = true
+		return nil, err
+	}
+	s.put(&item, expiresAt)
+	return nothing{}, nil
+}
+
+// InsertWithNextId inserts the item with id assigned by the generator and returns the id
+func (s *State[T]) InsertWithNextId(item T, opts ...WriteOption) (string, error) {
+	inserted, err := s.insertWithGeneratedId(item, opts)
+	if err != nil {
+		return "", err
+	}
+	return (*inserted).GetId(), nil
+}
+
+func (s *State[T]) insertWithGeneratedId(item T, opts []WriteOption) (*T, error) {
+	return state.Apply[T](s.mutator, request[T]{
+		InsertRequest: &item,
+		GenerateId:    true,
+		ExpiresAt:     s.expiresAt(opts),
+		Timestamp:     s.timestamp(),
+	})
+}
+
+func (s *State[T]) insertWithNextId(item T, expiresAt *time.Time) (any, error) {
+	if s.generateId == nil {
+		return nil, ErrNoIdGenerator
+	}
+
+	s.sequence++
+	s.generateId(&item, s.sequence)
+	_, err := s.insert(item, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+// advanceSequence moves the id sequence forward, e.g. to the value restored from the dump, it never goes back
+func (s *State[T]) advanceSequence(sequence uint64) (any, error) {
+	s.sequence = max(s.sequence, sequence)
 	return nothing{}, nil
 }
 
 func (s *State[T]) BulkUpsert(items []T) error {
 	_, err := state.Apply[nothing](s.mutator, request[T]{
 		BulkUpsertRequest: items,
+		Timestamp:         s.timestamp(),
 	})
 	return err
 }
 
 func (s *State[T]) bulkUpsert(items []T) (any, error) {
+	toCheck := make([]*T, 0, len(items))
+	for i := range items {
+		toCheck = append(toCheck, &items[i])
+	}
+	err := s.checkConstraints(toCheck...)
+	if err != nil {
+		return nil, err
+	}
+
 	for _, item := range items {
-		_, updated := s.items[item.GetId()]
-		s.items[item.GetId()] = &item
-		for _, hook := range s.hooks.UpsertHooks {
-			hook(&item, updated)
-		}
+		old := s.lookup(item.GetId())
+		s.put(&item, nil)
+		s.fireUpsert(old.item, &item)
 	}
 	return nothing{}, nil
 }
 
+func (s *State[T]) lookup(id string) record[T] {
+	r, _ := s.records.get(id)
+	return r
+}
+
+func (s *State[T]) put(item *T, expiresAt *time.Time) {
+	id := (*item).GetId()
+	old, updated := s.records.get(id)
+	s.version++
+	r := record[T]{
+		item:    item,
+		version: s.version,
+	}
+
+	expiring, ok := any(*item).(Expiring)
+	if ok {
+		at := expiring.ExpiresAt()
+		expiresAt = &at
+	}
+	if expiresAt != nil && !expiresAt.IsZero() {
+		r.expiresAt = truncateTime(*expiresAt)
+	}
+	s.records = s.records.set(id, r)
+	if s.tx != nil {
+		s.tx.items[id] = item
+	}
+
+	change := Change[T]{
+		Type: ChangeInsert,
+		Id:   id,
+		Old:  old.item,
+		New:  item,
+	}
+	if updated {
+		change.Type = ChangeUpdate
+	}
+	s.recordChange(change)
+}
+
+func (s *State[T]) remove(id string, reason DeleteReason) {
+	item := s.lookup(id).item
+	s.records = s.records.delete(id)
+	if s.tx != nil {
+		delete(s.tx.items, id)
+	}
+	s.fireDelete(item, true, reason)
+	s.recordChange(Change[T]{
+		Type:   ChangeDelete,
+		Id:     id,
+		Old:    item,
+		Reason: reason,
+	})
+}
+
+func (s *State[T]) checkConstraints(items ...*T) error {
+	if s.tx != nil {
+		return nil
+	}
+	return s.runConstraints(items)
+}
+
+func (s *State[T]) runConstraints(items []*T) error {
+	if s.checkId != nil {
+		for _, item := range items {
+			err := s.checkId(item)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for _, constraint := range s.hooks.Constraints {
+		err := constraint(items, s.stored)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stored returns the item for constraints, items expired at the time of the request are treated as removed
+func (s *State[T]) stored(id string) *T {
+	r, ok := s.records.get(id)
+	if !ok || (s.now != nil && r.isExpired(*s.now)) {
+		return nil
+	}
+	return r.item
+}
+
 func (s *State[T]) StateName() string {
 	return s.name
 }
@@ -231,24 +529,43 @@ func (s *State[T]) Apply(log state.Log) (any, error) {
 		return nil, err
 	}
 
-	if !log.IsInRecovery() {
-		s.writeLock.Lock()
-		defer s.writeLock.Unlock()
+	s.index = log.Index()
+	defer s.publish()
+
+	return s.handle(log.Codec(), req)
+}
+
+func (s *State[T]) handle(codec state.Codec, req request[T]) (any, error) {
+	if req.TxRequest == nil {
+		s.now = req.Timestamp
+	}
+	if req.Timestamp != nil {
+		s.removeExpired(*req.Timestamp, req.itemIds())
 	}
 
 	switch {
 	case req.UpsertRequest != nil:
-		return s.upsert(*req.UpsertRequest)
+		return s.upsert(*req.UpsertRequest, req.ExpiresAt)
+	case req.InsertRequest != nil && req.GenerateId:
+		return s.insertWithNextId(*req.InsertRequest, req.ExpiresAt)
 	case req.InsertRequest != nil:
-		return s.insert(*req.InsertRequest)
+		return s.insert(*req.InsertRequest, req.ExpiresAt)
 	case req.UpdateRequest != nil:
-		return s.update(*req.UpdateRequest)
+		return s.update(*req.UpdateRequest, req.ExpectedVersion)
 	case req.DeleteRequest != "":
-		return s.delete(req.DeleteRequest)
+		return s.delete(req.DeleteRequest, req.ExpectedVersion)
 	case req.DeleteAllRequest:
 		return s.deleteAll()
 	case req.BulkUpsertRequest != nil:
 		return s.bulkUpsert(req.BulkUpsertRequest)
+	case req.PatchRequest != nil:
+		return s.patch(codec, *req.PatchRequest)
+	case req.ExpireRequest != nil:
+		return s.expire(*req.ExpireRequest)
+	case req.TxRequest != nil:
+		return s.applyTx(codec, req.TxRequest)
+	case req.SequenceRequest != 0:
+		return s.advanceSequence(req.SequenceRequest)
 	default:
 		return nil, errors.New("handler not found")
 	}
@@ -1,8 +1,12 @@
 package crud_test
 
 import (
+	"context"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/txix-open/isp-kit/log"
 	"github.com/txix-open/isp-kit/test/fake"
 
 	"github.com/stretchr/testify/require"
@@ -88,3 +92,236 @@ func TestState_BulkUpsert(t *testing.T) {
 	receivedItem2 := crud.Get(item2.Id)
 	require.EqualValues(item2, *receivedItem2)
 }
+
+type Address struct {
+	City   string
+	Street string
+}
+
+type Profile struct {
+	Id      string
+	Name    string
+	Age     int64
+	Tags    []string
+	Address *Address
+	Rating  float64
+}
+
+func (p Profile) GetId() string {
+	return p.Id
+}
+
+func TestState_Patch(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	s := crud.New[Profile]("profiles")
+	var (
+		hookOld *Profile
+		hookNew *Profile
+	)
+	s.SetHooks(crud.Hooks[Profile]{
+		UpdateHooks: []crud.UpdateHook[Profile]{func(old *Profile, t *Profile, updated bool) {
+			hookOld = old
+			hookNew = t
+		}},
+	})
+	tstate.ServeState(t, s)
+
+	err := s.Insert(Profile{
+		Id:      "1",
+		Name:    "John",
+		Age:     9007199254740993,
+		Tags:    []string{"a", "b"},
+		Address: &Address{City: "Moscow", Street: "Arbat"},
+		Rating:  1.23456789,
+	})
+	require.NoError(err)
+
+	result, err := s.Patch("1", []byte(`{"name": "Jane", "tags": ["c"], "address": {"street": "Tverskaya"}}`))
+	require.NoError(err)
+	expected := Profile{
+		Id:      "1",
+		Name:    "Jane",
+		Age:     9007199254740993,
+		Tags:    []string{"c"},
+		Address: &Address{City: "Moscow", Street: "Tverskaya"},
+		Rating:  1.23456789,
+	}
+	require.EqualValues(expected, *result)
+	require.EqualValues(expected, *s.Get("1"))
+	require.EqualValues("John", hookOld.Name)
+	require.EqualValues(expected, *hookNew)
+
+	result, err = s.Patch("1", []byte(`{"address": null, "tags": null}`))
+	require.NoError(err)
+	require.Nil(result.Address)
+	require.Nil(result.Tags)
+	require.EqualValues(1.23456789, result.Rating)
+
+	result, err = s.Patch("1", []byte(`{"rating": 2.718281828459045}`))
+	require.NoError(err)
+	require.EqualValues(2.718281828459045, result.Rating)
+
+	_, err = s.Patch("2", []byte(`{"name": "Jane"}`))
+	require.ErrorIs(err, crud.ErrNotFound)
+	_, err = s.Patch("1", []byte(`{"id": "2"}`))
+	require.Error(err)
+	_, err = s.Patch("1", []byte(`["name"]`))
+	require.Error(err)
+	require.EqualValues("Jane", s.Get("1").Name)
+}
+
+func TestState_Versions(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	s := crud.New[Item1]("items")
+	tstate.ServeState(t, s)
+
+	item, version := s.GetWithVersion("1")
+	require.Nil(item)
+	require.Zero(version)
+
+	err := s.Insert(Item1{Id: "1", X: "a"})
+	require.NoError(err)
+	_, v1 := s.GetWithVersion("1")
+	require.Positive(v1)
+
+	err = s.UpdateIfVersion(Item1{Id: "1", X: "b"}, v1)
+	require.NoError(err)
+	item, v2 := s.GetWithVersion("1")
+	require.Greater(v2, v1)
+	require.EqualValues("b", item.X)
+
+	err = s.UpdateIfVersion(Item1{Id: "1", X: "c"}, v1)
+	require.ErrorIs(err, crud.ErrVersionConflict)
+	require.EqualValues("b", s.Get("1").X)
+	err = s.UpdateIfVersion(Item1{Id: "2", X: "c"}, v1)
+	require.ErrorIs(err, crud.ErrNotFound)
+
+	_, err = s.DeleteIfVersion("1", v1)
+	require.ErrorIs(err, crud.ErrVersionConflict)
+	require.NotNil(s.Get("1"))
+	deleted, err := s.DeleteIfVersion("1", v2)
+	require.NoError(err)
+	require.EqualValues("b", deleted.X)
+
+	err = s.Insert(Item1{Id: "1", X: "a"})
+	require.NoError(err)
+	_, v3 := s.GetWithVersion("1")
+	require.Greater(v3, v2)
+}
+
+type fakeClock struct {
+	lock sync.Mutex
+	now  time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.now = c.now.Add(d)
+}
+
+type Session struct {
+	Id       string
+	Deadline time.Time
+}
+
+func (s Session) GetId() string {
+	return s.Id
+}
+
+func (s Session) ExpiresAt() time.Time {
+	return s.Deadline
+}
+
+func TestState_Expiration(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	s := crud.New[Item1]("items", crud.WithClock(clock), crud.ExpirationInterval(10*time.Millisecond))
+	deleted := make(chan crud.DeleteReason, 10)
+	s.SetHooks(crud.Hooks[Item1]{
+		DeleteHooks: []crud.DeleteHook[Item1]{func(t *Item1, ok bool, reason crud.DeleteReason) {
+			if ok {
+				deleted <- reason
+			}
+		}},
+	})
+	tstate.ServeState(t, s)
+
+	err := s.Insert(Item1{Id: "1", X: "a"}, crud.WithTTL(time.Minute))
+	require.NoError(err)
+	err = s.Upsert(Item1{Id: "2", X: "b"}, crud.WithExpiresAt(clock.Now().Add(time.Hour)))
+	require.NoError(err)
+	err = s.Insert(Item1{Id: "3", X: "c"})
+	require.NoError(err)
+	require.EqualValues(clock.Now().Add(time.Minute), s.ExpiresAt("1"))
+	require.True(s.ExpiresAt("3").IsZero())
+
+	err = s.Update(Item1{Id: "1", X: "aa"})
+	require.NoError(err)
+	require.EqualValues(clock.Now().Add(time.Minute), s.ExpiresAt("1"))
+
+	clock.Advance(time.Minute)
+	require.Nil(s.Get("1"))
+	require.Len(s.All(), 2)
+
+	err = s.Update(Item1{Id: "1", X: "b"})
+	require.ErrorIs(err, crud.ErrNotFound)
+	require.EqualValues(crud.DeleteReasonExpired, <-deleted)
+	err = s.Insert(Item1{Id: "1", X: "new"})
+	require.NoError(err)
+	require.EqualValues("new", s.Get("1").X)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	logger, err := log.New()
+	require.NoError(err)
+	go func() {
+		_ = s.RunExpiration(ctx, logger)
+	}()
+	clock.Advance(time.Hour)
+	require.EqualValues(crud.DeleteReasonExpired, <-deleted)
+	require.ElementsMatch([]string{"1", "3"}, ids(s.All()))
+
+	_, err = s.Delete("3")
+	require.NoError(err)
+	require.EqualValues(crud.DeleteReasonRequested, <-deleted)
+}
+
+func TestState_ExpiringItem(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	s := crud.New[Session]("sessions", crud.WithClock(clock))
+	tstate.ServeState(t, s)
+
+	err := s.Insert(Session{Id: "1", Deadline: clock.Now().Add(time.Second)}, crud.WithTTL(time.Hour))
+	require.NoError(err)
+	err = s.Insert(Session{Id: "2"})
+	require.NoError(err)
+	require.NotNil(s.Get("1"))
+
+	clock.Advance(time.Second)
+	require.Nil(s.Get("1"))
+	require.NotNil(s.Get("2"))
+}
+
+func ids(items []Item1) []string {
+	result := make([]string, 0, len(items))
+	for _, item := range items {
+		result = append(result, item.Id)
+	}
+	return result
+}
@@ -0,0 +1,149 @@
+package crud
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/txix-open/isp-kit/log"
+	"github.com/txix-open/walx/v2/state"
+)
+
+type DeleteReason string
+
+const (
+	DeleteReasonRequested DeleteReason = "requested"
+	DeleteReasonExpired   DeleteReason = "expired"
+)
+
+// Expiring can be implemented by items to define expiration time by themselves.
+// It has priority over WithTTL and WithExpiresAt, zero time means the item never expires
+type Expiring interface {
+	ExpiresAt() time.Time
+}
+
+type expireRequest struct {
+	Ids []string
+	Now time.Time
+}
+
+// RunExpiration periodically journals removal of expired items, so replicas and recovery see the same deletions.
+// Expired items are hidden from reads and constraints immediately, but indexes see them until removal.
+// Failed removals are logged and retried on the next tick. It must be run on the leader only
+func (s *State[T]) RunExpiration(ctx context.Context, logger log.Logger) error {
+	ctx = log.ToContext(ctx, log.String("state", s.name))
+	ticker := time.NewTicker(s.options.expirationInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		err := s.removeExpiredItems()
+		if err != nil {
+			logger.Error(ctx, fmt.Errorf("remove expired items: %w", err))
+		}
+	}
+}
+
+// ExpiresAt returns the expiration time of the item or zero time if the item never expires
+func (s *State[T]) ExpiresAt(id string) time.Time {
+	return s.Snapshot().ExpiresAt(id)
+}
+
+func (s *State[T]) removeExpiredItems() error {
+	now := s.timestamp()
+	ids := s.expiredIds(*now)
+	if len(ids) == 0 {
+		return nil
+	}
+
+	_, err := state.Apply[nothing](s.mutator, request[T]{
+		ExpireRequest: &expireRequest{
+			Ids: ids,
+			Now: *now,
+		},
+	})
+	return err
+}
+
+func (s *State[T]) expiredIds(now time.Time) []string {
+	ids := make([]string, 0)
+	s.snapshot.Load().records.forEach(func(id string, r record[T]) bool {
+		if r.isExpired(now) {
+			ids = append(ids, id)
+		}
+		return true
+	})
+	sort.Strings(ids)
+	return ids
+}
+
+func (s *State[T]) expire(req expireRequest) (any, error) {
+	s.removeExpired(req.Now, req.Ids)
+	return nothing{}, nil
+}
+
+func (s *State[T]) removeExpired(now time.Time, ids []string) {
+	for _, id := range ids {
+		r, ok := s.records.get(id)
+		if ok && r.isExpired(now) {
+			s.remove(id, DeleteReasonExpired)
+		}
+	}
+}
+
+func (s *State[T]) expiresAt(opts []WriteOption) *time.Time {
+	options := &writeOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	expiresAt := options.expiresAt
+	if options.ttl > 0 {
+		expiresAt = s.options.clock.Now().Add(options.ttl)
+	}
+	if expiresAt.IsZero() {
+		return nil
+	}
+	expiresAt = truncateTime(expiresAt)
+	return &expiresAt
+}
+
+func (s *State[T]) timestamp() *time.Time {
+	now := truncateTime(s.options.clock.Now())
+	return &now
+}
+
+func (r request[T]) itemIds() []string {
+	switch {
+	case r.UpsertRequest != nil:
+		return []string{(*r.UpsertRequest).GetId()}
+	case r.InsertRequest != nil:
+		return []string{(*r.InsertRequest).GetId()}
+	case r.UpdateRequest != nil:
+		return []string{(*r.UpdateRequest).GetId()}
+	case r.DeleteRequest != "":
+		return []string{r.DeleteRequest}
+	case r.PatchRequest != nil:
+		return []string{r.PatchRequest.Id}
+	case r.BulkUpsertRequest != nil:
+		ids := make([]string, 0, len(r.BulkUpsertRequest))
+		for _, item := range r.BulkUpsertRequest {
+			ids = append(ids, item.GetId())
+		}
+		return ids
+	default:
+		return nil
+	}
+}
+
+// truncateTime drops precision not preserved by the codec,
+// so in-memory and recovered events are compared equally
+func truncateTime(t time.Time) time.Time {
+	return t.Truncate(time.Millisecond)
+}
@@ -0,0 +1,283 @@
+package crud
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/txix-open/walx/v2/state"
+)
+
+const (
+	defaultImportBatchSize = 1000
+)
+
+var (
+	ErrInvalidExport = errors.New("invalid export")
+)
+
+type exportHeader struct {
+	State    string
+	Index    uint64
+	Count    int
+	Sequence uint64
+}
+
+type exportLine struct {
+	Export *exportHeader `json:"$export,omitempty"`
+}
+
+// exportRecord is the line of the dump following the header
+type exportRecord[T any] struct {
+	Item     *T
+	ExpireAt *time.Time `json:",omitempty"`
+}
+
+type ImportProgress struct {
+	Read    int
+	Written int
+}
+
+// ImportDiff describes changes made by Import.
+// Deleted lists ids of items missing in the dump, Import itself never deletes items
+type ImportDiff struct {
+	Inserted []string
+	Updated  []string
+	Deleted  []string
+}
+
+// Export writes a consistent dump of the state as JSONL encoded with codec: the header line with the applied WAL index
+// and the id sequence followed by records of items with their expiration times, one per line.
+// It returns the WAL index the dump corresponds to
+func (s *State[T]) Export(w io.Writer, codec state.Codec) (uint64, error) {
+	snapshot := s.Snapshot()
+	count := 0
+	snapshot.ForEach(func(elem *T) {
+		count++
+	})
+
+	err := writeLine(w, codec, exportLine{Export: &exportHeader{
+		State:    s.name,
+		Index:    snapshot.index,
+		Count:    count,
+		Sequence: snapshot.sequence,
+	}})
+	if err != nil {
+		return 0, fmt.Errorf("write header: %w", err)
+	}
+
+	snapshot.records.forEach(func(id string, r record[T]) bool {
+		if r.isExpired(snapshot.now) {
+			return true
+		}
+		err = writeLine(w, codec, exportRecord[T]{
+			Item:     r.item,
+			ExpireAt: r.expiration(),
+		})
+		if err != nil {
+			err = fmt.Errorf("write item %s: %w", id, err)
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+	return snapshot.index, nil
+}
+
+// Import upserts items from the JSONL dump in batches of batchSize, unchanged items are skipped.
+// Dumps produced by Export and plain JSONL of items without the header are both accepted,
+// the dump must belong to the state with the same name and contain every id once.
+// The dump is read and validated before anything is written, so changed items are kept in memory.
+// The id sequence from the header is journaled before items, so generated ids don't collide with imported ones.
+// Every batch is journaled as a separate transaction, if writing fails, previous batches stay written
+// and the error reports how many items were written
+func (s *State[T]) Import(r io.Reader, codec state.Codec, batchSize int, opts ...ImportOption) (*ImportDiff, error) {
+	options := &importOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if batchSize <= 0 {
+		batchSize = defaultImportBatchSize
+	}
+
+	var (
+		header   *exportHeader
+		progress ImportProgress
+		changed  = make([]exportRecord[T], 0)
+		seen     = make(map[string]struct{})
+		diff     = &ImportDiff{
+			Inserted: make([]string, 0),
+			Updated:  make([]string, 0),
+			Deleted:  make([]string, 0),
+		}
+	)
+
+	current := s.Snapshot()
+	reader := bufio.NewReader(r)
+	for line := 1; ; line++ {
+		raw, err := reader.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("read line %d: %w", line, err)
+		}
+		eof := err != nil
+		raw = bytes.TrimSpace(raw)
+		if len(raw) == 0 {
+			if eof {
+				break
+			}
+			continue
+		}
+
+		if line == 1 {
+			header, err = readExportHeader(codec, raw)
+			if err != nil {
+				return nil, fmt.Errorf("%w: header: %w", ErrInvalidExport, err)
+			}
+			if header != nil && header.State != s.name {
+				return nil, fmt.Errorf("%w: dump of state %s", ErrInvalidExport, header.State)
+			}
+			if header != nil {
+				continue
+			}
+		}
+
+		record, err := readExportRecord[T](codec, raw, header != nil)
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: %w", ErrInvalidExport, line, err)
+		}
+		progress.Read++
+
+		id := (*record.Item).GetId()
+		_, duplicated := seen[id]
+		if duplicated {
+			return nil, fmt.Errorf("%w: line %d: duplicated id %s", ErrInvalidExport, line, id)
+		}
+		seen[id] = struct{}{}
+		existing := current.Get(id)
+		switch {
+		case existing == nil:
+			diff.Inserted = append(diff.Inserted, id)
+		case !equalJson(codec, existing, record.Item) || !current.ExpiresAt(id).Equal(expireAt(record)):
+			diff.Updated = append(diff.Updated, id)
+		default:
+			continue
+		}
+		changed = append(changed, record)
+
+		if eof {
+			break
+		}
+	}
+
+	if header != nil && header.Count != progress.Read {
+		return nil, fmt.Errorf("%w: expected %d items, got %d", ErrInvalidExport, header.Count, progress.Read)
+	}
+
+	if header != nil && header.Sequence > current.sequence && !options.dryRun {
+		_, err := state.Apply[nothing](s.mutator, request[T]{
+			SequenceRequest: header.Sequence,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("restore id sequence: %w", err)
+		}
+	}
+
+	for start := 0; start < len(changed) && !options.dryRun; start += batchSize {
+		batch := changed[start:min(start+batchSize, len(changed))]
+		err := s.Tx(func(tx *Tx[T]) error {
+			for _, record := range batch {
+				tx.Upsert(*record.Item, WithExpiresAt(expireAt(record)))
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf(
+				"upsert items %d-%d, %d items are written: %w",
+				start+1, start+len(batch), progress.Written, err,
+			)
+		}
+		progress.Written += len(batch)
+		if options.progress != nil {
+			options.progress(progress)
+		}
+	}
+	if options.progress != nil && progress.Written == 0 {
+		options.progress(progress)
+	}
+
+	current.records.forEach(func(id string, r record[T]) bool {
+		_, ok := seen[id]
+		if !ok && !r.isExpired(current.now) {
+			diff.Deleted = append(diff.Deleted, id)
+		}
+		return true
+	})
+	sort.Strings(diff.Deleted)
+	return diff, nil
+}
+
+func readExportHeader(codec state.Codec, raw []byte) (*exportHeader, error) {
+	if !isJsonObject(raw) {
+		return nil, nil
+	}
+	line := exportLine{}
+	err := codec.Decode(raw, &line)
+	if err != nil {
+		return nil, err
+	}
+	return line.Export, nil
+}
+
+// readExportRecord decodes the record written by Export or the plain item if the dump has no header
+func readExportRecord[T WithId](codec state.Codec, raw []byte, hasHeader bool) (exportRecord[T], error) {
+	record := exportRecord[T]{}
+	if !hasHeader {
+		record.Item = new(T)
+		err := codec.Decode(raw, record.Item)
+		return record, err
+	}
+
+	err := codec.Decode(raw, &record)
+	if err != nil {
+		return record, err
+	}
+	if record.Item == nil {
+		return record, errors.New("item is required")
+	}
+	return record, nil
+}
+
+func expireAt[T any](record exportRecord[T]) time.Time {
+	if record.ExpireAt == nil {
+		return time.Time{}
+	}
+	return truncateTime(*record.ExpireAt)
+}
+
+func writeLine(w io.Writer, codec state.Codec, value any) error {
+	data, err := marshalLossless(codec, value)
+	if err != nil {
+		return err
+	}
+	data = append(bytes.TrimRight(data, "\n"), '\n')
+	_, err = w.Write(data)
+	return err
+}
+
+func equalJson(codec state.Codec, a any, b any) bool {
+	aJson, err := marshalLossless(codec, a)
+	if err != nil {
+		return false
+	}
+	bJson, err := marshalLossless(codec, b)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(aJson, bJson)
+}
@@ -0,0 +1,137 @@
+package crud_test
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/txix-open/walx/v2/crud"
+	"github.com/txix-open/walx/v2/state/codec/json"
+	"github.com/txix-open/walx/v2/tstate"
+)
+
+func TestState_ExportImport(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	source := crud.New[Item1]("source")
+	tstate.ServeState(t, source)
+	for _, id := range []string{"1", "2", "3", "4"} {
+		err := source.Insert(Item1{Id: id, X: "x" + id})
+		require.NoError(err)
+	}
+	expireAt := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+	err := source.Insert(Item1{Id: "5", X: "x5"}, crud.WithExpiresAt(expireAt))
+	require.NoError(err)
+
+	codec := json.NewCodec()
+	dump := bytes.NewBuffer(nil)
+	index, err := source.Export(dump, codec)
+	require.NoError(err)
+	require.Equal(source.Snapshot().Index(), index)
+	lines := strings.Split(strings.TrimSpace(dump.String()), "\n")
+	require.Len(lines, 6)
+	require.JSONEq(`{"$export":{"state":"source","index":5,"count":5,"sequence":0}}`, lines[0])
+	require.Contains(dump.String(), `{"item":{"id":"1","x":"x1"}}`)
+	require.Contains(dump.String(), `"expireAt":`)
+
+	target := crud.New[Item1]("source")
+	tstate.ServeState(t, target)
+	err = target.Insert(Item1{Id: "1", X: "x1"})
+	require.NoError(err)
+	err = target.Insert(Item1{Id: "2", X: "old"})
+	require.NoError(err)
+	err = target.Insert(Item1{Id: "9", X: "x9"})
+	require.NoError(err)
+
+	diff, err := target.Import(bytes.NewReader(dump.Bytes()), codec, 2, crud.DryRun())
+	require.NoError(err)
+	require.ElementsMatch([]string{"3", "4", "5"}, diff.Inserted)
+	require.Equal([]string{"2"}, diff.Updated)
+	require.Equal([]string{"9"}, diff.Deleted)
+	require.Len(target.All(), 3)
+	require.Equal("old", target.Get("2").X)
+
+	progress := make([]crud.ImportProgress, 0)
+	diff, err = target.Import(bytes.NewReader(dump.Bytes()), codec, 2, crud.OnProgress(func(p crud.ImportProgress) {
+		progress = append(progress, p)
+	}))
+	require.NoError(err)
+	require.Len(diff.Inserted, 3)
+	require.NotEmpty(progress)
+	require.Equal(crud.ImportProgress{Read: 5, Written: 4}, progress[len(progress)-1])
+	require.Len(target.All(), 6)
+	require.Equal("x2", target.Get("2").X)
+	require.Equal("x9", target.Get("9").X)
+	require.True(expireAt.Equal(target.ExpiresAt("5")))
+	require.True(target.ExpiresAt("1").IsZero())
+
+	diff, err = target.Import(bytes.NewReader(dump.Bytes()), codec, 2)
+	require.NoError(err)
+	require.Empty(diff.Inserted)
+	require.Empty(diff.Updated)
+}
+
+func TestState_ImportPlainJsonl(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	s := crud.New[Item1]("state")
+	tstate.ServeState(t, s)
+
+	codec := json.NewCodec()
+	diff, err := s.Import(strings.NewReader("{\"id\":\"1\",\"x\":\"a\"}\n{\"id\":\"2\",\"x\":\"b\"}"), codec, 0)
+	require.NoError(err)
+	require.Equal([]string{"1", "2"}, diff.Inserted)
+	require.Equal("b", s.Get("2").X)
+
+	_, err = s.Import(strings.NewReader("{\"$export\":{\"state\":\"state\",\"index\":1,\"count\":2}}\n{\"item\":{\"id\":\"3\"}}\n"), codec, 0)
+	require.ErrorIs(err, crud.ErrInvalidExport)
+	require.Nil(s.Get("3"))
+
+	_, err = s.Import(strings.NewReader("{\"id\":\"4\"}\nnot json\n"), codec, 0)
+	require.ErrorIs(err, crud.ErrInvalidExport)
+	require.Nil(s.Get("4"))
+
+	_, err = s.Import(strings.NewReader("{\"id\":\"5\"}\n{\"id\":\"5\"}\n"), codec, 0)
+	require.ErrorIs(err, crud.ErrInvalidExport)
+	require.Nil(s.Get("5"))
+}
+
+func TestState_ImportSequence(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	generateId := func(item *Item1, seq uint64) {
+		item.Id = strconv.FormatUint(seq, 10)
+	}
+	source := crud.New[Item1]("items")
+	source.SetIdGenerator(generateId)
+	tstate.ServeState(t, source)
+	for range 3 {
+		_, err := source.InsertWithNextId(Item1{})
+		require.NoError(err)
+	}
+	codec := json.NewCodec()
+	dump := bytes.NewBuffer(nil)
+	_, err := source.Export(dump, codec)
+	require.NoError(err)
+
+	other := crud.New[Item1]("other")
+	tstate.ServeState(t, other)
+	_, err = other.Import(bytes.NewReader(dump.Bytes()), codec, 0)
+	require.ErrorIs(err, crud.ErrInvalidExport)
+	require.Empty(other.All())
+
+	target := crud.New[Item1]("items")
+	target.SetIdGenerator(generateId)
+	tstate.ServeState(t, target)
+	_, err = target.Import(bytes.NewReader(dump.Bytes()), codec, 0)
+	require.NoError(err)
+	id, err := target.InsertWithNextId(Item1{})
+	require.NoError(err)
+	require.Equal("4", id)
+}
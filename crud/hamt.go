@@ -0,0 +1,275 @@
+package crud
+
+import (
+	"math/bits"
+)
+
+const (
+	hamtBits     = 5
+	hamtMask     = 1<<hamtBits - 1
+	hamtMaxDepth = 64 / hamtBits
+)
+
+// hamt is a persistent hash array mapped trie.
+// Every modification returns a new version sharing unchanged nodes with the previous one,
+// so a published version can be read without locks while writers build the next one
+type hamt[V any] struct {
+	root *hamtNode[V]
+	size int
+}
+
+type hamtEntry[V any] struct {
+	hash  uint64
+	key   string
+	value V
+}
+
+// hamtNode stores entries and children in separate arrays addressed by bitmaps.
+// Nodes deeper than hamtMaxDepth have no bitmaps and keep colliding entries in a plain list
+type hamtNode[V any] struct {
+	dataMap uint32
+	nodeMap uint32
+	entries []hamtEntry[V]
+	nodes   []*hamtNode[V]
+}
+
+func (h hamt[V]) len() int {
+	return h.size
+}
+
+func (h hamt[V]) get(key string) (V, bool) {
+	var empty V
+	if h.root == nil {
+		return empty, false
+	}
+	entry := h.root.get(hashKey(key), key, 0)
+	if entry == nil {
+		return empty, false
+	}
+	return entry.value, true
+}
+
+func (h hamt[V]) set(key string, value V) hamt[V] {
+	root := h.root
+	if root == nil {
+		root = &hamtNode[V]{}
+	}
+	root, added := root.set(hamtEntry[V]{hash: hashKey(key), key: key, value: value}, 0)
+	size := h.size
+	if added {
+		size++
+	}
+	return hamt[V]{root: root, size: size}
+}
+
+func (h hamt[V]) delete(key string) hamt[V] {
+	if h.root == nil {
+		return h
+	}
+	root, removed := h.root.delete(hashKey(key), key, 0)
+	if !removed {
+		return h
+	}
+	return hamt[V]{root: root, size: h.size - 1}
+}
+
+// forEach calls f for every entry in unspecified order until f returns false
+func (h hamt[V]) forEach(f func(key string, value V) bool) {
+	if h.root == nil {
+		return
+	}
+	h.root.forEach(f)
+}
+
+func (n *hamtNode[V]) get(hash uint64, key string, depth int) *hamtEntry[V] {
+	for {
+		if depth >= hamtMaxDepth {
+			for i := range n.entries {
+				if n.entries[i].key == key {
+					return &n.entries[i]
+				}
+			}
+			return nil
+		}
+
+		bit := bitpos(hash, depth)
+		if n.dataMap&bit != 0 {
+			entry := &n.entries[hamtIndex(n.dataMap, bit)]
+			if entry.key == key {
+				return entry
+			}
+			return nil
+		}
+		if n.nodeMap&bit == 0 {
+			return nil
+		}
+		n = n.nodes[hamtIndex(n.nodeMap, bit)]
+		depth++
+	}
+}
+
+func (n *hamtNode[V]) set(entry hamtEntry[V], depth int) (*hamtNode[V], bool) {
+	if depth >= hamtMaxDepth {
+		for i := range n.entries {
+			if n.entries[i].key == entry.key {
+				result := n.clone()
+				result.entries[i] = entry
+				return result, false
+			}
+		}
+		result := n.clone()
+		result.entries = append(result.entries, entry)
+		return result, true
+	}
+
+	bit := bitpos(entry.hash, depth)
+	switch {
+	case n.dataMap&bit != 0:
+		idx := hamtIndex(n.dataMap, bit)
+		existing := n.entries[idx]
+		result := n.clone()
+		if existing.key == entry.key {
+			result.entries[idx] = entry
+			return result, false
+		}
+		result.dataMap &^= bit
+		result.entries = hamtRemove(result.entries, idx)
+		result.nodeMap |= bit
+		result.nodes = hamtInsert(result.nodes, hamtIndex(result.nodeMap, bit), newHamtPair(existing, entry, depth+1))
+		return result, true
+	case n.nodeMap&bit != 0:
+		idx := hamtIndex(n.nodeMap, bit)
+		child, added := n.nodes[idx].set(entry, depth+1)
+		result := n.clone()
+		result.nodes[idx] = child
+		return result, added
+	default:
+		result := n.clone()
+		result.dataMap |= bit
+		result.entries = hamtInsert(result.entries, hamtIndex(result.dataMap, bit), entry)
+		return result, true
+	}
+}
+
+func (n *hamtNode[V]) delete(hash uint64, key string, depth int) (*hamtNode[V], bool) {
+	if depth >= hamtMaxDepth {
+		for i := range n.entries {
+			if n.entries[i].key == key {
+				result := n.clone()
+				result.entries = hamtRemove(result.entries, i)
+				return result, true
+			}
+		}
+		return n, false
+	}
+
+	bit := bitpos(hash, depth)
+	switch {
+	case n.dataMap&bit != 0:
+		idx := hamtIndex(n.dataMap, bit)
+		if n.entries[idx].key != key {
+			return n, false
+		}
+		result := n.clone()
+		result.dataMap &^= bit
+		result.entries = hamtRemove(result.entries, idx)
+		return result, true
+	case n.nodeMap&bit != 0:
+		idx := hamtIndex(n.nodeMap, bit)
+		child, removed := n.nodes[idx].delete(hash, key, depth+1)
+		if !removed {
+			return n, false
+		}
+		result := n.clone()
+		switch {
+		case len(child.nodes) == 0 && len(child.entries) == 0:
+			result.nodeMap &^= bit
+			result.nodes = hamtRemove(result.nodes, idx)
+		case len(child.nodes) == 0 && len(child.entries) == 1:
+			result.nodeMap &^= bit
+			result.nodes = hamtRemove(result.nodes, idx)
+			result.dataMap |= bit
+			result.entries = hamtInsert(result.entries, hamtIndex(result.dataMap, bit), child.entries[0])
+		default:
+			result.nodes[idx] = child
+		}
+		return result, true
+	default:
+		return n, false
+	}
+}
+
+func (n *hamtNode[V]) forEach(f func(key string, value V) bool) bool {
+	for _, entry := range n.entries {
+		if !f(entry.key, entry.value) {
+			return false
+		}
+	}
+	for _, node := range n.nodes {
+		if !node.forEach(f) {
+			return false
+		}
+	}
+	return true
+}
+
+func (n *hamtNode[V]) clone() *hamtNode[V] {
+	return &hamtNode[V]{
+		dataMap: n.dataMap,
+		nodeMap: n.nodeMap,
+		entries: append([]hamtEntry[V](nil), n.entries...),
+		nodes:   append([]*hamtNode[V](nil), n.nodes...),
+	}
+}
+
+func newHamtPair[V any](a hamtEntry[V], b hamtEntry[V], depth int) *hamtNode[V] {
+	if depth >= hamtMaxDepth {
+		return &hamtNode[V]{entries: []hamtEntry[V]{a, b}}
+	}
+
+	bitA := bitpos(a.hash, depth)
+	bitB := bitpos(b.hash, depth)
+	if bitA == bitB {
+		return &hamtNode[V]{
+			nodeMap: bitA,
+			nodes:   []*hamtNode[V]{newHamtPair(a, b, depth+1)},
+		}
+	}
+	if bitA > bitB {
+		a, b = b, a
+	}
+	return &hamtNode[V]{
+		dataMap: bitA | bitB,
+		entries: []hamtEntry[V]{a, b},
+	}
+}
+
+func bitpos(hash uint64, depth int) uint32 {
+	return 1 << ((hash >> (depth * hamtBits)) & hamtMask)
+}
+
+func hamtIndex(bitmap uint32, bit uint32) int {
+	return bits.OnesCount32(bitmap & (bit - 1))
+}
+
+func hamtInsert[E any](arr []E, idx int, elem E) []E {
+	var empty E
+	arr = append(arr, empty)
+	copy(arr[idx+1:], arr[idx:])
+	arr[idx] = elem
+	return arr
+}
+
+func hamtRemove[E any](arr []E, idx int) []E {
+	return append(arr[:idx], arr[idx+1:]...)
+}
+
+// hashKey is FNV-1a
+func hashKey(key string) uint64 {
+	hash := uint64(14695981039164346037)
+	for i := 0; i < len(key); i++ {
+		hash ^= uint64(key[i])
+		hash *= 1099511628211
+	}
+	return hash
+}
@@ -0,0 +1,175 @@
+package index_test
+
+import (
+	"fmt"
+	"math/rand"
+	"slices"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/txix-open/walx/v2/crud"
+	"github.com/txix-open/walx/v2/crud/index"
+	"github.com/txix-open/walx/v2/tstate"
+)
+
+type Account struct {
+	Id    string
+	Group string
+	Email string
+	Rank  int
+	Roles []string
+}
+
+func (a Account) GetId() string {
+	return a.Id
+}
+
+type accountIndexes struct {
+	byGroup *index.Hash[Account]
+	byRank  *index.Sorted[Account, int]
+	byEmail *index.Unique[Account]
+	byRole  *index.Multi[Account]
+}
+
+func TestIndexes_Consistency(t *testing.T) {
+	t.Parallel()
+
+	for seed := int64(1); seed <= 20; seed++ {
+		t.Run(fmt.Sprintf("seed_%d", seed), func(t *testing.T) {
+			t.Parallel()
+			require := require.New(t)
+
+			s, indexes := accountState(t)
+			rnd := rand.New(rand.NewSource(seed))
+			for range 200 {
+				applyRandomOperation(rnd, s)
+				requireConsistent(require, s.All(), indexes)
+			}
+		})
+	}
+}
+
+func applyRandomOperation(rnd *rand.Rand, s *crud.State[Account]) {
+	randomAccount := func() Account {
+		return Account{
+			Id:    fmt.Sprintf("id%d", rnd.Intn(10)),
+			Group: []string{"", "g1", "g2", "g3"}[rnd.Intn(4)],
+			Email: []string{"", "a", "b", "c", "d", "e"}[rnd.Intn(6)],
+			Rank:  rnd.Intn(5),
+			Roles: []string{"r1", "r2", "r3"}[:rnd.Intn(4)],
+		}
+	}
+
+	switch rnd.Intn(7) {
+	case 0:
+		_ = s.Insert(randomAccount())
+	case 1, 2:
+		_ = s.Update(randomAccount())
+	case 3:
+		_ = s.Upsert(randomAccount())
+	case 4:
+		items := make([]Account, 0)
+		for range rnd.Intn(4) + 1 {
+			items = append(items, randomAccount())
+		}
+		_ = s.BulkUpsert(items)
+	case 5:
+		_, _ = s.Delete(fmt.Sprintf("id%d", rnd.Intn(10)))
+	case 6:
+		if rnd.Intn(10) == 0 {
+			_ = s.DeleteAll()
+		}
+	}
+}
+
+func requireConsistent(require *require.Assertions, all []Account, indexes accountIndexes) {
+	byGroup := make(map[string][]string)
+	byEmail := make(map[string]string)
+	byRole := make(map[string][]string)
+	for _, item := range all {
+		for _, role := range item.Roles {
+			byRole[role] = append(byRole[role], item.Id)
+		}
+		if item.Group != "" {
+			byGroup[item.Group] = append(byGroup[item.Group], item.Id)
+		}
+		if item.Email != "" {
+			_, duplicate := byEmail[item.Email]
+			require.False(duplicate, "duplicate email %s", item.Email)
+			byEmail[item.Email] = item.Id
+		}
+	}
+
+	for _, group := range []string{"g1", "g2", "g3"} {
+		expected := byGroup[group]
+		actual := make([]string, 0)
+		for _, item := range indexes.byGroup.Get(group) {
+			require.EqualValues(group, item.Group)
+			actual = append(actual, item.Id)
+		}
+		require.ElementsMatch(expected, actual, "group %s", group)
+	}
+
+	for _, role := range []string{"r1", "r2", "r3"} {
+		actual := make([]string, 0)
+		for _, item := range indexes.byRole.Get(role) {
+			require.Contains(item.Roles, role)
+			actual = append(actual, item.Id)
+		}
+		require.ElementsMatch(byRole[role], actual, "role %s", role)
+	}
+
+	for _, email := range []string{"a", "b", "c", "d", "e"} {
+		item := indexes.byEmail.Get(email)
+		expectedId, ok := byEmail[email]
+		if !ok {
+			require.Nil(item, "email %s", email)
+			continue
+		}
+		require.NotNil(item, "email %s", email)
+		require.EqualValues(expectedId, item.Id)
+		require.EqualValues(email, item.Email)
+	}
+
+	expected := slices.Clone(all)
+	sort.Slice(expected, func(i, j int) bool {
+		if expected[i].Rank == expected[j].Rank {
+			return expected[i].Id < expected[j].Id
+		}
+		return expected[i].Rank < expected[j].Rank
+	})
+	actual := make([]Account, 0)
+	for item := range indexes.byRank.Ascend() {
+		actual = append(actual, *item)
+	}
+	require.Equal(expected, actual)
+}
+
+func accountState(t *testing.T) (*crud.State[Account], accountIndexes) {
+	t.Helper()
+
+	indexes := accountIndexes{
+		byGroup: index.NewHash[Account](func(item *Account) string {
+			return item.Group
+		}),
+		byRank: index.NewSorted[Account](func(item *Account) int {
+			return item.Rank
+		}),
+		byEmail: index.NewUnique[Account]("email", func(item *Account) string {
+			return item.Email
+		}),
+		byRole: index.NewMulti[Account](func(item *Account) []string {
+			return item.Roles
+		}),
+	}
+	state := crud.New[Account]("state")
+	state.SetHooks(crud.MergeHooks(
+		indexes.byGroup.Hooks(),
+		indexes.byRank.Hooks(),
+		indexes.byEmail.Hooks(),
+		indexes.byRole.Hooks(),
+	))
+	tstate.ServeState(t, state)
+	return state, indexes
+}
@@ -1,6 +1,7 @@
 package index
 
 import (
+	"slices"
 	"sync"
 
 	"github.com/txix-open/walx/v2/crud"
@@ -30,6 +31,21 @@ func (h *Hash[T]) Get(key string) []*T {
 	return h.data[key]
 }
 
+func (h *Hash[T]) Lookup(key string) []*T {
+	h.readLock.Lock()
+	defer h.readLock.Unlock()
+
+	return slices.Clone(h.data[key])
+}
+
+func (h *Hash[T]) Keys(item *T) []string {
+	key := h.keySuppler(item)
+	if key == "" {
+		return nil
+	}
+	return []string{key}
+}
+
 func (h *Hash[T]) Hooks() crud.Hooks[T] {
 	return crud.Hooks[T]{
 		UpdateHooks: []crud.UpdateHook[T]{h.updateHook},
@@ -39,12 +55,12 @@ func (h *Hash[T]) Hooks() crud.Hooks[T] {
 	}
 }
 
-func (h *Hash[T]) updateHook(item *T, updated bool) {
+func (h *Hash[T]) updateHook(old *T, item *T, updated bool) {
 	if !updated {
 		return
 	}
 
-	h.index(item)
+	h.index(old, item)
 }
 
 func (h *Hash[T]) insertHook(item *T, inserted bool) {
@@ -52,55 +68,41 @@ func (h *Hash[T]) insertHook(item *T, inserted bool) {
 		return
 	}
 
-	h.index(item)
+	h.index(nil, item)
 }
 
-func (h *Hash[T]) deleteHook(item *T, deleted bool) {
+func (h *Hash[T]) deleteHook(item *T, deleted bool, _ crud.DeleteReason) {
 	if !deleted {
 		return
 	}
 
-	key := h.keySuppler(item)
-	if key == "" {
-		return
-	}
-
 	h.writeLock.Lock()
 	defer h.writeLock.Unlock()
 
-	arr := h.data[key]
-	if len(arr) == 0 {
-		return
-	}
-
-	newArr := make([]*T, 0)
-	for _, elem := range arr {
-		if (*elem).GetId() == (*item).GetId() {
-			continue
-		}
-		newArr = append(newArr, elem)
-	}
-	if len(newArr) == 0 {
-		delete(h.data, key)
-		return
-	}
-
-	h.data[key] = newArr
+	h.remove(h.keySuppler(item), (*item).GetId())
 }
 
-func (h *Hash[T]) upsertHook(item *T, updated bool) {
-	h.index(item)
+func (h *Hash[T]) upsertHook(old *T, item *T, updated bool) {
+	h.index(old, item)
 }
 
-func (h *Hash[T]) index(item *T) {
+func (h *Hash[T]) index(old *T, item *T) {
 	key := h.keySuppler(item)
-	if key == "" {
-		return
-	}
 
 	h.writeLock.Lock()
 	defer h.writeLock.Unlock()
 
+	if old != nil {
+		oldKey := h.keySuppler(old)
+		if oldKey != key {
+			h.remove(oldKey, (*old).GetId())
+		}
+	}
+
+	if key == "" {
+		return
+	}
+
 	arr := h.data[key]
 	slicesIsChanged := false
 	for i, elem := range arr {
@@ -116,3 +118,28 @@ func (h *Hash[T]) index(item *T) {
 
 	h.data[key] = arr
 }
+
+func (h *Hash[T]) remove(key string, id string) {
+	if key == "" {
+		return
+	}
+
+	arr := h.data[key]
+	if len(arr) == 0 {
+		return
+	}
+
+	newArr := make([]*T, 0)
+	for _, elem := range arr {
+		if (*elem).GetId() == id {
+			continue
+		}
+		newArr = append(newArr, elem)
+	}
+	if len(newArr) == 0 {
+		delete(h.data, key)
+		return
+	}
+
+	h.data[key] = newArr
+}
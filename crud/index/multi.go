@@ -0,0 +1,186 @@
+package index
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/txix-open/walx/v2/crud"
+)
+
+type Multi[T crud.WithId] struct {
+	data         map[string]map[string]*T
+	keysSupplier func(item *T) []string
+	readLock     sync.Locker
+	writeLock    sync.Locker
+}
+
+func NewMulti[T crud.WithId](keysSupplier func(item *T) []string) *Multi[T] {
+	mu := &sync.RWMutex{}
+	return &Multi[T]{
+		data:         make(map[string]map[string]*T),
+		keysSupplier: keysSupplier,
+		readLock:     mu.RLocker(),
+		writeLock:    mu,
+	}
+}
+
+// Get returns items indexed by key ordered by id
+func (m *Multi[T]) Get(key string) []*T {
+	m.readLock.Lock()
+	defer m.readLock.Unlock()
+
+	return sortedById(m.data[key])
+}
+
+// GetAny returns items indexed by at least one of keys ordered by id
+func (m *Multi[T]) GetAny(keys ...string) []*T {
+	m.readLock.Lock()
+	defer m.readLock.Unlock()
+
+	result := make(map[string]*T)
+	for _, key := range keys {
+		for id, item := range m.data[key] {
+			result[id] = item
+		}
+	}
+	return sortedById(result)
+}
+
+// GetAll returns items indexed by every key ordered by id
+func (m *Multi[T]) GetAll(keys ...string) []*T {
+	m.readLock.Lock()
+	defer m.readLock.Unlock()
+
+	if len(keys) == 0 {
+		return []*T{}
+	}
+
+	smallest := m.data[keys[0]]
+	for _, key := range keys[1:] {
+		if len(m.data[key]) < len(smallest) {
+			smallest = m.data[key]
+		}
+	}
+
+	result := make(map[string]*T)
+	for id, item := range smallest {
+		matched := true
+		for _, key := range keys {
+			_, ok := m.data[key][id]
+			if !ok {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			result[id] = item
+		}
+	}
+	return sortedById(result)
+}
+
+func (m *Multi[T]) Lookup(key string) []*T {
+	return m.Get(key)
+}
+
+func (m *Multi[T]) Keys(item *T) []string {
+	return m.keysSupplier(item)
+}
+
+func (m *Multi[T]) Hooks() crud.Hooks[T] {
+	return crud.Hooks[T]{
+		UpdateHooks: []crud.UpdateHook[T]{m.updateHook},
+		InsertHooks: []crud.InsertHook[T]{m.insertHook},
+		DeleteHooks: []crud.DeleteHook[T]{m.deleteHook},
+		UpsertHooks: []crud.UpsertHook[T]{m.upsertHook},
+	}
+}
+
+func (m *Multi[T]) updateHook(old *T, item *T, updated bool) {
+	if !updated {
+		return
+	}
+
+	m.index(old, item)
+}
+
+func (m *Multi[T]) insertHook(item *T, inserted bool) {
+	if !inserted {
+		return
+	}
+
+	m.index(nil, item)
+}
+
+func (m *Multi[T]) deleteHook(item *T, deleted bool, _ crud.DeleteReason) {
+	if !deleted {
+		return
+	}
+
+	m.writeLock.Lock()
+	defer m.writeLock.Unlock()
+
+	id := (*item).GetId()
+	for _, key := range m.keysSupplier(item) {
+		m.remove(key, id)
+	}
+}
+
+func (m *Multi[T]) upsertHook(old *T, item *T, updated bool) {
+	m.index(old, item)
+}
+
+func (m *Multi[T]) index(old *T, item *T) {
+	newKeys := make(map[string]struct{})
+	for _, key := range m.keysSupplier(item) {
+		if key != "" {
+			newKeys[key] = struct{}{}
+		}
+	}
+
+	m.writeLock.Lock()
+	defer m.writeLock.Unlock()
+
+	if old != nil {
+		oldId := (*old).GetId()
+		for _, key := range m.keysSupplier(old) {
+			_, ok := newKeys[key]
+			if !ok {
+				m.remove(key, oldId)
+			}
+		}
+	}
+
+	id := (*item).GetId()
+	for key := range newKeys {
+		items, ok := m.data[key]
+		if !ok {
+			items = make(map[string]*T)
+			m.data[key] = items
+		}
+		items[id] = item
+	}
+}
+
+func (m *Multi[T]) remove(key string, id string) {
+	items, ok := m.data[key]
+	if !ok {
+		return
+	}
+
+	delete(items, id)
+	if len(items) == 0 {
+		delete(m.data, key)
+	}
+}
+
+func sortedById[T crud.WithId](items map[string]*T) []*T {
+	result := make([]*T, 0, len(items))
+	for _, item := range items {
+		result = append(result, item)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return (*result[i]).GetId() < (*result[j]).GetId()
+	})
+	return result
+}
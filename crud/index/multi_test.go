@@ -0,0 +1,74 @@
+package index_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/txix-open/walx/v2/crud"
+	"github.com/txix-open/walx/v2/crud/index"
+	"github.com/txix-open/walx/v2/tstate"
+)
+
+type TaggedItem struct {
+	Id   string
+	Tags []string
+}
+
+func (i TaggedItem) GetId() string {
+	return i.Id
+}
+
+func taggedIds(items []*TaggedItem) []string {
+	ids := make([]string, 0, len(items))
+	for _, item := range items {
+		ids = append(ids, item.Id)
+	}
+	return ids
+}
+
+func TestMultiIndex(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	s, idx := multiState(t)
+	err := s.Insert(TaggedItem{Id: "1", Tags: []string{"go", "db"}})
+	require.NoError(err)
+	err = s.Insert(TaggedItem{Id: "2", Tags: []string{"go", "web"}})
+	require.NoError(err)
+	err = s.Insert(TaggedItem{Id: "3", Tags: []string{"db", "db"}})
+	require.NoError(err)
+
+	require.Equal([]string{"1", "2"}, taggedIds(idx.Get("go")))
+	require.Equal([]string{"1", "3"}, taggedIds(idx.Get("db")))
+	require.Equal([]string{"1", "2", "3"}, taggedIds(idx.GetAny("web", "db")))
+	require.Equal([]string{"1"}, taggedIds(idx.GetAll("go", "db")))
+	require.Empty(idx.GetAll("go", "unknown"))
+	require.Empty(idx.GetAll())
+
+	err = s.Update(TaggedItem{Id: "1", Tags: []string{"go", "web"}})
+	require.NoError(err)
+	require.Equal([]string{"3"}, taggedIds(idx.Get("db")))
+	require.Equal([]string{"1", "2"}, taggedIds(idx.GetAll("go", "web")))
+
+	err = s.BulkUpsert([]TaggedItem{{Id: "2", Tags: nil}, {Id: "4", Tags: []string{"db"}}})
+	require.NoError(err)
+	require.Equal([]string{"1"}, taggedIds(idx.Get("go")))
+	require.Equal([]string{"3", "4"}, taggedIds(idx.Get("db")))
+
+	_, err = s.Delete("1")
+	require.NoError(err)
+	require.Empty(idx.Get("go"))
+	require.Empty(idx.Get("web"))
+}
+
+func multiState(t *testing.T) (*crud.State[TaggedItem], *index.Multi[TaggedItem]) {
+	t.Helper()
+
+	state := crud.New[TaggedItem]("state")
+	index := index.NewMulti[TaggedItem](func(item *TaggedItem) []string {
+		return item.Tags
+	})
+	state.SetHooks(index.Hooks())
+	tstate.ServeState(t, state)
+	return state, index
+}
@@ -0,0 +1,332 @@
+package index
+
+import (
+	"cmp"
+	"fmt"
+	"iter"
+	"reflect"
+	"sync"
+
+	"github.com/google/btree"
+	"github.com/txix-open/walx/v2/crud"
+)
+
+const (
+	btreeDegree = 32
+	// scanBatchSize is the number of items copied under the lock per step of lazy iteration
+	scanBatchSize = 64
+)
+
+type Cursor[K cmp.Ordered] struct {
+	Key K
+	Id  string
+}
+
+type sortedItem[T crud.WithId, K cmp.Ordered] struct {
+	key  K
+	id   string
+	item *T
+}
+
+func lessSortedItem[T crud.WithId, K cmp.Ordered](a, b sortedItem[T, K]) bool {
+	c := cmp.Compare(a.key, b.key)
+	if c == 0 {
+		return a.id < b.id
+	}
+	return c < 0
+}
+
+type Sorted[T crud.WithId, K cmp.Ordered] struct {
+	tree        *btree.BTreeG[sortedItem[T, K]]
+	keySupplier func(item *T) K
+	readLock    sync.Locker
+	writeLock   sync.Locker
+}
+
+func NewSorted[T crud.WithId, K cmp.Ordered](keySupplier func(item *T) K) *Sorted[T, K] {
+	mu := &sync.RWMutex{}
+	return &Sorted[T, K]{
+		tree:        btree.NewG(btreeDegree, lessSortedItem[T, K]),
+		keySupplier: keySupplier,
+		readLock:    mu.RLocker(),
+		writeLock:   mu,
+	}
+}
+
+func (s *Sorted[T, K]) Len() int {
+	s.readLock.Lock()
+	defer s.readLock.Unlock()
+
+	return s.tree.Len()
+}
+
+func (s *Sorted[T, K]) Min() *T {
+	s.readLock.Lock()
+	defer s.readLock.Unlock()
+
+	item, ok := s.tree.Min()
+	if !ok {
+		return nil
+	}
+	return item.item
+}
+
+func (s *Sorted[T, K]) Max() *T {
+	s.readLock.Lock()
+	defer s.readLock.Unlock()
+
+	item, ok := s.tree.Max()
+	if !ok {
+		return nil
+	}
+	return item.item
+}
+
+// Range iterates items with keys in [from, to) in ascending order
+func (s *Sorted[T, K]) Range(from K, to K) iter.Seq[*T] {
+	return s.Between(&from, &to, false)
+}
+
+func (s *Sorted[T, K]) Ascend() iter.Seq[*T] {
+	return s.Between(nil, nil, false)
+}
+
+func (s *Sorted[T, K]) Descend() iter.Seq[*T] {
+	return s.Between(nil, nil, true)
+}
+
+// Between iterates items with keys in [from, to), nil bound means unbounded.
+// Items are read lazily in small batches, so breaking early doesn't copy the whole index
+func (s *Sorted[T, K]) Between(from *K, to *K, descending bool) iter.Seq[*T] {
+	return func(yield func(*T) bool) {
+		var after *sortedItem[T, K]
+		for {
+			batch := s.scanBatch(from, to, descending, after)
+			for _, item := range batch {
+				if !yield(item.item) {
+					return
+				}
+			}
+			if len(batch) < scanBatchSize {
+				return
+			}
+			after = &batch[len(batch)-1]
+		}
+	}
+}
+
+// Key returns the key the item is ordered by
+func (s *Sorted[T, K]) Key(item *T) K {
+	return s.keySupplier(item)
+}
+
+func (s *Sorted[T, K]) Compare(a *T, b *T) int {
+	return cmp.Compare(s.keySupplier(a), s.keySupplier(b))
+}
+
+func (s *Sorted[T, K]) Scan(descending bool) iter.Seq[*T] {
+	return s.Between(nil, nil, descending)
+}
+
+// Lookup returns items with the key formatted as by fmt.Sprint
+func (s *Sorted[T, K]) Lookup(key string) []*T {
+	k, ok := parseKey[K](key)
+	if !ok {
+		return nil
+	}
+
+	s.readLock.Lock()
+	defer s.readLock.Unlock()
+
+	var items []*T
+	s.tree.AscendGreaterOrEqual(sortedItem[T, K]{key: k}, func(item sortedItem[T, K]) bool {
+		if item.key != k {
+			return false
+		}
+		items = append(items, item.item)
+		return true
+	})
+	return items
+}
+
+func (s *Sorted[T, K]) Keys(item *T) []string {
+	return []string{fmt.Sprint(s.keySupplier(item))}
+}
+
+// Page returns at most limit items after cursor in ascending order and the cursor of the next page.
+// Nil cursor means the first page, nil next cursor means there are no more items.
+// Non-positive limit returns no items and nil cursor
+func (s *Sorted[T, K]) Page(after *Cursor[K], limit int) ([]*T, *Cursor[K]) {
+	return s.page(limit, func(tree *btree.BTreeG[sortedItem[T, K]], visit btree.ItemIteratorG[sortedItem[T, K]]) {
+		if after == nil {
+			tree.Ascend(visit)
+			return
+		}
+		pivot := sortedItem[T, K]{key: after.Key, id: after.Id}
+		tree.AscendGreaterOrEqual(pivot, func(item sortedItem[T, K]) bool {
+			if !lessSortedItem(pivot, item) {
+				return true
+			}
+			return visit(item)
+		})
+	})
+}
+
+// PageDesc is the same as Page but in descending order
+func (s *Sorted[T, K]) PageDesc(before *Cursor[K], limit int) ([]*T, *Cursor[K]) {
+	return s.page(limit, func(tree *btree.BTreeG[sortedItem[T, K]], visit btree.ItemIteratorG[sortedItem[T, K]]) {
+		if before == nil {
+			tree.Descend(visit)
+			return
+		}
+		pivot := sortedItem[T, K]{key: before.Key, id: before.Id}
+		tree.DescendLessOrEqual(pivot, func(item sortedItem[T, K]) bool {
+			if !lessSortedItem(item, pivot) {
+				return true
+			}
+			return visit(item)
+		})
+	})
+}
+
+func (s *Sorted[T, K]) Hooks() crud.Hooks[T] {
+	return crud.Hooks[T]{
+		UpdateHooks: []crud.UpdateHook[T]{s.updateHook},
+		InsertHooks: []crud.InsertHook[T]{s.insertHook},
+		DeleteHooks: []crud.DeleteHook[T]{s.deleteHook},
+		UpsertHooks: []crud.UpsertHook[T]{s.upsertHook},
+	}
+}
+
+func (s *Sorted[T, K]) updateHook(old *T, item *T, updated bool) {
+	if !updated {
+		return
+	}
+
+	s.index(old, item)
+}
+
+func (s *Sorted[T, K]) insertHook(item *T, inserted bool) {
+	if !inserted {
+		return
+	}
+
+	s.index(nil, item)
+}
+
+func (s *Sorted[T, K]) deleteHook(item *T, deleted bool, _ crud.DeleteReason) {
+	if !deleted {
+		return
+	}
+
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+
+	s.tree.Delete(sortedItem[T, K]{key: s.keySupplier(item), id: (*item).GetId()})
+}
+
+func (s *Sorted[T, K]) upsertHook(old *T, item *T, updated bool) {
+	s.index(old, item)
+}
+
+func (s *Sorted[T, K]) index(old *T, item *T) {
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+
+	id := (*item).GetId()
+	if old != nil {
+		s.tree.Delete(sortedItem[T, K]{key: s.keySupplier(old), id: (*old).GetId()})
+	}
+	s.tree.ReplaceOrInsert(sortedItem[T, K]{
+		key:  s.keySupplier(item),
+		id:   id,
+		item: item,
+	})
+}
+
+func (s *Sorted[T, K]) scanBatch(from *K, to *K, descending bool, after *sortedItem[T, K]) []sortedItem[T, K] {
+	s.readLock.Lock()
+	defer s.readLock.Unlock()
+
+	batch := make([]sortedItem[T, K], 0, scanBatchSize)
+	visit := func(item sortedItem[T, K]) bool {
+		if after != nil && !descending && !lessSortedItem(*after, item) {
+			return true
+		}
+		if after != nil && descending && !lessSortedItem(item, *after) {
+			return true
+		}
+		if !descending && to != nil && cmp.Compare(item.key, *to) >= 0 {
+			return false
+		}
+		if descending && to != nil && cmp.Compare(item.key, *to) >= 0 {
+			return true
+		}
+		if descending && from != nil && cmp.Compare(item.key, *from) < 0 {
+			return false
+		}
+		batch = append(batch, item)
+		return len(batch) < scanBatchSize
+	}
+
+	switch {
+	case after != nil && !descending:
+		s.tree.AscendGreaterOrEqual(*after, visit)
+	case after != nil && descending:
+		s.tree.DescendLessOrEqual(*after, visit)
+	case !descending && from != nil:
+		s.tree.AscendGreaterOrEqual(sortedItem[T, K]{key: *from}, visit)
+	case !descending:
+		s.tree.Ascend(visit)
+	case to != nil:
+		s.tree.DescendLessOrEqual(sortedItem[T, K]{key: *to}, visit)
+	default:
+		s.tree.Descend(visit)
+	}
+	return batch
+}
+
+func (s *Sorted[T, K]) page(
+	limit int,
+	walk func(tree *btree.BTreeG[sortedItem[T, K]], visit btree.ItemIteratorG[sortedItem[T, K]]),
+) ([]*T, *Cursor[K]) {
+	if limit <= 0 {
+		return nil, nil
+	}
+
+	s.readLock.Lock()
+	defer s.readLock.Unlock()
+
+	items := make([]*T, 0, limit)
+	var (
+		last    sortedItem[T, K]
+		hasMore bool
+	)
+	walk(s.tree, func(item sortedItem[T, K]) bool {
+		if len(items) == limit {
+			hasMore = true
+			return false
+		}
+		items = append(items, item.item)
+		last = item
+		return true
+	})
+	if !hasMore {
+		return items, nil
+	}
+	return items, &Cursor[K]{Key: last.key, Id: last.id}
+}
+
+func parseKey[K cmp.Ordered](key string) (K, bool) {
+	var k K
+	value := reflect.ValueOf(&k).Elem()
+	if value.Kind() == reflect.String {
+		value.SetString(key)
+		return k, true
+	}
+	_, err := fmt.Sscan(key, &k)
+	if err != nil || fmt.Sprint(k) != key {
+		return k, false
+	}
+	return k, true
+}
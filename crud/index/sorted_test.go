@@ -0,0 +1,178 @@
+package index_test
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/txix-open/walx/v2/crud"
+	"github.com/txix-open/walx/v2/crud/index"
+	"github.com/txix-open/walx/v2/tstate"
+)
+
+type ScoredItem struct {
+	Id    string
+	Score int
+}
+
+func (i ScoredItem) GetId() string {
+	return i.Id
+}
+
+func scores(items []*ScoredItem) []int {
+	result := make([]int, 0, len(items))
+	for _, item := range items {
+		result = append(result, item.Score)
+	}
+	return result
+}
+
+func collectScores(seq func(yield func(*ScoredItem) bool)) []int {
+	return scores(slices.Collect(seq))
+}
+
+func TestSortedIndex(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	s, idx := sortedState(t)
+	require.Nil(idx.Min())
+	require.Nil(idx.Max())
+
+	for i, score := range []int{50, 10, 40, 20, 30} {
+		err := s.Insert(ScoredItem{Id: string(rune('a' + i)), Score: score})
+		require.NoError(err)
+	}
+	require.Equal([]int{10, 20, 30, 40, 50}, collectScores(idx.Ascend()))
+	require.Equal([]int{50, 40, 30, 20, 10}, collectScores(idx.Descend()))
+	require.Equal([]int{20, 30}, collectScores(idx.Range(20, 40)))
+	require.EqualValues(10, idx.Min().Score)
+	require.EqualValues(50, idx.Max().Score)
+
+	err := s.Update(ScoredItem{Id: "b", Score: 60})
+	require.NoError(err)
+	err = s.Upsert(ScoredItem{Id: "a", Score: 5})
+	require.NoError(err)
+	require.Equal([]int{5, 20, 30, 40, 60}, collectScores(idx.Ascend()))
+	require.EqualValues(5, idx.Len())
+
+	err = s.BulkUpsert([]ScoredItem{{Id: "c", Score: 1}, {Id: "f", Score: 100}})
+	require.NoError(err)
+	require.Equal([]int{1, 5, 20, 30, 60, 100}, collectScores(idx.Ascend()))
+
+	_, err = s.Delete("d")
+	require.NoError(err)
+	require.Equal([]int{1, 5, 30, 60, 100}, collectScores(idx.Ascend()))
+
+	page, cursor := idx.Page(nil, 2)
+	require.Equal([]int{1, 5}, scores(page))
+	require.NotNil(cursor)
+	page, cursor = idx.Page(cursor, 2)
+	require.Equal([]int{30, 60}, scores(page))
+	page, cursor = idx.Page(cursor, 2)
+	require.Equal([]int{100}, scores(page))
+	require.Nil(cursor)
+
+	page, cursor = idx.PageDesc(nil, 3)
+	require.Equal([]int{100, 60, 30}, scores(page))
+	page, cursor = idx.PageDesc(cursor, 3)
+	require.Equal([]int{5, 1}, scores(page))
+	require.Nil(cursor)
+
+	for _, limit := range []int{0, -1} {
+		page, cursor = idx.Page(nil, limit)
+		require.Empty(page)
+		require.Nil(cursor)
+		page, cursor = idx.PageDesc(nil, limit)
+		require.Empty(page)
+		require.Nil(cursor)
+	}
+
+	err = s.DeleteAll()
+	require.NoError(err)
+	require.Empty(collectScores(idx.Ascend()))
+	require.Nil(idx.Min())
+}
+
+func TestSortedIndex_DuplicateKeys(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	s, idx := sortedState(t)
+	for _, id := range []string{"c", "a", "b"} {
+		err := s.Upsert(ScoredItem{Id: id, Score: 1})
+		require.NoError(err)
+	}
+
+	ids := make([]string, 0)
+	for item := range idx.Ascend() {
+		ids = append(ids, item.Id)
+	}
+	require.Equal([]string{"a", "b", "c"}, ids)
+
+	page, cursor := idx.Page(nil, 1)
+	require.EqualValues("a", page[0].Id)
+	page, _ = idx.Page(cursor, 5)
+	require.Len(page, 2)
+	require.EqualValues("b", page[0].Id)
+}
+
+func TestSortedIndex_Between(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	s, idx := sortedState(t)
+	items := make([]ScoredItem, 0)
+	for i := 0; i < 200; i++ {
+		items = append(items, ScoredItem{Id: fmt.Sprintf("%03d", i), Score: i})
+	}
+	err := s.BulkUpsert(items)
+	require.NoError(err)
+
+	from, to := 10, 150
+	between := collectScores(idx.Between(&from, &to, false))
+	require.Len(between, 140)
+	require.EqualValues(10, between[0])
+	require.EqualValues(149, between[139])
+	require.True(slices.IsSorted(between))
+
+	desc := collectScores(idx.Between(&from, &to, true))
+	require.Len(desc, 140)
+	require.EqualValues(149, desc[0])
+	require.EqualValues(10, desc[139])
+	require.Len(collectScores(idx.Between(nil, &to, true)), 150)
+	require.Len(collectScores(idx.Between(&to, nil, false)), 50)
+
+	visited := 0
+	for item := range idx.Descend() {
+		// the index is not locked while items are yielded
+		err := s.Upsert(ScoredItem{Id: item.Id, Score: item.Score})
+		require.NoError(err)
+		visited++
+		if visited == 3 {
+			break
+		}
+	}
+	require.EqualValues(3, visited)
+
+	var _ crud.Index[ScoredItem] = idx
+	require.Equal([]string{"42"}, idx.Keys(&ScoredItem{Score: 42}))
+	found := idx.Lookup("42")
+	require.Len(found, 1)
+	require.EqualValues("042", found[0].Id)
+	require.Empty(idx.Lookup("42.5"))
+	require.Empty(idx.Lookup("unknown"))
+}
+
+func sortedState(t *testing.T) (*crud.State[ScoredItem], *index.Sorted[ScoredItem, int]) {
+	t.Helper()
+
+	state := crud.New[ScoredItem]("state")
+	index := index.NewSorted[ScoredItem](func(item *ScoredItem) int {
+		return item.Score
+	})
+	state.SetHooks(index.Hooks())
+	tstate.ServeState(t, state)
+	return state, index
+}
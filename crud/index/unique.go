@@ -0,0 +1,171 @@
+package index
+
+import (
+	"sync"
+
+	"github.com/txix-open/walx/v2/crud"
+)
+
+type Unique[T crud.WithId] struct {
+	name        string
+	data        map[string]*T
+	keySupplier func(item *T) string
+	readLock    sync.Locker
+	writeLock   sync.Locker
+}
+
+// NewUnique creates an index which rejects writes producing two items with the same non-empty key.
+// Name is used to identify the index in crud.ConstraintViolationError
+func NewUnique[T crud.WithId](name string, keySupplier func(item *T) string) *Unique[T] {
+	mu := &sync.RWMutex{}
+	return &Unique[T]{
+		name:        name,
+		data:        make(map[string]*T),
+		keySupplier: keySupplier,
+		readLock:    mu.RLocker(),
+		writeLock:   mu,
+	}
+}
+
+func (u *Unique[T]) Get(key string) *T {
+	u.readLock.Lock()
+	defer u.readLock.Unlock()
+
+	return u.data[key]
+}
+
+func (u *Unique[T]) Lookup(key string) []*T {
+	item := u.Get(key)
+	if item == nil {
+		return nil
+	}
+	return []*T{item}
+}
+
+func (u *Unique[T]) Keys(item *T) []string {
+	key := u.keySupplier(item)
+	if key == "" {
+		return nil
+	}
+	return []string{key}
+}
+
+func (u *Unique[T]) Hooks() crud.Hooks[T] {
+	return crud.Hooks[T]{
+		UpdateHooks: []crud.UpdateHook[T]{u.updateHook},
+		InsertHooks: []crud.InsertHook[T]{u.insertHook},
+		DeleteHooks: []crud.DeleteHook[T]{u.deleteHook},
+		UpsertHooks: []crud.UpsertHook[T]{u.upsertHook},
+		Constraints: []crud.Constraint[T]{u.check},
+	}
+}
+
+// check rejects items taking keys of other items.
+// The index is updated only after commit, so owners are verified against stored items:
+// the key is released if its owner is deleted or re-keyed by the same write
+func (u *Unique[T]) check(items []*T, stored func(id string) *T) error {
+	u.readLock.Lock()
+	defer u.readLock.Unlock()
+
+	newKeys := make(map[string]string, len(items))
+	for _, item := range items {
+		newKeys[(*item).GetId()] = u.keySupplier(item)
+	}
+
+	owners := make(map[string]string, len(items))
+	for _, item := range items {
+		id := (*item).GetId()
+		key := u.keySupplier(item)
+		if key == "" {
+			continue
+		}
+
+		ownerId, ok := owners[key]
+		if ok && ownerId != id {
+			return u.violation(key, id, ownerId)
+		}
+		owners[key] = id
+
+		owner, ok := u.data[key]
+		if !ok {
+			continue
+		}
+		ownerId = (*owner).GetId()
+		if ownerId == id {
+			continue
+		}
+		newKey, moved := newKeys[ownerId]
+		if moved && newKey != key {
+			continue
+		}
+		current := stored(ownerId)
+		if current == nil || u.keySupplier(current) != key {
+			continue
+		}
+		return u.violation(key, id, ownerId)
+	}
+	return nil
+}
+
+func (u *Unique[T]) violation(key string, id string, conflictId string) error {
+	return crud.ConstraintViolationError{
+		Constraint: u.name,
+		Key:        key,
+		Id:         id,
+		ConflictId: conflictId,
+	}
+}
+
+func (u *Unique[T]) updateHook(old *T, item *T, updated bool) {
+	if !updated {
+		return
+	}
+
+	u.index(old, item)
+}
+
+func (u *Unique[T]) insertHook(item *T, inserted bool) {
+	if !inserted {
+		return
+	}
+
+	u.index(nil, item)
+}
+
+func (u *Unique[T]) deleteHook(item *T, deleted bool, _ crud.DeleteReason) {
+	if !deleted {
+		return
+	}
+
+	u.writeLock.Lock()
+	defer u.writeLock.Unlock()
+
+	u.remove(item)
+}
+
+func (u *Unique[T]) upsertHook(old *T, item *T, updated bool) {
+	u.index(old, item)
+}
+
+func (u *Unique[T]) index(old *T, item *T) {
+	u.writeLock.Lock()
+	defer u.writeLock.Unlock()
+
+	if old != nil {
+		u.remove(old)
+	}
+
+	key := u.keySupplier(item)
+	if key == "" {
+		return
+	}
+	u.data[key] = item
+}
+
+func (u *Unique[T]) remove(item *T) {
+	key := u.keySupplier(item)
+	owner, ok := u.data[key]
+	if ok && (*owner).GetId() == (*item).GetId() {
+		delete(u.data, key)
+	}
+}
@@ -0,0 +1,101 @@
+package index_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/txix-open/walx/v2/crud"
+	"github.com/txix-open/walx/v2/crud/index"
+	"github.com/txix-open/walx/v2/tstate"
+)
+
+func TestUniqueIndex(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	s, idx := uniqueState(t)
+	err := s.Insert(Item{Id: "1", IndexKey: "a@mail.com"})
+	require.NoError(err)
+	err = s.Insert(Item{Id: "2", IndexKey: "b@mail.com"})
+	require.NoError(err)
+
+	err = s.Insert(Item{Id: "3", IndexKey: "a@mail.com"})
+	require.ErrorIs(err, crud.ErrConstraintViolation)
+	violation := crud.ConstraintViolationError{}
+	require.ErrorAs(err, &violation)
+	require.EqualValues("email", violation.Constraint)
+	require.EqualValues("1", violation.ConflictId)
+	require.Nil(s.Get("3"))
+
+	err = s.Update(Item{Id: "2", IndexKey: "a@mail.com"})
+	require.ErrorIs(err, crud.ErrConstraintViolation)
+	err = s.Upsert(Item{Id: "2", IndexKey: "a@mail.com"})
+	require.ErrorIs(err, crud.ErrConstraintViolation)
+	require.EqualValues("b@mail.com", s.Get("2").IndexKey)
+	require.EqualValues("2", idx.Get("b@mail.com").Id)
+
+	err = s.Upsert(Item{Id: "1", IndexKey: "a@mail.com"})
+	require.NoError(err)
+	err = s.Update(Item{Id: "1", IndexKey: "c@mail.com"})
+	require.NoError(err)
+	require.Nil(idx.Get("a@mail.com"))
+	err = s.Insert(Item{Id: "3", IndexKey: "a@mail.com"})
+	require.NoError(err)
+
+	_, err = s.Delete("3")
+	require.NoError(err)
+	require.Nil(idx.Get("a@mail.com"))
+
+	err = s.Insert(Item{Id: "4"})
+	require.NoError(err)
+	err = s.Insert(Item{Id: "5"})
+	require.NoError(err)
+}
+
+func TestUniqueIndex_ExpiredOwner(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	s, idx := uniqueState(t)
+	err := s.Insert(Item{Id: "1", IndexKey: "a@mail.com"}, crud.WithTTL(50*time.Millisecond))
+	require.NoError(err)
+	err = s.Insert(Item{Id: "2", IndexKey: "a@mail.com"})
+	require.ErrorIs(err, crud.ErrConstraintViolation)
+
+	time.Sleep(100 * time.Millisecond)
+	err = s.Insert(Item{Id: "2", IndexKey: "a@mail.com"})
+	require.NoError(err)
+	require.EqualValues("2", idx.Get("a@mail.com").Id)
+	require.Nil(s.Get("1"))
+}
+
+func TestUniqueIndex_BulkUpsert(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	s, idx := uniqueState(t)
+	err := s.BulkUpsert([]Item{{Id: "1", IndexKey: "a"}, {Id: "2", IndexKey: "b"}})
+	require.NoError(err)
+
+	err = s.BulkUpsert([]Item{{Id: "3", IndexKey: "c"}, {Id: "4", IndexKey: "c"}})
+	require.ErrorIs(err, crud.ErrConstraintViolation)
+	require.Nil(s.Get("3"))
+
+	err = s.BulkUpsert([]Item{{Id: "1", IndexKey: "b"}, {Id: "2", IndexKey: "a"}})
+	require.NoError(err)
+	require.EqualValues("1", idx.Get("b").Id)
+	require.EqualValues("2", idx.Get("a").Id)
+}
+
+func uniqueState(t *testing.T) (*crud.State[Item], *index.Unique[Item]) {
+	t.Helper()
+
+	state := crud.New[Item]("state")
+	index := index.NewUnique[Item]("email", func(item *Item) string {
+		return item.IndexKey
+	})
+	state.SetHooks(index.Hooks())
+	tstate.ServeState(t, state)
+	return state, index
+}
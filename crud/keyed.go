@@ -0,0 +1,228 @@
+package crud
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+
+	"github.com/txix-open/walx/v2/state"
+)
+
+var (
+	ErrUnsupportedKey = errors.New("unsupported key type")
+	ErrInvalidKey     = errors.New("invalid key")
+)
+
+// Entity is the item of Keyed state stored together with its key
+type Entity[K comparable, T any] struct {
+	Key  K
+	Item T
+}
+
+// GetId returns the formatted key, it is empty if the key can't be formatted.
+// Keys are validated before write events are journaled and again when they are applied
+func (e Entity[K, T]) GetId() string {
+	id, _ := formatKey(e.Key)
+	return id
+}
+
+// Keyed is State with items identified by keys of arbitrary comparable type instead of string ids
+type Keyed[K comparable, T any] struct {
+	state *State[Entity[K, T]]
+	key   func(item *T) K
+}
+
+// NewKeyed returns ErrUnsupportedKey if K is not a string, an integer, encoding.TextMarshaler or fmt.Stringer,
+// because ids of items are formatted from keys and other types have no stable string form.
+// Formatting must be injective, writes of keys which MarshalText fails for are rejected with ErrInvalidKey
+func NewKeyed[K comparable, T any](name string, key func(item *T) K, opts ...Option) (*Keyed[K, T], error) {
+	err := checkKeyType[K]()
+	if err != nil {
+		return nil, err
+	}
+	s := New[Entity[K, T]](name, opts...)
+	s.checkId = func(entity *Entity[K, T]) error {
+		_, err := formatKey(entity.Key)
+		return err
+	}
+	return &Keyed[K, T]{
+		state: s,
+		key:   key,
+	}, nil
+}
+
+// Unwrap returns the underlying state, e.g. to set hooks, watch changes or take snapshots
+func (k *Keyed[K, T]) Unwrap() *State[Entity[K, T]] {
+	return k.state
+}
+
+// SetIdGenerator sets the function assigning key to items inserted by InsertWithNextId.
+// It is called inside the FSM with the next value of the state sequence, so leader and replicas assign identical keys
+func (k *Keyed[K, T]) SetIdGenerator(generateId func(item *T, seq uint64)) {
+	k.state.SetIdGenerator(func(entity *Entity[K, T], seq uint64) {
+		generateId(&entity.Item, seq)
+		entity.Key = k.key(&entity.Item)
+	})
+}
+
+func (k *Keyed[K, T]) Get(key K) *T {
+	id, err := formatKey(key)
+	if err != nil {
+		return nil
+	}
+	entity := k.state.Get(id)
+	if entity == nil {
+		return nil
+	}
+	return &entity.Item
+}
+
+func (k *Keyed[K, T]) All() []T {
+	return k.Find(func(elem *T) bool {
+		return true
+	})
+}
+
+func (k *Keyed[K, T]) Find(filter func(elem *T) bool) []T {
+	arr := make([]T, 0)
+	k.state.ForEach(func(entity *Entity[K, T]) {
+		if filter(&entity.Item) {
+			arr = append(arr, entity.Item)
+		}
+	})
+	return arr
+}
+
+func (k *Keyed[K, T]) ForEach(f func(elem *T)) {
+	k.state.ForEach(func(entity *Entity[K, T]) {
+		f(&entity.Item)
+	})
+}
+
+func (k *Keyed[K, T]) Insert(item T, opts ...WriteOption) error {
+	entity, err := k.entity(item)
+	if err != nil {
+		return err
+	}
+	return k.state.Insert(entity, opts...)
+}
+
+// InsertWithNextId inserts the item with key assigned by the generator and returns the key
+func (k *Keyed[K, T]) InsertWithNextId(item T, opts ...WriteOption) (K, error) {
+	var empty K
+	entity, err := k.state.insertWithGeneratedId(Entity[K, T]{Item: item}, opts)
+	if err != nil {
+		return empty, err
+	}
+	return entity.Key, nil
+}
+
+func (k *Keyed[K, T]) Upsert(item T, opts ...WriteOption) error {
+	entity, err := k.entity(item)
+	if err != nil {
+		return err
+	}
+	return k.state.Upsert(entity, opts...)
+}
+
+func (k *Keyed[K, T]) Update(item T) error {
+	entity, err := k.entity(item)
+	if err != nil {
+		return err
+	}
+	return k.state.Update(entity)
+}
+
+func (k *Keyed[K, T]) BulkUpsert(items []T) error {
+	entities := make([]Entity[K, T], 0, len(items))
+	for _, item := range items {
+		entity, err := k.entity(item)
+		if err != nil {
+			return err
+		}
+		entities = append(entities, entity)
+	}
+	return k.state.BulkUpsert(entities)
+}
+
+func (k *Keyed[K, T]) Delete(key K) (*T, error) {
+	id, err := formatKey(key)
+	if err != nil {
+		return nil, err
+	}
+	entity, err := k.state.Delete(id)
+	if err != nil {
+		return nil, err
+	}
+	return &entity.Item, nil
+}
+
+func (k *Keyed[K, T]) DeleteAll() error {
+	return k.state.DeleteAll()
+}
+
+func (k *Keyed[K, T]) StateName() string {
+	return k.state.StateName()
+}
+
+func (k *Keyed[K, T]) SetMutator(mutator state.Mutator) {
+	k.state.SetMutator(mutator)
+}
+
+func (k *Keyed[K, T]) Apply(log state.Log) (any, error) {
+	return k.state.Apply(log)
+}
+
+func (k *Keyed[K, T]) entity(item T) (Entity[K, T], error) {
+	entity := Entity[K, T]{
+		Key:  k.key(&item),
+		Item: item,
+	}
+	_, err := formatKey(entity.Key)
+	return entity, err
+}
+
+func checkKeyType[K comparable]() error {
+	var key K
+	switch any(key).(type) {
+	case encoding.TextMarshaler, fmt.Stringer:
+		return nil
+	}
+	switch reflect.TypeFor[K]().Kind() {
+	case reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return nil
+	default:
+		return fmt.Errorf("%w: %s", ErrUnsupportedKey, reflect.TypeFor[K]())
+	}
+}
+
+func formatKey[K comparable](key K) (string, error) {
+	switch k := any(key).(type) {
+	case string:
+		return k, nil
+	case encoding.TextMarshaler:
+		text, err := k.MarshalText()
+		if err != nil {
+			return "", fmt.Errorf("%w: %w", ErrInvalidKey, err)
+		}
+		return string(text), nil
+	case fmt.Stringer:
+		return k.String(), nil
+	}
+
+	value := reflect.ValueOf(key)
+	switch value.Kind() {
+	case reflect.String:
+		return value.String(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(value.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(value.Uint(), 10), nil
+	default:
+		return "", fmt.Errorf("%w: %s", ErrUnsupportedKey, value.Type())
+	}
+}
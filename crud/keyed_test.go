@@ -0,0 +1,197 @@
+package crud_test
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/txix-open/walx/v2"
+	"github.com/txix-open/walx/v2/crud"
+	"github.com/txix-open/walx/v2/state"
+	"github.com/txix-open/walx/v2/state/codec/json"
+	"github.com/txix-open/walx/v2/tstate"
+)
+
+type Order struct {
+	Id    int64
+	Title string
+}
+
+type LineKey struct {
+	OrderId int64
+	Line    int
+}
+
+func (k LineKey) String() string {
+	return fmt.Sprintf("%d/%d", k.OrderId, k.Line)
+}
+
+type OrderLine struct {
+	OrderId int64
+	Line    int
+	Amount  int
+}
+
+func TestKeyed(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	lines, err := crud.NewKeyed[LineKey]("lines", func(item *OrderLine) LineKey {
+		return LineKey{OrderId: item.OrderId, Line: item.Line}
+	})
+	require.NoError(err)
+	tstate.ServeState(t, lines)
+
+	err = lines.Insert(OrderLine{OrderId: 1, Line: 1, Amount: 10})
+	require.NoError(err)
+	err = lines.Insert(OrderLine{OrderId: 1, Line: 2, Amount: 20})
+	require.NoError(err)
+	err = lines.Insert(OrderLine{OrderId: 1, Line: 1, Amount: 30})
+	require.ErrorIs(err, crud.ErrAlreadyExists)
+
+	err = lines.Update(OrderLine{OrderId: 1, Line: 2, Amount: 25})
+	require.NoError(err)
+	require.EqualValues(25, lines.Get(LineKey{OrderId: 1, Line: 2}).Amount)
+	require.Nil(lines.Get(LineKey{OrderId: 2, Line: 1}))
+	require.Len(lines.All(), 2)
+
+	deleted, err := lines.Delete(LineKey{OrderId: 1, Line: 1})
+	require.NoError(err)
+	require.EqualValues(10, deleted.Amount)
+	_, err = lines.Delete(LineKey{OrderId: 1, Line: 1})
+	require.ErrorIs(err, crud.ErrNotFound)
+
+	_, err = lines.InsertWithNextId(OrderLine{})
+	require.ErrorIs(err, crud.ErrNoIdGenerator)
+}
+
+func TestKeyed_UnsupportedKey(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	type structKey struct {
+		A int
+	}
+	_, err := crud.NewKeyed[structKey]("items", func(item *OrderLine) structKey {
+		return structKey{A: item.Line}
+	})
+	require.ErrorIs(err, crud.ErrUnsupportedKey)
+	require.EqualError(err, fmt.Sprintf("%s: crud_test.structKey", crud.ErrUnsupportedKey))
+	_, err = crud.NewKeyed[time.Month]("items", func(item *OrderLine) time.Month {
+		return time.Month(item.Line)
+	})
+	require.NoError(err)
+}
+
+type lineNumber int
+
+func (n lineNumber) MarshalText() ([]byte, error) {
+	if n < 0 {
+		return nil, errors.New("negative line number")
+	}
+	return []byte(strconv.Itoa(int(n))), nil
+}
+
+func TestKeyed_InvalidKey(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	lines, err := crud.NewKeyed[lineNumber]("lines", func(item *OrderLine) lineNumber {
+		return lineNumber(item.Line)
+	})
+	require.NoError(err)
+	lines.SetIdGenerator(func(item *OrderLine, seq uint64) {
+		item.Line = -int(seq)
+	})
+	tstate.ServeState(t, lines)
+
+	err = lines.Insert(OrderLine{Line: -1})
+	require.ErrorIs(err, crud.ErrInvalidKey)
+	err = lines.Upsert(OrderLine{Line: -1})
+	require.ErrorIs(err, crud.ErrInvalidKey)
+	_, err = lines.Delete(-1)
+	require.ErrorIs(err, crud.ErrInvalidKey)
+	require.Nil(lines.Get(-1))
+
+	_, err = lines.InsertWithNextId(OrderLine{})
+	require.ErrorIs(err, crud.ErrInvalidKey)
+	require.Empty(lines.All())
+
+	err = lines.Insert(OrderLine{Line: 1})
+	require.NoError(err)
+	require.Len(lines.All(), 1)
+}
+
+func TestKeyed_InsertWithNextId(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	dir := newDir()
+	t.Cleanup(func() {
+		_ = os.RemoveAll(dir)
+	})
+
+	orders, s := openOrders(t, dir)
+	go func() {
+		err := s.Run(context.Background())
+		require.NoError(err)
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	for i, title := range []string{"first", "second"} {
+		id, err := orders.InsertWithNextId(Order{Title: title})
+		require.NoError(err)
+		require.EqualValues(i+1, id)
+		require.EqualValues(title, orders.Get(id).Title)
+	}
+	err := s.Close()
+	require.NoError(err)
+
+	restored, s := openOrders(t, dir)
+	require.Len(restored.All(), 2)
+	require.EqualValues("second", restored.Get(2).Title)
+	go func() {
+		err := s.Run(context.Background())
+		require.NoError(err)
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	id, err := restored.InsertWithNextId(Order{Title: "third"})
+	require.NoError(err)
+	require.EqualValues(3, id)
+	err = s.Close()
+	require.NoError(err)
+}
+
+func openOrders(t *testing.T, dir string) (*crud.Keyed[int64, Order], *state.State) {
+	t.Helper()
+	require := require.New(t)
+
+	orders, err := crud.NewKeyed[int64]("orders", func(item *Order) int64 {
+		return item.Id
+	})
+	require.NoError(err)
+	orders.SetIdGenerator(func(item *Order, seq uint64) {
+		item.Id = int64(seq)
+	})
+	wal, err := walx.Open(dir)
+	require.NoError(err)
+	s := state.New(wal, orders, json.NewCodec(), "test")
+	orders.SetMutator(s)
+	err = s.Recovery(context.Background())
+	require.NoError(err)
+	return orders, s
+}
+
+func newDir() string {
+	d := make([]byte, 8)
+	_, _ = rand.Read(d)
+	return hex.EncodeToString(d)
+}
@@ -0,0 +1,129 @@
+package crud
+
+import (
+	"time"
+)
+
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+type options struct {
+	clock              Clock
+	expirationInterval time.Duration
+	watchBufferSize    int
+}
+
+func newOptions() *options {
+	return &options{
+		clock:              systemClock{},
+		expirationInterval: 1 * time.Second,
+		watchBufferSize:    1024,
+	}
+}
+
+type Option func(o *options)
+
+func WithClock(clock Clock) Option {
+	return func(o *options) {
+		o.clock = clock
+	}
+}
+
+func ExpirationInterval(interval time.Duration) Option {
+	return func(o *options) {
+		o.expirationInterval = interval
+	}
+}
+
+// WatchBufferSize sets the number of the latest changes kept for watchers, values less than 1 are ignored
+func WatchBufferSize(size int) Option {
+	return func(o *options) {
+		if size > 0 {
+			o.watchBufferSize = size
+		}
+	}
+}
+
+type writeOptions struct {
+	ttl       time.Duration
+	expiresAt time.Time
+}
+
+type WriteOption func(o *writeOptions)
+
+func WithTTL(ttl time.Duration) WriteOption {
+	return func(o *writeOptions) {
+		o.ttl = ttl
+	}
+}
+
+func WithExpiresAt(expiresAt time.Time) WriteOption {
+	return func(o *writeOptions) {
+		o.expiresAt = expiresAt
+	}
+}
+
+type watchOptions struct {
+	fromIndex          uint64
+	channelSize        int
+	skipOnSlowConsumer bool
+}
+
+func newWatchOptions() *watchOptions {
+	return &watchOptions{
+		channelSize: 64,
+	}
+}
+
+type WatchOption func(o *watchOptions)
+
+// FromIndex resumes watching from changes with WAL index not less than index
+func FromIndex(index uint64) WatchOption {
+	return func(o *watchOptions) {
+		o.fromIndex = index
+	}
+}
+
+// ChannelSize sets the buffer size of the changes channel, negative values are ignored
+func ChannelSize(size int) WatchOption {
+	return func(o *watchOptions) {
+		if size >= 0 {
+			o.channelSize = size
+		}
+	}
+}
+
+// SkipOnSlowConsumer makes a lagging watcher continue from the oldest available change instead of disconnecting
+func SkipOnSlowConsumer() WatchOption {
+	return func(o *watchOptions) {
+		o.skipOnSlowConsumer = true
+	}
+}
+
+type importOptions struct {
+	dryRun   bool
+	progress func(progress ImportProgress)
+}
+
+type ImportOption func(o *importOptions)
+
+// DryRun makes Import only compute the diff against the current state without journaling anything
+func DryRun() ImportOption {
+	return func(o *importOptions) {
+		o.dryRun = true
+	}
+}
+
+// OnProgress sets the callback called after each imported batch
+func OnProgress(progress func(progress ImportProgress)) ImportOption {
+	return func(o *importOptions) {
+		o.progress = progress
+	}
+}
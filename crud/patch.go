@@ -0,0 +1,81 @@
+package crud
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/txix-open/walx/v2/state"
+)
+
+var (
+	errPatchNotObject = errors.New("patch must be a json object")
+	jsonNull          = []byte("null")
+	jsonEmptyObject   = []byte("{}")
+)
+
+type losslessEncoder interface {
+	EncodeLossless(w io.Writer, value any) error
+}
+
+// marshalLossless encodes item with the codec field naming, but without loss of float precision
+// if the codec supports it, e.g. json.Codec
+func marshalLossless(codec state.Codec, item any) ([]byte, error) {
+	encoder, ok := codec.(losslessEncoder)
+	if !ok {
+		return state.MarshalEvent(codec, item)
+	}
+	buff := bytes.NewBuffer(nil)
+	err := encoder.EncodeLossless(buff, item)
+	if err != nil {
+		return nil, err
+	}
+	return buff.Bytes(), nil
+}
+
+// mergePatch applies RFC 7396 json merge patch to target.
+// Values are merged on raw json level, so values of target and patch are kept as encoded.
+func mergePatch(target []byte, patch []byte) ([]byte, error) {
+	if !isJsonObject(patch) {
+		return nil, errPatchNotObject
+	}
+
+	targetFields := make(map[string]json.RawMessage)
+	err := json.Unmarshal(target, &targetFields)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal target: %w", err)
+	}
+	patchFields := make(map[string]json.RawMessage)
+	err = json.Unmarshal(patch, &patchFields)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal patch: %w", err)
+	}
+
+	for key, value := range patchFields {
+		switch {
+		case bytes.Equal(bytes.TrimSpace(value), jsonNull):
+			delete(targetFields, key)
+		case isJsonObject(value):
+			base := targetFields[key]
+			if !isJsonObject(base) {
+				base = jsonEmptyObject
+			}
+			merged, err := mergePatch(base, value)
+			if err != nil {
+				return nil, fmt.Errorf("field %s: %w", key, err)
+			}
+			targetFields[key] = merged
+		default:
+			targetFields[key] = value
+		}
+	}
+
+	return json.Marshal(targetFields)
+}
+
+func isJsonObject(data []byte) bool {
+	data = bytes.TrimSpace(data)
+	return len(data) > 0 && data[0] == '{'
+}
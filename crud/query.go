@@ -0,0 +1,359 @@
+package crud
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"iter"
+	"slices"
+	"strings"
+)
+
+var (
+	ErrUnknownIndex    = errors.New("unknown index")
+	ErrIndexNotOrdered = errors.New("index is not ordered")
+)
+
+// Index is implemented by indexes which can be used by queries to avoid full scans
+type Index[T WithId] interface {
+	// Lookup returns items indexed by key
+	Lookup(key string) []*T
+	// Keys returns keys the item is indexed by
+	Keys(item *T) []string
+}
+
+// OrderedIndex is implemented by indexes which keep items ordered by key, e.g. index.Sorted.
+// Queries use it for OrderByIndex to stream items without sorting
+type OrderedIndex[T WithId] interface {
+	Index[T]
+	// Compare compares items by their keys
+	Compare(a *T, b *T) int
+	// Scan iterates all items ordered by key, items with equal keys are ordered by id
+	Scan(descending bool) iter.Seq[*T]
+}
+
+// RangeIndex is implemented by ordered indexes with keys of type K, it is used by range conditions
+type RangeIndex[T WithId, K cmp.Ordered] interface {
+	OrderedIndex[T]
+	// Key returns the key the item is ordered by
+	Key(item *T) K
+	// Between iterates items with keys in [from, to), nil bound means unbounded
+	Between(from *K, to *K, descending bool) iter.Seq[*T]
+}
+
+type Condition[T WithId] struct {
+	index string
+	key   string
+	match func(item *T) bool
+	// scan and inRange are set for range conditions, they return false if the index doesn't support ranges
+	scan    func(index Index[T], descending bool) (iter.Seq[*T], bool)
+	inRange func(index Index[T], item *T) bool
+}
+
+// Eq matches items indexed by key in the registered index
+func Eq[T WithId](index string, key string) Condition[T] {
+	return Condition[T]{
+		index: index,
+		key:   key,
+	}
+}
+
+// Between matches items with keys in [from, to) of the registered RangeIndex
+func Between[T WithId, K cmp.Ordered](index string, from K, to K) Condition[T] {
+	return rangeCondition[T](index, &from, &to)
+}
+
+// Gte matches items with keys greater than or equal to from of the registered RangeIndex
+func Gte[T WithId, K cmp.Ordered](index string, from K) Condition[T] {
+	return rangeCondition[T](index, &from, nil)
+}
+
+// Lt matches items with keys less than to of the registered RangeIndex
+func Lt[T WithId, K cmp.Ordered](index string, to K) Condition[T] {
+	return rangeCondition[T, K](index, nil, &to)
+}
+
+// Match matches items satisfying predicate, it is always evaluated by scanning candidates
+func Match[T WithId](match func(item *T) bool) Condition[T] {
+	return Condition[T]{
+		match: match,
+	}
+}
+
+func rangeCondition[T WithId, K cmp.Ordered](index string, from *K, to *K) Condition[T] {
+	return Condition[T]{
+		index: index,
+		scan: func(index Index[T], descending bool) (iter.Seq[*T], bool) {
+			ranged, ok := index.(RangeIndex[T, K])
+			if !ok {
+				return nil, false
+			}
+			return ranged.Between(from, to, descending), true
+		},
+		inRange: func(index Index[T], item *T) bool {
+			key := index.(RangeIndex[T, K]).Key(item)
+			return (from == nil || cmp.Compare(key, *from) >= 0) && (to == nil || cmp.Compare(key, *to) < 0)
+		},
+	}
+}
+
+type Query[T WithId] struct {
+	state      *State[T]
+	conditions []Condition[T]
+	compare    func(a *T, b *T) int
+	orderIndex string
+	descending bool
+	after      *T
+	limit      int
+}
+
+// RegisterIndex makes the index available for conditions of queries
+func (s *State[T]) RegisterIndex(name string, index Index[T]) {
+	if s.indexes == nil {
+		s.indexes = make(map[string]Index[T])
+	}
+	s.indexes[name] = index
+}
+
+func (s *State[T]) Query() *Query[T] {
+	return &Query[T]{
+		state: s,
+	}
+}
+
+func (q *Query[T]) Where(conditions ...Condition[T]) *Query[T] {
+	q.conditions = append(q.conditions, conditions...)
+	return q
+}
+
+// OrderBy sets the order of items, items are ordered by id if compare is not set or returns 0
+func (q *Query[T]) OrderBy(compare func(a *T, b *T) int) *Query[T] {
+	q.compare = compare
+	q.orderIndex = ""
+	return q
+}
+
+// OrderByIndex orders items by keys of the registered OrderedIndex and then by id.
+// Items are streamed from the index, so they are not collected and sorted
+func (q *Query[T]) OrderByIndex(index string, descending bool) *Query[T] {
+	q.orderIndex = index
+	q.descending = descending
+	q.compare = nil
+	return q
+}
+
+// After skips items up to cursor inclusively, cursor is usually the last item of the previous page
+func (q *Query[T]) After(cursor T) *Query[T] {
+	q.after = &cursor
+	return q
+}
+
+func (q *Query[T]) Limit(limit int) *Query[T] {
+	q.limit = limit
+	return q
+}
+
+// Items evaluates the query on the current snapshot of the state.
+// If the query is ordered by index, items are streamed lazily, otherwise matched items are sorted first.
+// Index conditions and OrderByIndex take candidates from live indexes, which are only eventually consistent
+// with the snapshot: returned items are taken from the snapshot and satisfy conditions, but an item whose indexed key
+// was changed after the snapshot may be missed or streamed out of order. The longer the stream is iterated,
+// the more writes it may miss, use conditions without indexes if results must be exact
+func (q *Query[T]) Items() (iter.Seq[T], error) {
+	err := q.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := q.state.Snapshot()
+	if q.orderIndex != "" {
+		return q.stream(snapshot), nil
+	}
+
+	items := q.match(snapshot)
+	slices.SortFunc(items, q.order)
+	if q.after != nil {
+		pos, _ := slices.BinarySearchFunc(items, q.after, q.order)
+		for pos < len(items) && q.order(items[pos], q.after) <= 0 {
+			pos++
+		}
+		items = items[pos:]
+	}
+	if q.limit > 0 && len(items) > q.limit {
+		items = items[:q.limit]
+	}
+
+	return func(yield func(T) bool) {
+		for _, item := range items {
+			if !yield(*item) {
+				return
+			}
+		}
+	}, nil
+}
+
+// Count returns the number of items matching conditions ignoring After and Limit.
+// It is consistent with the snapshot as much as Items
+func (q *Query[T]) Count() (int, error) {
+	err := q.validate()
+	if err != nil {
+		return 0, err
+	}
+	return len(q.match(q.state.Snapshot())), nil
+}
+
+func (q *Query[T]) validate() error {
+	for _, condition := range q.conditions {
+		if condition.match != nil {
+			continue
+		}
+		index, ok := q.state.indexes[condition.index]
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrUnknownIndex, condition.index)
+		}
+		if condition.scan == nil {
+			continue
+		}
+		_, ok = condition.scan(index, false)
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrIndexNotOrdered, condition.index)
+		}
+	}
+
+	if q.orderIndex == "" {
+		return nil
+	}
+	index, ok := q.state.indexes[q.orderIndex]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownIndex, q.orderIndex)
+	}
+	_, ok = index.(OrderedIndex[T])
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrIndexNotOrdered, q.orderIndex)
+	}
+	return nil
+}
+
+// stream yields items in the order of the index, a range condition on the same index bounds the scan
+func (q *Query[T]) stream(snapshot *Snapshot[T]) iter.Seq[T] {
+	index := q.state.indexes[q.orderIndex]
+	source := index.(OrderedIndex[T]).Scan(q.descending)
+	for _, condition := range q.conditions {
+		if condition.scan != nil && condition.index == q.orderIndex {
+			source, _ = condition.scan(index, q.descending)
+			break
+		}
+	}
+
+	return func(yield func(T) bool) {
+		yielded := 0
+		for candidate := range source {
+			item := snapshot.Get((*candidate).GetId())
+			if item == nil || !q.matches(item) {
+				continue
+			}
+			if q.after != nil && q.order(item, q.after) <= 0 {
+				continue
+			}
+			if !yield(*item) {
+				return
+			}
+			yielded++
+			if q.limit > 0 && yielded == q.limit {
+				return
+			}
+		}
+	}
+}
+
+func (q *Query[T]) match(snapshot *Snapshot[T]) []*T {
+	items := make([]*T, 0)
+	candidates := q.candidates()
+	if candidates == nil {
+		snapshot.ForEach(func(item *T) {
+			if q.matches(item) {
+				items = append(items, item)
+			}
+		})
+		return items
+	}
+
+	for candidate := range candidates {
+		item := snapshot.Get((*candidate).GetId())
+		if item != nil && q.matches(item) {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// candidates returns items of the most selective Eq condition, items of the first range condition
+// if there are no Eq conditions or nil if there are no index conditions
+func (q *Query[T]) candidates() iter.Seq[*T] {
+	var best []*T
+	for _, condition := range q.conditions {
+		if condition.match != nil || condition.scan != nil {
+			continue
+		}
+		items := q.state.indexes[condition.index].Lookup(condition.key)
+		if items == nil {
+			return slices.Values([]*T{})
+		}
+		if best == nil || len(items) < len(best) {
+			best = items
+		}
+	}
+	if best != nil {
+		return slices.Values(best)
+	}
+
+	for _, condition := range q.conditions {
+		if condition.scan != nil {
+			items, _ := condition.scan(q.state.indexes[condition.index], false)
+			return items
+		}
+	}
+	return nil
+}
+
+func (q *Query[T]) matches(item *T) bool {
+	for _, condition := range q.conditions {
+		switch {
+		case condition.match != nil:
+			if !condition.match(item) {
+				return false
+			}
+		case condition.inRange != nil:
+			if !condition.inRange(q.state.indexes[condition.index], item) {
+				return false
+			}
+		default:
+			keys := q.state.indexes[condition.index].Keys(item)
+			if !slices.Contains(keys, condition.key) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (q *Query[T]) order(a *T, b *T) int {
+	if q.orderIndex != "" {
+		c := q.state.indexes[q.orderIndex].(OrderedIndex[T]).Compare(a, b)
+		if c == 0 {
+			c = strings.Compare((*a).GetId(), (*b).GetId())
+		}
+		if q.descending {
+			return -c
+		}
+		return c
+	}
+
+	if q.compare != nil {
+		c := q.compare(a, b)
+		if c != 0 {
+			return c
+		}
+	}
+	return strings.Compare((*a).GetId(), (*b).GetId())
+}
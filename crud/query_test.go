@@ -0,0 +1,231 @@
+package crud_test
+
+import (
+	"cmp"
+	"iter"
+	"slices"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/txix-open/walx/v2/crud"
+	"github.com/txix-open/walx/v2/crud/index"
+	"github.com/txix-open/walx/v2/tstate"
+)
+
+type Employee struct {
+	Id     string
+	Dept   string
+	City   string
+	Salary int
+}
+
+func (e Employee) GetId() string {
+	return e.Id
+}
+
+type countingIndex struct {
+	crud.Index[Employee]
+	lookups int
+}
+
+func (c *countingIndex) Lookup(key string) []*Employee {
+	c.lookups++
+	return c.Index.Lookup(key)
+}
+
+func collectIds(t *testing.T, query *crud.Query[Employee]) []string {
+	t.Helper()
+
+	items, err := query.Items()
+	require.NoError(t, err)
+	ids := make([]string, 0)
+	for item := range items {
+		ids = append(ids, item.Id)
+	}
+	return ids
+}
+
+func TestQuery(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	s := crud.New[Employee]("employees")
+	byDept := index.NewHash[Employee](func(item *Employee) string {
+		return item.Dept
+	})
+	byCity := index.NewHash[Employee](func(item *Employee) string {
+		return item.City
+	})
+	s.SetHooks(crud.MergeHooks(byDept.Hooks(), byCity.Hooks()))
+	s.RegisterIndex("dept", byDept)
+	s.RegisterIndex("city", byCity)
+	tstate.ServeState(t, s)
+
+	employees := make([]Employee, 0)
+	for i := range 20 {
+		employees = append(employees, Employee{
+			Id:     strconv.Itoa(100 + i),
+			Dept:   []string{"dev", "ops"}[i%2],
+			City:   []string{"msk", "spb", "kzn", "nsk", "ekb"}[i%5],
+			Salary: 1000 - i*10,
+		})
+	}
+	err := s.BulkUpsert(employees)
+	require.NoError(err)
+
+	require.Equal([]string{"100", "110"}, collectIds(t, s.Query().Where(
+		crud.Eq[Employee]("dept", "dev"),
+		crud.Eq[Employee]("city", "msk"),
+	)))
+
+	bySalary := func(a *Employee, b *Employee) int {
+		return cmp.Compare(a.Salary, b.Salary)
+	}
+	query := s.Query().
+		Where(crud.Eq[Employee]("dept", "dev"), crud.Match(func(item *Employee) bool {
+			return item.Salary > 850
+		})).
+		OrderBy(bySalary)
+	count, err := query.Count()
+	require.NoError(err)
+	require.EqualValues(8, count)
+	require.Equal([]string{"114", "112", "110", "108", "106", "104", "102", "100"}, collectIds(t, query))
+
+	page := collectIds(t, query.Limit(3))
+	require.Equal([]string{"114", "112", "110"}, page)
+	cursor := s.Get(page[len(page)-1])
+	page = collectIds(t, query.After(*cursor).Limit(3))
+	require.Equal([]string{"108", "106", "104"}, page)
+	cursor = s.Get(page[len(page)-1])
+	require.Equal([]string{"102", "100"}, collectIds(t, query.After(*cursor)))
+
+	all := collectIds(t, s.Query())
+	require.Len(all, 20)
+	require.True(slices.IsSorted(all))
+	require.Empty(collectIds(t, s.Query().Where(crud.Eq[Employee]("city", "unknown"))))
+
+	_, err = s.Query().Where(crud.Eq[Employee]("salary", "1")).Items()
+	require.ErrorIs(err, crud.ErrUnknownIndex)
+}
+
+func TestQuery_PicksMostSelectiveIndex(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	s := crud.New[Employee]("employees")
+	byDept := index.NewHash[Employee](func(item *Employee) string {
+		return item.Dept
+	})
+	byId := index.NewUnique[Employee]("id", func(item *Employee) string {
+		return item.Id
+	})
+	s.SetHooks(crud.MergeHooks(byDept.Hooks(), byId.Hooks()))
+	dept := &countingIndex{Index: byDept}
+	s.RegisterIndex("dept", dept)
+	s.RegisterIndex("id", byId)
+	tstate.ServeState(t, s)
+
+	for i := range 10 {
+		err := s.Insert(Employee{Id: strconv.Itoa(i), Dept: "dev"})
+		require.NoError(err)
+	}
+
+	scanned := 0
+	ids := collectIds(t, s.Query().Where(
+		crud.Eq[Employee]("dept", "dev"),
+		crud.Eq[Employee]("id", "5"),
+		crud.Match(func(item *Employee) bool {
+			scanned++
+			return true
+		}),
+	))
+	require.Equal([]string{"5"}, ids)
+	require.EqualValues(1, scanned)
+	require.EqualValues(1, dept.lookups)
+}
+
+type countingSorted struct {
+	*index.Sorted[Employee, int]
+	scanned int
+}
+
+func (c *countingSorted) Between(from *int, to *int, descending bool) iter.Seq[*Employee] {
+	return func(yield func(*Employee) bool) {
+		for item := range c.Sorted.Between(from, to, descending) {
+			c.scanned++
+			if !yield(item) {
+				return
+			}
+		}
+	}
+}
+
+func (c *countingSorted) Scan(descending bool) iter.Seq[*Employee] {
+	return c.Between(nil, nil, descending)
+}
+
+func TestQuery_SortedIndex(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	s := crud.New[Employee]("employees")
+	byDept := index.NewHash[Employee](func(item *Employee) string {
+		return item.Dept
+	})
+	bySalary := &countingSorted{Sorted: index.NewSorted[Employee](func(item *Employee) int {
+		return item.Salary
+	})}
+	s.SetHooks(crud.MergeHooks(byDept.Hooks(), bySalary.Hooks()))
+	s.RegisterIndex("dept", byDept)
+	s.RegisterIndex("salary", bySalary)
+	tstate.ServeState(t, s)
+
+	employees := make([]Employee, 0)
+	for i := range 200 {
+		employees = append(employees, Employee{
+			Id:     strconv.Itoa(1000 + i),
+			Dept:   []string{"dev", "ops"}[i%2],
+			Salary: 1000 + i*10,
+		})
+	}
+	err := s.BulkUpsert(employees)
+	require.NoError(err)
+
+	query := s.Query().
+		Where(crud.Between[Employee]("salary", 1500, 1600), crud.Eq[Employee]("dept", "dev")).
+		OrderByIndex("salary", true)
+	count, err := query.Count()
+	require.NoError(err)
+	require.EqualValues(5, count)
+	require.Equal([]string{"1058", "1056", "1054", "1052", "1050"}, collectIds(t, query))
+
+	bySalary.scanned = 0
+	page := collectIds(t, query.Limit(2))
+	require.Equal([]string{"1058", "1056"}, page)
+	require.EqualValues(4, bySalary.scanned)
+	cursor := s.Get(page[len(page)-1])
+	require.Equal([]string{"1054", "1052"}, collectIds(t, query.After(*cursor)))
+
+	bySalary.scanned = 0
+	top := collectIds(t, s.Query().OrderByIndex("salary", true).Limit(3))
+	require.Equal([]string{"1199", "1198", "1197"}, top)
+	require.EqualValues(3, bySalary.scanned)
+
+	require.Equal(
+		[]string{"1197", "1198", "1199"},
+		collectIds(t, s.Query().Where(crud.Gte[Employee]("salary", 2970))),
+	)
+	require.Equal(
+		[]string{"1000", "1001"},
+		collectIds(t, s.Query().Where(crud.Lt[Employee]("salary", 1020))),
+	)
+	require.Equal([]string{"1050"}, collectIds(t, s.Query().Where(crud.Eq[Employee]("salary", "1500"))))
+
+	_, err = s.Query().OrderByIndex("dept", false).Items()
+	require.ErrorIs(err, crud.ErrIndexNotOrdered)
+	_, err = s.Query().Where(crud.Gte[Employee]("dept", 1)).Items()
+	require.ErrorIs(err, crud.ErrIndexNotOrdered)
+	_, err = s.Query().Where(crud.Gte[Employee]("salary", "1")).Items()
+	require.ErrorIs(err, crud.ErrIndexNotOrdered)
+}
@@ -0,0 +1,79 @@
+package crud
+
+import (
+	"time"
+)
+
+// Snapshot is an immutable point-in-time view of the state.
+// It is taken without locks and can be read concurrently with writes
+type Snapshot[T WithId] struct {
+	records  hamt[record[T]]
+	index    uint64
+	sequence uint64
+	now      time.Time
+}
+
+// Snapshot returns the view of the state after the last applied WAL entry.
+// Items expired at the moment of the call are hidden
+func (s *State[T]) Snapshot() *Snapshot[T] {
+	snapshot := *s.snapshot.Load()
+	snapshot.now = s.options.clock.Now()
+	return &snapshot
+}
+
+func (s *State[T]) publish() {
+	s.snapshot.Store(&Snapshot[T]{
+		records:  s.records,
+		index:    s.index,
+		sequence: s.sequence,
+	})
+}
+
+// Index returns the WAL index of the last entry applied before the snapshot was taken
+func (s *Snapshot[T]) Index() uint64 {
+	return s.index
+}
+
+func (s *Snapshot[T]) Get(id string) *T {
+	item, _ := s.GetWithVersion(id)
+	return item
+}
+
+func (s *Snapshot[T]) GetWithVersion(id string) (*T, uint64) {
+	r, ok := s.records.get(id)
+	if !ok || r.isExpired(s.now) {
+		return nil, 0
+	}
+	return r.item, r.version
+}
+
+// ExpiresAt returns the expiration time of the item or zero time if the item never expires
+func (s *Snapshot[T]) ExpiresAt(id string) time.Time {
+	r, _ := s.records.get(id)
+	return r.expiresAt
+}
+
+func (s *Snapshot[T]) All() []T {
+	return s.Find(func(elem *T) bool {
+		return true
+	})
+}
+
+func (s *Snapshot[T]) Find(filter func(elem *T) bool) []T {
+	arr := make([]T, 0)
+	s.ForEach(func(elem *T) {
+		if filter(elem) {
+			arr = append(arr, *elem)
+		}
+	})
+	return arr
+}
+
+func (s *Snapshot[T]) ForEach(f func(elem *T)) {
+	s.records.forEach(func(_ string, r record[T]) bool {
+		if !r.isExpired(s.now) {
+			f(r.item)
+		}
+		return true
+	})
+}
@@ -0,0 +1,182 @@
+package crud_test
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/txix-open/walx/v2/crud"
+	"github.com/txix-open/walx/v2/state"
+	"github.com/txix-open/walx/v2/tstate"
+)
+
+func TestState_Snapshot(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	s := crud.New[Item1]("items")
+	tstate.ServeState(t, s)
+
+	err := s.Insert(Item1{Id: "1", X: "a"})
+	require.NoError(err)
+	snapshot := s.Snapshot()
+	require.Positive(snapshot.Index())
+
+	err = s.Update(Item1{Id: "1", X: "b"})
+	require.NoError(err)
+	err = s.Insert(Item1{Id: "2", X: "c"})
+	require.NoError(err)
+
+	require.EqualValues("a", snapshot.Get("1").X)
+	require.Nil(snapshot.Get("2"))
+	require.Len(snapshot.All(), 1)
+
+	latest := s.Snapshot()
+	require.Greater(latest.Index(), snapshot.Index())
+	require.EqualValues("b", latest.Get("1").X)
+	require.Len(latest.All(), 2)
+}
+
+func TestState_SnapshotConsistency(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	s := crud.New[Item1]("items")
+	tstate.ServeState(t, s)
+
+	expected := make(map[string]string)
+	rnd := rand.New(rand.NewSource(1))
+	for i := range 3000 {
+		id := strconv.Itoa(rnd.Intn(1000))
+		if rnd.Intn(4) == 0 {
+			_, err := s.Delete(id)
+			_, exists := expected[id]
+			if exists {
+				require.NoError(err)
+			} else {
+				require.ErrorIs(err, crud.ErrNotFound)
+			}
+			delete(expected, id)
+			continue
+		}
+		value := fmt.Sprintf("value_%d", i)
+		err := s.Upsert(Item1{Id: id, X: value})
+		require.NoError(err)
+		expected[id] = value
+	}
+
+	snapshot := s.Snapshot()
+	actual := make(map[string]string)
+	for _, item := range snapshot.All() {
+		actual[item.Id] = item.X
+	}
+	require.Equal(expected, actual)
+	for id, value := range expected {
+		require.EqualValues(value, snapshot.Get(id).X)
+	}
+}
+
+// mutexState keeps items the way crud did before snapshots: in a map guarded by RWMutex
+type mutexState struct {
+	mutator state.Mutator
+	items   map[string]*Item1
+	lock    *sync.RWMutex
+}
+
+func (s *mutexState) SetMutator(mutator state.Mutator) {
+	s.mutator = mutator
+}
+
+func (s *mutexState) Apply(log state.Log) (any, error) {
+	item, err := state.UnmarshalEvent[Item1](log)
+	if err != nil {
+		return nil, err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.items[item.Id] = &item
+	return item, nil
+}
+
+func (s *mutexState) Upsert(item Item1) error {
+	_, err := state.Apply[Item1](s.mutator, item)
+	return err
+}
+
+func (s *mutexState) All() []Item1 {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	arr := make([]Item1, 0)
+	for _, item := range s.items {
+		arr = append(arr, *item)
+	}
+	return arr
+}
+
+// BenchmarkAll measures full scans while a writer continuously upserts items, like readers and writers of bench workload do.
+// writes/op shows how many writes were applied during one scan
+func BenchmarkAll(b *testing.B) {
+	const items = 10000
+
+	b.Run("mutex", func(b *testing.B) {
+		s := &mutexState{
+			items: make(map[string]*Item1),
+			lock:  &sync.RWMutex{},
+		}
+		tstate.ServeState(b, s)
+		runAllBenchmark(b, items, s.Upsert, s.All)
+	})
+
+	b.Run("snapshot", func(b *testing.B) {
+		s := crud.New[Item1]("items")
+		tstate.ServeState(b, s)
+		runAllBenchmark(b, items, func(item Item1) error {
+			return s.Upsert(item)
+		}, s.All)
+	})
+}
+
+func runAllBenchmark(b *testing.B, items int, upsert func(item Item1) error, all func() []Item1) {
+	b.Helper()
+
+	for i := range items {
+		err := upsert(Item1{Id: strconv.Itoa(i)})
+		require.NoError(b, err)
+	}
+
+	done := make(chan struct{})
+	writes := &atomic.Int64{}
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			id := strconv.Itoa(i % items)
+			_ = upsert(Item1{Id: id, X: id})
+			writes.Add(1)
+		}
+	}()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for b.Loop() {
+		all()
+	}
+	b.StopTimer()
+	close(done)
+	wg.Wait()
+
+	b.ReportMetric(float64(writes.Load())/float64(b.N), "writes/op")
+}
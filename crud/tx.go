@@ -0,0 +1,168 @@
+package crud
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/txix-open/walx/v2/state"
+)
+
+var (
+	ErrEmptyTx = errors.New("transaction has no operations")
+)
+
+// Tx records operations which are journaled as a single WAL entry and applied all-or-nothing
+type Tx[T WithId] struct {
+	state *State[T]
+	ops   []request[T]
+}
+
+func (tx *Tx[T]) Insert(item T, opts ...WriteOption) {
+	tx.ops = append(tx.ops, request[T]{
+		InsertRequest: &item,
+		ExpiresAt:     tx.state.expiresAt(opts),
+		Timestamp:     tx.state.timestamp(),
+	})
+}
+
+func (tx *Tx[T]) Upsert(item T, opts ...WriteOption) {
+	tx.ops = append(tx.ops, request[T]{
+		UpsertRequest: &item,
+		ExpiresAt:     tx.state.expiresAt(opts),
+		Timestamp:     tx.state.timestamp(),
+	})
+}
+
+func (tx *Tx[T]) Update(item T) {
+	tx.UpdateIfVersion(item, 0)
+}
+
+func (tx *Tx[T]) UpdateIfVersion(item T, version uint64) {
+	tx.ops = append(tx.ops, request[T]{
+		UpdateRequest:   &item,
+		ExpectedVersion: version,
+		Timestamp:       tx.state.timestamp(),
+	})
+}
+
+func (tx *Tx[T]) Delete(id string) {
+	tx.DeleteIfVersion(id, 0)
+}
+
+func (tx *Tx[T]) DeleteIfVersion(id string, version uint64) {
+	tx.ops = append(tx.ops, request[T]{
+		DeleteRequest:   id,
+		ExpectedVersion: version,
+		Timestamp:       tx.state.timestamp(),
+	})
+}
+
+type txState[T WithId] struct {
+	effects []func()
+	items   map[string]*T
+}
+
+// Tx collects operations recorded by f and applies them atomically.
+// If any operation fails, the state is rolled back and the error of the operation is returned.
+// Hooks and watchers are notified only after commit
+func (s *State[T]) Tx(f func(tx *Tx[T]) error) error {
+	tx := &Tx[T]{
+		state: s,
+	}
+	err := f(tx)
+	if err != nil {
+		return err
+	}
+	if len(tx.ops) == 0 {
+		return ErrEmptyTx
+	}
+
+	_, err = state.Apply[nothing](s.mutator, request[T]{
+		TxRequest: tx.ops,
+	})
+	return err
+}
+
+func (s *State[T]) applyTx(codec state.Codec, ops []request[T]) (any, error) {
+	if s.tx != nil {
+		return nil, errors.New("nested transactions are not supported")
+	}
+
+	records, version, sequence := s.records, s.version, s.sequence
+	s.tx = &txState[T]{
+		items: make(map[string]*T),
+	}
+	defer func() {
+		s.tx = nil
+	}()
+	rollback := func() {
+		s.records, s.version, s.sequence = records, version, sequence
+	}
+
+	for i, op := range ops {
+		_, err := s.handle(codec, op)
+		if err != nil {
+			rollback()
+			return nil, fmt.Errorf("operation %d: %w", i, err)
+		}
+	}
+
+	// constraints are checked once for all written items, because indexes are updated by hooks after commit
+	written := make([]*T, 0, len(s.tx.items))
+	for _, item := range s.tx.items {
+		written = append(written, item)
+	}
+	err := s.runConstraints(written)
+	if err != nil {
+		rollback()
+		return nil, err
+	}
+
+	effects := s.tx.effects
+	s.tx = nil
+	for _, effect := range effects {
+		effect()
+	}
+	return nothing{}, nil
+}
+
+// emit runs f immediately or after commit if a transaction is applied
+func (s *State[T]) emit(f func()) {
+	if s.tx != nil {
+		s.tx.effects = append(s.tx.effects, f)
+		return
+	}
+	f()
+}
+
+func (s *State[T]) fireInsert(item *T, inserted bool) {
+	s.emit(func() {
+		for _, hook := range s.hooks.InsertHooks {
+			hook(item, inserted)
+		}
+	})
+}
+
+func (s *State[T]) fireUpdate(old *T, item *T, updated bool) {
+	s.emit(func() {
+		for _, hook := range s.hooks.UpdateHooks {
+			hook(old, item, updated)
+		}
+	})
+}
+
+func (s *State[T]) fireUpsert(old *T, item *T) {
+	s.emit(func() {
+		for _, hook := range s.hooks.UpsertHooks {
+			hook(old, item, old != nil)
+		}
+	})
+}
+
+func (s *State[T]) fireDelete(item *T, deleted bool, reason DeleteReason) {
+	s.emit(func() {
+		for _, hook := range s.hooks.DeleteHooks {
+			hook(item, deleted, reason)
+		}
+	})
+}
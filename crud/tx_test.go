@@ -0,0 +1,144 @@
+package crud_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/txix-open/walx/v2/crud"
+	"github.com/txix-open/walx/v2/crud/index"
+	"github.com/txix-open/walx/v2/tstate"
+)
+
+func TestState_Tx(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	s := crud.New[Item1]("items")
+	events := make([]string, 0)
+	s.SetHooks(crud.Hooks[Item1]{
+		InsertHooks: []crud.InsertHook[Item1]{func(t *Item1, inserted bool) {
+			events = append(events, "insert "+t.Id)
+		}},
+		UpdateHooks: []crud.UpdateHook[Item1]{func(old *Item1, t *Item1, updated bool) {
+			events = append(events, "update "+t.Id)
+		}},
+		DeleteHooks: []crud.DeleteHook[Item1]{func(t *Item1, deleted bool, reason crud.DeleteReason) {
+			events = append(events, "delete "+t.Id)
+		}},
+	})
+	tstate.ServeState(t, s)
+
+	err := s.BulkUpsert([]Item1{{Id: "b", X: "1"}, {Id: "c", X: "1"}})
+	require.NoError(err)
+	_, version := s.GetWithVersion("b")
+
+	err = s.Tx(func(tx *crud.Tx[Item1]) error {
+		tx.Insert(Item1{Id: "a", X: "1"})
+		tx.Update(Item1{Id: "b", X: "2"})
+		tx.Delete("d")
+		return nil
+	})
+	require.ErrorIs(err, crud.ErrNotFound)
+	require.Nil(s.Get("a"))
+	require.EqualValues("1", s.Get("b").X)
+	require.Empty(events)
+	_, sameVersion := s.GetWithVersion("b")
+	require.EqualValues(version, sameVersion)
+
+	err = s.Tx(func(tx *crud.Tx[Item1]) error {
+		tx.Insert(Item1{Id: "a", X: "1"})
+		tx.UpdateIfVersion(Item1{Id: "b", X: "2"}, version)
+		tx.Delete("c")
+		return nil
+	})
+	require.NoError(err)
+	require.EqualValues("1", s.Get("a").X)
+	require.EqualValues("2", s.Get("b").X)
+	require.Nil(s.Get("c"))
+	require.Equal([]string{"insert a", "update b", "delete c"}, events)
+
+	err = s.Tx(func(tx *crud.Tx[Item1]) error {
+		tx.Upsert(Item1{Id: "e"})
+		tx.Insert(Item1{Id: "a"})
+		return nil
+	})
+	require.ErrorIs(err, crud.ErrAlreadyExists)
+	require.Nil(s.Get("e"))
+
+	err = s.Tx(func(tx *crud.Tx[Item1]) error {
+		return nil
+	})
+	require.ErrorIs(err, crud.ErrEmptyTx)
+}
+
+func TestState_TxUniqueConstraint(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	s := crud.New[Item]("items")
+	emails := index.NewUnique[Item]("email", func(item *Item) string {
+		return item.Email
+	})
+	s.SetHooks(emails.Hooks())
+	tstate.ServeState(t, s)
+
+	err := s.BulkUpsert([]Item{{Id: "1", Email: "a"}, {Id: "2", Email: "b"}})
+	require.NoError(err)
+
+	err = s.Tx(func(tx *crud.Tx[Item]) error {
+		tx.Insert(Item{Id: "3", Email: "c"})
+		tx.Insert(Item{Id: "4", Email: "c"})
+		return nil
+	})
+	require.ErrorIs(err, crud.ErrConstraintViolation)
+	require.Nil(s.Get("3"))
+	require.Nil(emails.Get("c"))
+
+	err = s.Tx(func(tx *crud.Tx[Item]) error {
+		tx.Update(Item{Id: "1", Email: "b"})
+		tx.Update(Item{Id: "2", Email: "a"})
+		return nil
+	})
+	require.NoError(err)
+	require.EqualValues("2", emails.Get("a").Id)
+	require.EqualValues("1", emails.Get("b").Id)
+
+	err = s.Tx(func(tx *crud.Tx[Item]) error {
+		tx.Delete("2")
+		tx.Insert(Item{Id: "5", Email: "a"})
+		return nil
+	})
+	require.NoError(err)
+	require.Nil(s.Get("2"))
+	require.EqualValues("5", emails.Get("a").Id)
+
+	err = s.Tx(func(tx *crud.Tx[Item]) error {
+		tx.Update(Item{Id: "1", Email: "d"})
+		tx.Update(Item{Id: "1", Email: "e"})
+		tx.Insert(Item{Id: "6", Email: "b"})
+		return nil
+	})
+	require.NoError(err)
+	require.EqualValues("1", emails.Get("e").Id)
+	require.EqualValues("6", emails.Get("b").Id)
+	require.Nil(emails.Get("d"))
+
+	err = s.Tx(func(tx *crud.Tx[Item]) error {
+		tx.Delete("5")
+		tx.Insert(Item{Id: "7", Email: "a"})
+		tx.Insert(Item{Id: "8", Email: "e"})
+		return nil
+	})
+	require.ErrorIs(err, crud.ErrConstraintViolation)
+	require.NotNil(s.Get("5"))
+	require.EqualValues("5", emails.Get("a").Id)
+}
+
+type Item struct {
+	Id    string
+	Email string
+}
+
+func (i Item) GetId() string {
+	return i.Id
+}
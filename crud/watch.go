@@ -0,0 +1,206 @@
+package crud
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	ErrSlowConsumer   = errors.New("watcher is too slow, changes were lost")
+	ErrIndexCompacted = errors.New("changes from requested index are no longer available")
+)
+
+type ChangeType string
+
+const (
+	ChangeInsert ChangeType = "insert"
+	ChangeUpdate ChangeType = "update"
+	ChangeDelete ChangeType = "delete"
+)
+
+// Change describes a single item mutation.
+// Old and New point to the state items and must not be modified
+type Change[T WithId] struct {
+	Type   ChangeType
+	Id     string
+	Old    *T
+	New    *T
+	Index  uint64
+	Reason DeleteReason
+}
+
+type Watcher[T WithId] struct {
+	changes chan Change[T]
+	err     atomic.Pointer[error]
+}
+
+// Changes returns the channel of changes, it is closed when the watch is finished
+func (w *Watcher[T]) Changes() <-chan Change[T] {
+	return w.changes
+}
+
+// Err returns the reason of finishing after Changes is closed
+func (w *Watcher[T]) Err() error {
+	err := w.err.Load()
+	if err == nil {
+		return nil
+	}
+	return *err
+}
+
+func (w *Watcher[T]) finish(err error) {
+	w.err.Store(&err)
+	close(w.changes)
+}
+
+// Watch streams changes matching filter until ctx is done.
+// Changes are buffered in a bounded ring, so consumers never block state applying.
+// A consumer lagging behind the ring is disconnected with ErrSlowConsumer unless SkipOnSlowConsumer is set
+func (s *State[T]) Watch(
+	ctx context.Context,
+	filter func(change Change[T]) bool,
+	opts ...WatchOption,
+) (*Watcher[T], error) {
+	options := newWatchOptions()
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	seq, err := s.feed.seqFrom(options.fromIndex)
+	if err != nil {
+		return nil, err
+	}
+
+	watcher := &Watcher[T]{
+		changes: make(chan Change[T], options.channelSize),
+	}
+	go s.watch(ctx, watcher, seq, filter, options)
+	return watcher, nil
+}
+
+func (s *State[T]) watch(
+	ctx context.Context,
+	watcher *Watcher[T],
+	seq uint64,
+	filter func(change Change[T]) bool,
+	options *watchOptions,
+) {
+	for {
+		changes, next, wait, lagged := s.feed.read(seq)
+		if lagged {
+			if !options.skipOnSlowConsumer {
+				watcher.finish(ErrSlowConsumer)
+				return
+			}
+			seq = next
+			continue
+		}
+
+		for _, change := range changes {
+			if filter != nil && !filter(change) {
+				continue
+			}
+			select {
+			case watcher.changes <- change:
+			case <-ctx.Done():
+				watcher.finish(ctx.Err())
+				return
+			}
+		}
+		seq = next
+
+		if len(changes) == 0 {
+			select {
+			case <-wait:
+			case <-ctx.Done():
+				watcher.finish(ctx.Err())
+				return
+			}
+		}
+	}
+}
+
+func (s *State[T]) recordChange(change Change[T]) {
+	change.Index = s.index
+	s.emit(func() {
+		s.feed.append(change)
+	})
+}
+
+type feed[T WithId] struct {
+	lock             sync.Mutex
+	changes          []Change[T]
+	head             uint64
+	lastDroppedIndex uint64
+	notify           chan struct{}
+}
+
+func newFeed[T WithId](size int) *feed[T] {
+	return &feed[T]{
+		changes: make([]Change[T], size),
+		notify:  make(chan struct{}),
+	}
+}
+
+func (f *feed[T]) append(change Change[T]) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	pos := f.head % uint64(len(f.changes))
+	if f.head >= uint64(len(f.changes)) {
+		f.lastDroppedIndex = f.changes[pos].Index
+	}
+	f.changes[pos] = change
+	f.head++
+
+	close(f.notify)
+	f.notify = make(chan struct{})
+}
+
+// read returns changes starting from seq, the next seq and the channel closed on the next append.
+// If seq is no longer in the ring, lagged is true and next is the oldest available seq
+func (f *feed[T]) read(seq uint64) (changes []Change[T], next uint64, wait <-chan struct{}, lagged bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	oldest := f.oldest()
+	if seq < oldest {
+		return nil, oldest, nil, true
+	}
+
+	changes = make([]Change[T], 0, f.head-seq)
+	for i := seq; i < f.head; i++ {
+		changes = append(changes, f.changes[i%uint64(len(f.changes))])
+	}
+	return changes, f.head, f.notify, false
+}
+
+// seqFrom returns the seq of the first change with index not less than fromIndex.
+// Zero fromIndex means only new changes
+func (f *feed[T]) seqFrom(fromIndex uint64) (uint64, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if fromIndex == 0 {
+		return f.head, nil
+	}
+	if fromIndex <= f.lastDroppedIndex {
+		return 0, ErrIndexCompacted
+	}
+	for seq := f.oldest(); seq < f.head; seq++ {
+		if f.changes[seq%uint64(len(f.changes))].Index >= fromIndex {
+			return seq, nil
+		}
+	}
+	return f.head, nil
+}
+
+func (f *feed[T]) oldest() uint64 {
+	size := uint64(len(f.changes))
+	if f.head < size {
+		return 0
+	}
+	return f.head - size
+}
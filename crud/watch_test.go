@@ -0,0 +1,123 @@
+package crud_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/txix-open/walx/v2/crud"
+	"github.com/txix-open/walx/v2/tstate"
+)
+
+func receive(t *testing.T, watcher *crud.Watcher[Item1]) crud.Change[Item1] {
+	t.Helper()
+
+	select {
+	case change, ok := <-watcher.Changes():
+		require.True(t, ok, "watcher is closed: %v", watcher.Err())
+		return change
+	case <-time.After(time.Second):
+		t.Fatal("change was not received")
+		return crud.Change[Item1]{}
+	}
+}
+
+func TestState_Watch(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	s := crud.New[Item1]("items")
+	tstate.ServeState(t, s)
+
+	err := s.Insert(Item1{Id: "0", X: "before watch"})
+	require.NoError(err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	watcher, err := s.Watch(ctx, func(change crud.Change[Item1]) bool {
+		return change.Id != "skip"
+	})
+	require.NoError(err)
+
+	err = s.Insert(Item1{Id: "1", X: "a"})
+	require.NoError(err)
+	err = s.Insert(Item1{Id: "skip"})
+	require.NoError(err)
+	err = s.Update(Item1{Id: "1", X: "b"})
+	require.NoError(err)
+	_, err = s.Delete("1")
+	require.NoError(err)
+
+	insert := receive(t, watcher)
+	require.EqualValues(crud.ChangeInsert, insert.Type)
+	require.Nil(insert.Old)
+	require.EqualValues("a", insert.New.X)
+	require.Positive(insert.Index)
+
+	update := receive(t, watcher)
+	require.EqualValues(crud.ChangeUpdate, update.Type)
+	require.EqualValues("a", update.Old.X)
+	require.EqualValues("b", update.New.X)
+	require.Greater(update.Index, insert.Index)
+
+	deleted := receive(t, watcher)
+	require.EqualValues(crud.ChangeDelete, deleted.Type)
+	require.EqualValues("b", deleted.Old.X)
+	require.EqualValues(crud.DeleteReasonRequested, deleted.Reason)
+
+	resumed, err := s.Watch(context.Background(), nil, crud.FromIndex(update.Index))
+	require.NoError(err)
+	require.EqualValues(crud.ChangeUpdate, receive(t, resumed).Type)
+	require.EqualValues(crud.ChangeDelete, receive(t, resumed).Type)
+
+	cancel()
+	_, ok := <-watcher.Changes()
+	require.False(ok)
+	require.ErrorIs(watcher.Err(), context.Canceled)
+}
+
+func TestState_WatchSlowConsumer(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	s := crud.New[Item1]("items", crud.WatchBufferSize(4))
+	tstate.ServeState(t, s)
+
+	slow, err := s.Watch(context.Background(), nil, crud.ChannelSize(1))
+	require.NoError(err)
+	skipping, err := s.Watch(context.Background(), nil, crud.ChannelSize(1), crud.SkipOnSlowConsumer())
+	require.NoError(err)
+
+	items := make([]Item1, 0)
+	for i := range 20 {
+		items = append(items, Item1{Id: string(rune('a' + i))})
+		err := s.Upsert(items[i])
+		require.NoError(err)
+	}
+
+	for range slow.Changes() {
+	}
+	require.ErrorIs(slow.Err(), crud.ErrSlowConsumer)
+
+	var last crud.Change[Item1]
+	for last.Id != "t" {
+		last = receive(t, skipping)
+	}
+
+	_, err = s.Watch(context.Background(), nil, crud.FromIndex(1))
+	require.ErrorIs(err, crud.ErrIndexCompacted)
+}
+
+func TestState_WatchInvalidSizes(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	s := crud.New[Item1]("items", crud.WatchBufferSize(0))
+	tstate.ServeState(t, s)
+
+	watcher, err := s.Watch(context.Background(), nil, crud.ChannelSize(-1))
+	require.NoError(err)
+	err = s.Insert(Item1{Id: "1"})
+	require.NoError(err)
+	require.EqualValues("1", receive(t, watcher).Id)
+}
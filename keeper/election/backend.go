@@ -0,0 +1,75 @@
+package election
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type Candidate struct {
+	Id      string
+	Address string
+}
+
+type Lease struct {
+	Holder    Candidate
+	Epoch     uint64
+	ExpiresAt time.Time
+}
+
+func (l Lease) IsHeldBy(id string) bool {
+	return l.Holder.Id == id
+}
+
+// Backend stores the leader lease shared by all candidates
+type Backend interface {
+	// Acquire grants the lease to candidate if it is free, expired or already held by candidate
+	// and returns the actual lease. Epoch is incremented every time the lease changes its holder
+	Acquire(ctx context.Context, candidate Candidate, ttl time.Duration) (Lease, error)
+	// Release frees the lease if it is held by candidate with id
+	Release(ctx context.Context, id string) error
+}
+
+// Memory is an in-process Backend, it is intended for tests and single process deployments
+type Memory struct {
+	clock Clock
+	lease Lease
+	lock  sync.Locker
+}
+
+func NewMemory(clock Clock) *Memory {
+	return &Memory{
+		clock: clock,
+		lock:  &sync.Mutex{},
+	}
+}
+
+func (m *Memory) Acquire(ctx context.Context, candidate Candidate, ttl time.Duration) (Lease, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	now := m.clock.Now()
+	switch {
+	case m.lease.IsHeldBy(candidate.Id):
+		m.lease.Holder = candidate
+		m.lease.ExpiresAt = now.Add(ttl)
+	case m.lease.Holder.Id == "" || !now.Before(m.lease.ExpiresAt):
+		m.lease = Lease{
+			Holder:    candidate,
+			Epoch:     m.lease.Epoch + 1,
+			ExpiresAt: now.Add(ttl),
+		}
+	}
+	return m.lease, nil
+}
+
+func (m *Memory) Release(ctx context.Context, id string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.lease.IsHeldBy(id) {
+		m.lease.Holder = Candidate{}
+		m.lease.ExpiresAt = time.Time{}
+	}
+	return nil
+}
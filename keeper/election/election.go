@@ -0,0 +1,243 @@
+package election
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/txix-open/isp-kit/log"
+	"github.com/txix-open/walx/v2/keeper"
+)
+
+type Node interface {
+	Promote(epoch uint64) error
+	Demote(leaderAddress string, epoch uint64) error
+}
+
+// Election keeps exactly one node of the cluster as a leader using the lease from Backend.
+// The leader is promoted with the epoch of its lease, other candidates are demoted to followers
+// replicating from the lease holder. The leader steps down on its own a safety margin before its lease expires
+// if it can't renew the lease in time
+type Election struct {
+	node      Node
+	backend   Backend
+	candidate Candidate
+	options   *options
+	logger    log.Logger
+
+	role     keeper.Role
+	lease    Lease
+	deadline *time.Timer
+	lock     sync.Locker
+	roleLock sync.Locker
+}
+
+func New(node Node, backend Backend, candidate Candidate, logger log.Logger, opts ...Option) *Election {
+	options := newOptions()
+	for _, opt := range opts {
+		opt(options)
+	}
+	if options.safetyMargin <= 0 {
+		options.safetyMargin = options.leaseTtl / 10
+	}
+	if options.acquireTimeout <= 0 {
+		options.acquireTimeout = options.renewInterval
+	}
+	return &Election{
+		node:      node,
+		backend:   backend,
+		candidate: candidate,
+		options:   options,
+		logger:    logger,
+		lock:      &sync.Mutex{},
+		roleLock:  &sync.Mutex{},
+	}
+}
+
+// Role returns the current role of the node, it is empty until the first election round is completed
+func (e *Election) Role() keeper.Role {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	return e.role
+}
+
+// Lease returns the last observed lease
+func (e *Election) Lease() Lease {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	return e.lease
+}
+
+// Run takes part in elections until ctx is canceled.
+// On exit the leader becomes read only and releases the lease, so other candidates can take over immediately
+func (e *Election) Run(ctx context.Context) error {
+	ctx = log.ToContext(ctx, log.String("candidate", e.candidate.Id))
+	ticker := time.NewTicker(e.options.renewInterval)
+	defer ticker.Stop()
+
+	for {
+		e.round(ctx)
+
+		select {
+		case <-ctx.Done():
+			return e.resign()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (e *Election) round(ctx context.Context) {
+	requestedAt := e.options.clock.Now()
+	acquireCtx, cancel := context.WithTimeout(ctx, e.options.acquireTimeout)
+	lease, err := e.backend.Acquire(acquireCtx, e.candidate, e.options.leaseTtl)
+	cancel()
+	if err != nil {
+		e.logger.Error(ctx, errors.WithMessage(err, "acquire lease"))
+		// the deadline timer demotes the leader as well, the check covers clocks not following the real time
+		current := e.Lease()
+		if e.Role() == keeper.RoleLeader && !e.options.clock.Now().Before(e.stepDownAt(current)) {
+			e.changeRole(ctx, keeper.RoleFollower, Lease{Epoch: current.Epoch})
+		}
+		return
+	}
+
+	if !lease.IsHeldBy(e.candidate.Id) {
+		e.changeRole(ctx, keeper.RoleFollower, lease)
+		return
+	}
+
+	// the lease is counted from the moment of request, so the local view expires no later than the backend's one
+	// if clocks run at the same rate, the safety margin covers clock drift and demotion latency
+	lease.ExpiresAt = requestedAt.Add(e.options.leaseTtl)
+	if !e.options.clock.Now().Before(e.stepDownAt(lease)) {
+		e.logger.Warn(ctx, "lease is renewed too late, step down", log.Any("epoch", lease.Epoch))
+		e.changeRole(ctx, keeper.RoleFollower, Lease{Epoch: lease.Epoch})
+		return
+	}
+	e.changeRole(ctx, keeper.RoleLeader, lease)
+}
+
+func (e *Election) changeRole(ctx context.Context, role keeper.Role, lease Lease) {
+	e.roleLock.Lock()
+	defer e.roleLock.Unlock()
+
+	e.changeRoleLocked(ctx, role, lease)
+}
+
+func (e *Election) changeRoleLocked(ctx context.Context, role keeper.Role, lease Lease) {
+	e.lock.Lock()
+	prevRole := e.role
+	prevLease := e.lease
+	e.lease = lease
+	e.lock.Unlock()
+
+	if role == keeper.RoleLeader {
+		e.armDeadline(ctx, lease)
+	} else {
+		e.stopDeadline()
+	}
+
+	sameLeader := prevLease.Epoch == lease.Epoch && prevLease.Holder == lease.Holder
+	if prevRole == role && sameLeader {
+		return
+	}
+
+	var err error
+	if role == keeper.RoleLeader {
+		err = e.node.Promote(lease.Epoch)
+	} else {
+		err = e.node.Demote(lease.Holder.Address, lease.Epoch)
+	}
+	if err != nil {
+		e.logger.Error(
+			ctx,
+			errors.WithMessagef(err, "change role to %s", role),
+			log.Any("epoch", lease.Epoch),
+			log.String("leader", lease.Holder.Id),
+		)
+		e.lock.Lock()
+		e.role = ""
+		e.lock.Unlock()
+		if role == keeper.RoleLeader {
+			e.stopDeadline()
+			err = e.backend.Release(ctx, e.candidate.Id)
+			if err != nil {
+				e.logger.Error(ctx, errors.WithMessage(err, "release lease"))
+			}
+		}
+		return
+	}
+
+	e.lock.Lock()
+	e.role = role
+	e.lock.Unlock()
+
+	e.logger.Info(
+		ctx,
+		"role changed",
+		log.String("role", string(role)),
+		log.Any("epoch", lease.Epoch),
+		log.String("leader", lease.Holder.Id),
+	)
+	for _, callback := range e.options.onRoleChange {
+		callback(RoleChange{Role: role, Lease: lease})
+	}
+}
+
+// stepDownAt returns the moment the leader must be demoted if the lease isn't renewed
+func (e *Election) stepDownAt(lease Lease) time.Time {
+	return lease.ExpiresAt.Add(-e.options.safetyMargin)
+}
+
+// armDeadline demotes the leader when the lease is about to expire, it runs on the real time
+// regardless of the configured clock
+func (e *Election) armDeadline(ctx context.Context, lease Lease) {
+	e.stopDeadline()
+	timeout := e.stepDownAt(lease).Sub(e.options.clock.Now())
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.deadline = time.AfterFunc(timeout, func() {
+		e.stepDown(ctx, lease)
+	})
+}
+
+func (e *Election) stopDeadline() {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if e.deadline != nil {
+		e.deadline.Stop()
+		e.deadline = nil
+	}
+}
+
+func (e *Election) stepDown(ctx context.Context, lease Lease) {
+	e.roleLock.Lock()
+	defer e.roleLock.Unlock()
+
+	current := e.Lease()
+	renewed := current.Epoch != lease.Epoch || !current.ExpiresAt.Equal(lease.ExpiresAt)
+	if e.Role() != keeper.RoleLeader || renewed {
+		return
+	}
+	e.logger.Warn(ctx, "lease is not renewed in time, step down", log.Any("epoch", lease.Epoch))
+	e.changeRoleLocked(ctx, keeper.RoleFollower, Lease{Epoch: lease.Epoch})
+}
+
+func (e *Election) resign() error {
+	if e.Role() != keeper.RoleLeader {
+		return nil
+	}
+
+	ctx := log.ToContext(context.Background(), log.String("candidate", e.candidate.Id))
+	e.changeRole(ctx, keeper.RoleFollower, Lease{Epoch: e.Lease().Epoch})
+	err := e.backend.Release(ctx, e.candidate.Id)
+	if err != nil {
+		return errors.WithMessage(err, "release lease")
+	}
+	return nil
+}
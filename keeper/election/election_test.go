@@ -0,0 +1,275 @@
+package election_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/txix-open/isp-kit/log"
+	"github.com/txix-open/walx/v2/keeper"
+	"github.com/txix-open/walx/v2/keeper/election"
+	"github.com/txix-open/walx/v2/state"
+)
+
+type AddRequest struct {
+	Value int
+}
+
+type counter struct {
+	value atomic.Int64
+}
+
+func (c *counter) SetMutator(mutator state.Mutator) {
+}
+
+func (c *counter) Apply(log state.Log) (any, error) {
+	req, err := state.UnmarshalEvent[AddRequest](log)
+	if err != nil {
+		return nil, err
+	}
+	return c.value.Add(int64(req.Value)), nil
+}
+
+type flakyBackend struct {
+	election.Backend
+	unavailable atomic.Bool
+	stalled     atomic.Bool
+}
+
+func (b *flakyBackend) Acquire(ctx context.Context, candidate election.Candidate, ttl time.Duration) (election.Lease, error) {
+	if b.unavailable.Load() {
+		return election.Lease{}, errors.New("backend is unavailable")
+	}
+	if b.stalled.Load() {
+		<-ctx.Done()
+		return election.Lease{}, ctx.Err()
+	}
+	return b.Backend.Acquire(ctx, candidate, ttl)
+}
+
+type node struct {
+	keeper   *keeper.Keeper
+	counter  *counter
+	election *election.Election
+	backend  *flakyBackend
+	changes  []election.RoleChange
+	lock     sync.Locker
+}
+
+func (n *node) roleChanges() []election.RoleChange {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	return append([]election.RoleChange{}, n.changes...)
+}
+
+func TestElection(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	backend := election.NewMemory(election.SystemClock{})
+	nodes := make([]*node, 0)
+	for i := range 3 {
+		nodes = append(nodes, newNode(t, strconv.Itoa(i), backend))
+	}
+
+	leader := waitLeader(t, nodes, 1)
+	for i := 0; i < 5; i++ {
+		_, err := leader.keeper.State().Apply(AddRequest{Value: 1}, nil)
+		require.NoError(err)
+	}
+	for _, n := range nodes {
+		require.Eventually(func() bool {
+			return n.counter.value.Load() == 5 && n.keeper.Epoch() == 1
+		}, 5*time.Second, 10*time.Millisecond)
+		if n != leader {
+			require.Equal(keeper.RoleFollower, n.keeper.Role())
+			_, err := n.keeper.State().Apply(AddRequest{Value: 1}, nil)
+			require.ErrorIs(err, state.ErrReadOnly)
+		}
+	}
+
+	leader.backend.unavailable.Store(true)
+	require.Eventually(func() bool {
+		return leader.keeper.Role() == keeper.RoleFollower
+	}, 5*time.Second, 10*time.Millisecond)
+	newLeader := waitLeader(t, nodes, 2)
+	require.NotEqual(leader, newLeader)
+
+	_, err := newLeader.keeper.State().Apply(AddRequest{Value: 1}, nil)
+	require.NoError(err)
+	err = leader.keeper.Promote(1)
+	require.ErrorIs(err, keeper.ErrStaleEpoch)
+
+	leader.backend.unavailable.Store(false)
+	for _, n := range nodes {
+		require.Eventually(func() bool {
+			return n.counter.value.Load() == 6 && n.keeper.Epoch() == 2
+		}, 5*time.Second, 10*time.Millisecond)
+	}
+	err = leader.keeper.Promote(2)
+	require.ErrorIs(err, keeper.ErrStaleEpoch)
+
+	changes := leader.roleChanges()
+	require.Equal(keeper.RoleLeader, changes[0].Role)
+	require.Equal(keeper.RoleFollower, changes[len(changes)-1].Role)
+	require.Equal(newLeader.election.Lease().Holder, changes[len(changes)-1].Lease.Holder)
+}
+
+func TestElection_StalledBackend(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	backend := election.NewMemory(election.SystemClock{})
+	n := newNode(t, "0", backend)
+	waitLeader(t, []*node{n}, 1)
+
+	n.backend.stalled.Store(true)
+	require.Eventually(func() bool {
+		return n.keeper.Role() == keeper.RoleFollower
+	}, 300*time.Millisecond, 10*time.Millisecond)
+	_, err := n.keeper.State().Apply(AddRequest{Value: 1}, nil)
+	require.ErrorIs(err, state.ErrReadOnly)
+
+	n.backend.stalled.Store(false)
+	waitLeader(t, []*node{n}, 2)
+	_, err = n.keeper.State().Apply(AddRequest{Value: 1}, nil)
+	require.NoError(err)
+}
+
+func waitLeader(t *testing.T, nodes []*node, epoch uint64) *node {
+	t.Helper()
+
+	var leader *node
+	require.Eventually(t, func() bool {
+		leaders := make([]*node, 0)
+		for _, n := range nodes {
+			if n.keeper.Role() == keeper.RoleLeader {
+				leaders = append(leaders, n)
+			}
+		}
+		if len(leaders) != 1 || leaders[0].keeper.Epoch() != epoch {
+			return false
+		}
+		leader = leaders[0]
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+	return leader
+}
+
+func TestElection_DivergedLeader(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	deposed, deposedCounter, deposedPort := newKeeper(t)
+	leader, leaderCounter, leaderPort := newKeeper(t)
+	deposedAddress := net.JoinHostPort("127.0.0.1", strconv.Itoa(deposedPort))
+	leaderAddress := net.JoinHostPort("127.0.0.1", strconv.Itoa(leaderPort))
+
+	require.NoError(deposed.Promote(1))
+	require.NoError(leader.Demote(deposedAddress, 1))
+	for i := 0; i < 2; i++ {
+		_, err := deposed.State().Apply(AddRequest{Value: 1}, nil)
+		require.NoError(err)
+	}
+	require.Eventually(func() bool {
+		return leaderCounter.value.Load() == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// the deposed leader journals the entry which is never replicated
+	require.NoError(leader.Demote("", 1))
+	_, err := deposed.State().Apply(AddRequest{Value: 1}, nil)
+	require.NoError(err)
+
+	require.NoError(leader.Promote(2))
+	_, err = leader.State().Apply(AddRequest{Value: 10}, nil)
+	require.NoError(err)
+	require.NoError(deposed.Demote(leaderAddress, 2))
+
+	follower, followerCounter, _ := newKeeper(t)
+	require.NoError(follower.Demote(leaderAddress, 2))
+	require.Eventually(func() bool {
+		return followerCounter.value.Load() == 12 && follower.Epoch() == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.Never(func() bool {
+		return deposedCounter.value.Load() != 3 || deposed.Epoch() != 1
+	}, 500*time.Millisecond, 10*time.Millisecond)
+}
+
+func newKeeper(t *testing.T) (*keeper.Keeper, *counter, int) {
+	t.Helper()
+	require := require.New(t)
+
+	logger, err := log.New()
+	require.NoError(err)
+	port := freePort(require)
+	counter := &counter{}
+	k, err := keeper.New(t.TempDir(), "test", counter, logger, keeper.ServeWalOnPort(port))
+	require.NoError(err)
+	k.State().SetReadOnly(nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		_ = k.Run(ctx)
+	}()
+	time.Sleep(100 * time.Millisecond) // we must run state before first promotion
+	t.Cleanup(func() {
+		cancel()
+		_ = k.Close()
+	})
+	return k, counter, port
+}
+
+func newNode(t *testing.T, id string, backend election.Backend) *node {
+	t.Helper()
+	require := require.New(t)
+
+	logger, err := log.New()
+	require.NoError(err)
+	k, counter, port := newKeeper(t)
+	n := &node{
+		keeper:  k,
+		counter: counter,
+		backend: &flakyBackend{Backend: backend},
+		lock:    &sync.Mutex{},
+	}
+	n.election = election.New(
+		k,
+		n.backend,
+		election.Candidate{Id: id, Address: net.JoinHostPort("127.0.0.1", strconv.Itoa(port))},
+		logger,
+		election.LeaseTTL(300*time.Millisecond),
+		election.RenewInterval(50*time.Millisecond),
+		election.OnRoleChange(func(change election.RoleChange) {
+			n.lock.Lock()
+			defer n.lock.Unlock()
+			n.changes = append(n.changes, change)
+		}),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = n.election.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return n
+}
+
+func freePort(require *require.Assertions) int {
+	lis, err := net.Listen("tcp", "127.0.0.1:")
+	require.NoError(err)
+	defer lis.Close()
+	return lis.Addr().(*net.TCPAddr).Port
+}
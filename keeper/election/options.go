@@ -0,0 +1,87 @@
+package election
+
+import (
+	"time"
+
+	"github.com/txix-open/walx/v2/keeper"
+)
+
+type Clock interface {
+	Now() time.Time
+}
+
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+type RoleChange struct {
+	Role  keeper.Role
+	Lease Lease
+}
+
+type options struct {
+	clock          Clock
+	leaseTtl       time.Duration
+	renewInterval  time.Duration
+	safetyMargin   time.Duration
+	acquireTimeout time.Duration
+	onRoleChange   []func(change RoleChange)
+}
+
+func newOptions() *options {
+	return &options{
+		clock:         SystemClock{},
+		leaseTtl:      10 * time.Second,
+		renewInterval: 3 * time.Second,
+	}
+}
+
+type Option func(o *options)
+
+func WithClock(clock Clock) Option {
+	return func(o *options) {
+		o.clock = clock
+	}
+}
+
+// LeaseTTL sets the lease duration, the leader steps down if it can't renew the lease in time
+func LeaseTTL(ttl time.Duration) Option {
+	return func(o *options) {
+		o.leaseTtl = ttl
+	}
+}
+
+// RenewInterval sets how often the lease is acquired or renewed, it must be notably less than lease TTL
+func RenewInterval(interval time.Duration) Option {
+	return func(o *options) {
+		o.renewInterval = interval
+	}
+}
+
+// SafetyMargin sets how long before the lease expiration the leader steps down on its own.
+// It covers clock drift between the node and the backend and the time to demote, a tenth of lease TTL by default
+func SafetyMargin(margin time.Duration) Option {
+	return func(o *options) {
+		if margin > 0 {
+			o.safetyMargin = margin
+		}
+	}
+}
+
+// AcquireTimeout limits a single request to the backend, it equals the renew interval by default
+func AcquireTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		if timeout > 0 {
+			o.acquireTimeout = timeout
+		}
+	}
+}
+
+// OnRoleChange adds the callback called after the node was promoted or demoted
+func OnRoleChange(callback func(change RoleChange)) Option {
+	return func(o *options) {
+		o.onRoleChange = append(o.onRoleChange, callback)
+	}
+}
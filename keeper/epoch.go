@@ -0,0 +1,106 @@
+package keeper
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+	"github.com/txix-open/walx/v2/state"
+)
+
+var (
+	ErrStaleEpoch = errors.New("stale epoch")
+)
+
+const (
+	epochStreamSuffix = "$epoch"
+)
+
+type epochEvent struct {
+	Epoch uint64
+}
+
+type nothing struct{}
+
+type epochStart struct {
+	epoch uint64
+	index uint64
+}
+
+// epochHistory keeps WAL indexes of journaled epoch events in the order they were applied
+type epochHistory struct {
+	starts []epochStart
+	lock   sync.Locker
+}
+
+func (h *epochHistory) add(epoch uint64, index uint64) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.starts = append(h.starts, epochStart{epoch: epoch, index: index})
+}
+
+// at returns the epoch the entry with index was written in, 0 if it precedes the first known epoch
+func (h *epochHistory) at(index uint64) uint64 {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	i := sort.Search(len(h.starts), func(i int) bool {
+		return h.starts[i].index > index
+	})
+	if i == 0 {
+		return 0
+	}
+	return h.starts[i-1].epoch
+}
+
+// epochFSM journals leadership epochs in the primary stream of the state,
+// so they are replicated and recovered together with business events
+type epochFSM struct {
+	delegate    state.FSM
+	epochStream []byte
+	epoch       *atomic.Uint64
+	history     *epochHistory
+}
+
+func newEpochFSM(name string, delegate state.FSM) epochFSM {
+	return epochFSM{
+		delegate:    delegate,
+		epochStream: bytes.Join([][]byte{[]byte(name), []byte(epochStreamSuffix)}, state.Separator),
+		epoch:       &atomic.Uint64{},
+		history:     &epochHistory{lock: &sync.Mutex{}},
+	}
+}
+
+func (f epochFSM) Apply(log state.Log) (any, error) {
+	if !bytes.Equal(log.StreamName(), f.epochStream) {
+		return f.delegate.Apply(log)
+	}
+
+	e, err := state.UnmarshalEvent[epochEvent](log)
+	if err != nil {
+		return nil, err
+	}
+	if e.Epoch <= f.epoch.Load() {
+		return nil, ErrStaleEpoch
+	}
+	f.epoch.Store(e.Epoch)
+	f.history.add(e.Epoch, log.Index())
+	return nothing{}, nil
+}
+
+// walEpochs resolves epochs of entries applied to the state,
+// so the replication server refuses followers diverged from the leader, e.g. a deposed one
+type walEpochs struct {
+	state   *state.State
+	history *epochHistory
+}
+
+func (e walEpochs) EpochAt(index uint64) (uint64, bool) {
+	if index > e.state.AppliedIndex() {
+		return 0, false
+	}
+	return e.history.at(index), true
+}
@@ -3,7 +3,10 @@ package keeper
 import (
 	"context"
 	"fmt"
+	"os"
+	"path/filepath"
 	"slices"
+	"sync"
 
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
@@ -12,18 +15,32 @@ import (
 	"github.com/txix-open/isp-kit/metrics"
 	"github.com/txix-open/walx/v2"
 	"github.com/txix-open/walx/v2/metric"
+	"github.com/txix-open/walx/v2/projection"
 	"github.com/txix-open/walx/v2/replication"
 	"github.com/txix-open/walx/v2/state"
 	"github.com/txix-open/walx/v2/stream"
 	"golang.org/x/sync/errgroup"
 )
 
+type Role string
+
+const (
+	RoleLeader   Role = "leader"
+	RoleFollower Role = "follower"
+)
+
 type Keeper struct {
 	name              string
+	replicaId         string
 	state             *state.State
 	businessState     state.BusinessState
 	replicationServer *replication.Server
 	replicationClient *replication.Client
+	leaderAddress     string
+	epochFSM          epochFSM
+	epochs            walEpochs
+	roleLock          sync.Locker
+	projections       []*projection.Projection
 	options           options
 	logger            log.Logger
 }
@@ -50,7 +67,8 @@ func New(
 	}
 	logger.Info(ctx, "end reading wal")
 
-	ss := state.New(wal, businessState, options.codec, name)
+	epochFSM := newEpochFSM(name, businessState)
+	ss := state.New(wal, epochFSM, options.codec, name)
 	businessState.SetMutator(ss)
 
 	logger.Info(ctx, "start state recovering")
@@ -60,13 +78,29 @@ func New(
 	}
 	logger.Info(ctx, "end state recovering")
 
+	epochs := walEpochs{state: ss, history: epochFSM.history}
 	serverOptions := slices.Clone(options.replicationServerOptions)
+	serverOptions = append(serverOptions, replication.ForwardWritesTo(ss), replication.ServerEpochs(epochs))
+	replicationServer := replication.NewServer(wal, logger, serverOptions...)
+
+	stateReplication := options.replication
+	stateReplication.Waiter = replicationServer
+	err = ss.SetReplication(stateReplication)
+	if err != nil {
+		_ = wal.Close()
+		return nil, errors.WithMessagef(err, "set replication of state %s", name)
+	}
+
 	return &Keeper{
 		name:              name,
+		replicaId:         replicaId(dir),
 		state:             ss,
 		businessState:     businessState,
 		logger:            logger,
-		replicationServer: replication.NewServer(wal, logger, serverOptions...),
+		replicationServer: replicationServer,
+		epochFSM:          epochFSM,
+		epochs:            epochs,
+		roleLock:          &sync.Mutex{},
 		options:           *options,
 	}, nil
 }
@@ -79,35 +113,144 @@ func (k *Keeper) StreamWriter(streamName string) *stream.Writer {
 	return stream.NewWriter(k.state, k.options.codec, streamName)
 }
 
+func (k *Keeper) Role() Role {
+	if k.state.IsReadOnly() {
+		return RoleFollower
+	}
+	return RoleLeader
+}
+
+// Replicas returns followers connected to the replication server of the keeper
+func (k *Keeper) Replicas() []replication.Replica {
+	return k.replicationServer.Replicas()
+}
+
+// Epoch returns the latest leadership epoch journaled in the WAL
+func (k *Keeper) Epoch() uint64 {
+	return k.epochFSM.epoch.Load()
+}
+
 func (k *Keeper) StopReplication() {
+	k.roleLock.Lock()
+	defer k.roleLock.Unlock()
+
+	k.stopReplication()
+	k.state.SetWritable()
+}
+
+func (k *Keeper) BeginReplication(address string) {
+	k.roleLock.Lock()
+	defer k.roleLock.Unlock()
+
+	k.beginReplication(address)
+}
+
+// Promote makes the node a leader of the epoch and journals the epoch in the WAL.
+// Epochs not greater than the journaled one are rejected with ErrStaleEpoch,
+// so a delayed promotion of a superseded leader is fenced
+func (k *Keeper) Promote(epoch uint64) error {
+	k.roleLock.Lock()
+	defer k.roleLock.Unlock()
+
+	if epoch <= k.Epoch() {
+		return errors.WithMessagef(ErrStaleEpoch, "promote to epoch %d, current epoch %d", epoch, k.Epoch())
+	}
+
+	k.stopReplication()
+	k.state.SetWritable()
+	// the epoch is journaled asynchronously, followers may be unavailable during failover,
+	// async replication is always valid
+	async, _ := k.state.WithReplication(state.Replication{})
+	_, err := async.Apply(epochEvent{Epoch: epoch}, []byte(epochStreamSuffix))
+	if err != nil {
+		k.state.SetReadOnly(nil)
+		return errors.WithMessagef(err, "journal epoch %d", epoch)
+	}
+	return nil
+}
+
+// Demote makes the node a follower replicating from leaderAddress.
+// Empty leaderAddress leaves the node read only until the leader is known.
+// The leader refuses to replicate to the node if its last entry was written in another epoch,
+// e.g. it was journaled by the node as a deposed leader. Applied entries can't be rolled back,
+// so replication stops with replication.ErrDiverged and the node must be restored from the leader
+func (k *Keeper) Demote(leaderAddress string, epoch uint64) error {
+	k.roleLock.Lock()
+	defer k.roleLock.Unlock()
+
+	if epoch < k.Epoch() {
+		return errors.WithMessagef(ErrStaleEpoch, "demote in epoch %d, current epoch %d", epoch, k.Epoch())
+	}
+
+	if k.replicationClient != nil && k.leaderAddress == leaderAddress {
+		return nil
+	}
+	k.stopReplication()
+	k.state.SetReadOnly(nil)
+	if leaderAddress != "" {
+		k.beginReplication(leaderAddress)
+	}
+	return nil
+}
+
+func (k *Keeper) stopReplication() {
 	if k.replicationClient != nil {
 		_ = k.replicationClient.Close()
 	}
 	k.replicationClient = nil
+	k.leaderAddress = ""
+	k.state.CancelForwards()
 }
 
-func (k *Keeper) BeginReplication(address string) {
+func (k *Keeper) beginReplication(address string) {
 	if k.replicationClient != nil {
 		return
 	}
 
-	k.replicationClient = replication.NewClient(
+	// the id is stable across reconnects and restarts, explicit replication.ReplicaId overrides it
+	clientOptions := []replication.ClientOption{replication.ReplicaId(k.replicaId), replication.ClientEpochs(k.epochs)}
+	clientOptions = append(clientOptions, k.options.replicationClientOptions...)
+	// epochs are replicated regardless of filtered streams, they are required to check divergence
+	filteredStreams := append(slices.Clone(k.options.filteredStreams), string(k.epochFSM.epochStream))
+	client := replication.NewClient(
 		k.state,
 		k.name,
 		address,
-		k.options.filteredStreams,
+		filteredStreams,
 		k.logger,
-		k.options.replicationClientOptions...,
+		clientOptions...,
 	)
+	k.replicationClient = client
+	k.leaderAddress = address
+	if k.options.forwardWrites {
+		k.state.SetReadOnly(client)
+	} else {
+		k.state.SetReadOnly(nil)
+	}
 	ctx := context.Background()
 	go func() {
-		err := k.replicationClient.Run(ctx)
+		err := client.Run(ctx)
 		if err != nil {
 			k.logger.Error(ctx, "run replication client", log.Any("error", err))
 		}
 	}()
 }
 
+func RegisterProjection[T any](
+	k *Keeper,
+	name string,
+	handler projection.Handler[T],
+	opts ...projection.Option,
+) *projection.Projection {
+	p := projection.New(name, k.state, k.options.codec, handler, k.logger, opts...)
+	k.projections = append(k.projections, p)
+	return p
+}
+
+func (k *Keeper) Projections() []*projection.Projection {
+	return k.projections
+}
+
 func (k *Keeper) WalIndexMetric() metric.Metric {
 	return metric.Metric{
 		Name:        "wal_index",
@@ -121,6 +264,36 @@ func (k *Keeper) WalIndexMetric() metric.Metric {
 	}
 }
 
+func (k *Keeper) ProjectionLagMetric() metric.Metric {
+	return metric.Metric{
+		Name:        "projection_lag",
+		Description: "Index lag of projection from local Write Ahead Log for specific state",
+		Labels:      []string{"state", "projection"},
+		Collect: func() []metric.Value {
+			values := make([]metric.Value, 0, len(k.projections))
+			for _, p := range k.projections {
+				values = append(values, metric.ValueOf(int(p.Lag()), k.name, p.Name()))
+			}
+			return values
+		},
+	}
+}
+
+func (k *Keeper) ProjectionSkippedMetric() metric.Metric {
+	return metric.Metric{
+		Name:        "projection_skipped_entries",
+		Description: "Number of entries skipped by projection because they can't be decoded",
+		Labels:      []string{"state", "projection"},
+		Collect: func() []metric.Value {
+			values := make([]metric.Value, 0, len(k.projections))
+			for _, p := range k.projections {
+				values = append(values, metric.ValueOf(int(p.Skipped()), k.name, p.Name()))
+			}
+			return values
+		},
+	}
+}
+
 func (k *Keeper) Run(ctx context.Context) error {
 	group, ctx := errgroup.WithContext(ctx)
 	group.Go(func() error {
@@ -130,6 +303,15 @@ func (k *Keeper) Run(ctx context.Context) error {
 		}
 		return nil
 	})
+	for _, p := range k.projections {
+		group.Go(func() error {
+			err := p.Run(ctx)
+			if err != nil {
+				return errors.WithMessagef(err, "run projection %s", p.Name())
+			}
+			return nil
+		})
+	}
 	group.Go(func() error {
 		if k.options.serverPort <= 0 {
 			k.logger.Info(ctx, "skip running wal replication server, server port is unspecified", log.String("state", k.name))
@@ -149,13 +331,20 @@ func (k *Keeper) Run(ctx context.Context) error {
 
 func (k *Keeper) Close() error {
 	closers := []app.Closer{
-		k.replicationServer,
 		app.CloserFunc(func() error {
-			if k.replicationClient != nil {
-				_ = k.replicationClient.Close()
+			for _, p := range k.projections {
+				p.Close()
 			}
 			return nil
 		}),
+		k.replicationServer,
+		app.CloserFunc(func() error {
+			k.roleLock.Lock()
+			defer k.roleLock.Unlock()
+
+			k.stopReplication()
+			return nil
+		}),
 		k.state,
 	}
 	for _, closer := range closers {
@@ -200,3 +389,16 @@ func metricsHook() walx.Hook {
 		}
 	}
 }
+
+// replicaId identifies the node by host and WAL directory
+func replicaId(dir string) string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		absDir = dir
+	}
+	return hostname + ":" + absDir
+}
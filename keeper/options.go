@@ -1,6 +1,8 @@
 package keeper
 
 import (
+	"time"
+
 	"github.com/txix-open/walx/v2"
 	"github.com/txix-open/walx/v2/replication"
 	"github.com/txix-open/walx/v2/state"
@@ -15,6 +17,8 @@ type options struct {
 	replicationClientOptions []replication.ClientOption
 	replicationServerOptions []replication.ServerOption
 	codec                    state.Codec
+	forwardWrites            bool
+	replication              state.Replication
 }
 
 func newOptions() *options {
@@ -65,3 +69,21 @@ func WithCodec(codec state.Codec) Option {
 		o.codec = codec
 	}
 }
+
+func ForwardWritesToLeader() Option {
+	return func(o *options) {
+		o.forwardWrites = true
+	}
+}
+
+// WithReplication makes writes on the leader wait until the given number of followers
+// acknowledge the event. Mode and timeout are described by state.ReplicationMode
+func WithReplication(mode state.ReplicationMode, replicas int, timeout time.Duration) Option {
+	return func(o *options) {
+		o.replication = state.Replication{
+			Mode:     mode,
+			Replicas: replicas,
+			Timeout:  timeout,
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package projection
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+type Checkpoint interface {
+	Load() (uint64, error)
+	Save(index uint64) error
+}
+
+type MemCheckpoint struct {
+	index *atomic.Uint64
+}
+
+func NewMemCheckpoint() MemCheckpoint {
+	return MemCheckpoint{
+		index: &atomic.Uint64{},
+	}
+}
+
+func (c MemCheckpoint) Load() (uint64, error) {
+	return c.index.Load(), nil
+}
+
+func (c MemCheckpoint) Save(index uint64) error {
+	c.index.Store(index)
+	return nil
+}
+
+type FileCheckpoint struct {
+	path string
+}
+
+func NewFileCheckpoint(path string) FileCheckpoint {
+	return FileCheckpoint{
+		path: path,
+	}
+}
+
+func (c FileCheckpoint) Load() (uint64, error) {
+	data, err := os.ReadFile(c.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, errors.WithMessagef(err, "read checkpoint file %s", c.path)
+	}
+
+	index, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, errors.WithMessagef(err, "parse checkpoint file %s", c.path)
+	}
+	return index, nil
+}
+
+func (c FileCheckpoint) Save(index uint64) error {
+	tmpPath := c.path + ".tmp"
+	err := os.MkdirAll(filepath.Dir(c.path), 0755)
+	if err != nil {
+		return errors.WithMessage(err, "create checkpoint dir")
+	}
+	err = os.WriteFile(tmpPath, []byte(strconv.FormatUint(index, 10)), 0644)
+	if err != nil {
+		return errors.WithMessagef(err, "write checkpoint file %s", tmpPath)
+	}
+	err = os.Rename(tmpPath, c.path)
+	if err != nil {
+		return errors.WithMessagef(err, "rename checkpoint file %s", tmpPath)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package projection
+
+import (
+	"time"
+
+	"github.com/txix-open/walx/v2/stream"
+)
+
+type options struct {
+	filteredStreams []string
+	checkpoint      Checkpoint
+	batchSize       int
+	retryTimeout    time.Duration
+	maxRetryTimeout time.Duration
+}
+
+func newOptions() *options {
+	return &options{
+		filteredStreams: []string{stream.AllStreams},
+		checkpoint:      NewMemCheckpoint(),
+		batchSize:       100,
+		retryTimeout:    1 * time.Second,
+		maxRetryTimeout: 30 * time.Second,
+	}
+}
+
+type Option func(o *options)
+
+func FilterStreams(streams ...string) Option {
+	return func(o *options) {
+		o.filteredStreams = streams
+	}
+}
+
+func WithCheckpoint(checkpoint Checkpoint) Option {
+	return func(o *options) {
+		o.checkpoint = checkpoint
+	}
+}
+
+func BatchSize(size int) Option {
+	return func(o *options) {
+		if size > 0 {
+			o.batchSize = size
+		}
+	}
+}
+
+func RetryTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.retryTimeout = timeout
+	}
+}
+
+// MaxRetryTimeout limits the exponential backoff between handler retries
+func MaxRetryTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.maxRetryTimeout = timeout
+	}
+}
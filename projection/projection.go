@@ -0,0 +1,255 @@
+package projection
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/txix-open/isp-kit/log"
+	"github.com/txix-open/walx/v2"
+	"github.com/txix-open/walx/v2/state"
+	"github.com/txix-open/walx/v2/stream"
+)
+
+type Handler[T any] interface {
+	Handle(ctx context.Context, index uint64, event T) error
+	Reset(ctx context.Context) error
+}
+
+type Log interface {
+	OpenReader(lastIndex uint64) walx.Reader
+	FirstIndex() (uint64, error)
+	LastIndex() uint64
+}
+
+type Projection struct {
+	name    string
+	log     Log
+	decode  func(entry walx.Entry) (func(ctx context.Context) error, error)
+	reset   func(ctx context.Context) error
+	matcher stream.Matcher
+	options *options
+	logger  log.Logger
+
+	position         *atomic.Uint64
+	skipped          *atomic.Uint64
+	rebuildRequested *atomic.Bool
+	closed           *atomic.Bool
+	reader           walx.Reader
+	mu               sync.Locker
+}
+
+func New[T any](
+	name string,
+	log Log,
+	codec state.Codec,
+	handler Handler[T],
+	logger log.Logger,
+	opts ...Option,
+) *Projection {
+	options := newOptions()
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	decode := func(entry walx.Entry) (func(ctx context.Context) error, error) {
+		event, err := stream.ReadMessage[T](entry.Data, codec)
+		if err != nil {
+			return nil, errors.WithMessage(err, "read message")
+		}
+		return func(ctx context.Context) error {
+			return handler.Handle(ctx, entry.Index, event)
+		}, nil
+	}
+	return &Projection{
+		name:             name,
+		log:              log,
+		decode:           decode,
+		reset:            handler.Reset,
+		matcher:          stream.NewMatcher(options.filteredStreams),
+		options:          options,
+		logger:           logger,
+		position:         &atomic.Uint64{},
+		skipped:          &atomic.Uint64{},
+		rebuildRequested: &atomic.Bool{},
+		closed:           &atomic.Bool{},
+		mu:               &sync.Mutex{},
+	}
+}
+
+func (p *Projection) Name() string {
+	return p.name
+}
+
+func (p *Projection) Position() uint64 {
+	return p.position.Load()
+}
+
+// Skipped returns the number of entries which could not be decoded and were skipped
+func (p *Projection) Skipped() uint64 {
+	return p.skipped.Load()
+}
+
+func (p *Projection) Lag() uint64 {
+	lastIndex := p.log.LastIndex()
+	position := p.position.Load()
+	if position >= lastIndex {
+		return 0
+	}
+	return lastIndex - position
+}
+
+func (p *Projection) Run(ctx context.Context) error {
+	ctx = log.ToContext(ctx, log.String("projection", p.name))
+	for {
+		reader, err := p.open(ctx)
+		if errors.Is(err, walx.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		err = p.consume(ctx, reader)
+		reader.Close()
+		switch {
+		case errors.Is(err, walx.ErrClosed) && p.rebuildRequested.Load():
+			continue
+		case errors.Is(err, walx.ErrClosed):
+			return nil
+		case errors.Is(err, context.Canceled):
+			return nil
+		case err != nil:
+			return err
+		}
+	}
+}
+
+func (p *Projection) Rebuild() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.rebuildRequested.Store(true)
+	if p.reader != nil {
+		p.reader.Close()
+	}
+}
+
+func (p *Projection) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.closed.Store(true)
+	if p.reader != nil {
+		p.reader.Close()
+	}
+}
+
+func (p *Projection) open(ctx context.Context) (walx.Reader, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed.Load() {
+		return nil, walx.ErrClosed
+	}
+
+	if p.rebuildRequested.Load() {
+		p.logger.Info(ctx, "rebuild projection")
+		err := p.reset(ctx)
+		if err != nil {
+			return nil, errors.WithMessage(err, "reset projection")
+		}
+		err = p.options.checkpoint.Save(0)
+		if err != nil {
+			return nil, errors.WithMessage(err, "reset checkpoint")
+		}
+		p.rebuildRequested.Store(false)
+	}
+
+	startFrom, err := p.options.checkpoint.Load()
+	if err != nil {
+		return nil, errors.WithMessage(err, "load checkpoint")
+	}
+	firstIndex, err := p.log.FirstIndex()
+	if err != nil {
+		return nil, errors.WithMessage(err, "get first index")
+	}
+	if firstIndex > 0 && startFrom < firstIndex-1 {
+		p.logger.Warn(
+			ctx,
+			"projection checkpoint is behind the first wal index, some entries are skipped",
+			log.Any("checkpoint", startFrom),
+			log.Any("firstIndex", firstIndex),
+		)
+		startFrom = firstIndex - 1
+	}
+
+	p.position.Store(startFrom)
+	p.reader = p.log.OpenReader(startFrom)
+	return p.reader, nil
+}
+
+func (p *Projection) consume(ctx context.Context, reader walx.Reader) error {
+	for {
+		entries, err := reader.ReadAtMost(ctx, p.options.batchSize)
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range entries {
+			err := p.handleEntry(ctx, entry)
+			if err != nil {
+				return err
+			}
+			p.position.Store(entry.Index)
+		}
+
+		if len(entries) == 0 {
+			continue
+		}
+		err = p.options.checkpoint.Save(entries.LastIndex())
+		if err != nil {
+			return errors.WithMessage(err, "save checkpoint")
+		}
+	}
+}
+
+// handleEntry skips entries which can't be decoded, e.g. events of other streams,
+// and retries handler errors with exponential backoff
+func (p *Projection) handleEntry(ctx context.Context, entry walx.Entry) error {
+	if len(entry.Data) == 0 {
+		return nil
+	}
+	if !p.matcher.Match(entry) {
+		return nil
+	}
+
+	handle, err := p.decode(entry)
+	if err != nil {
+		p.skipped.Add(1)
+		p.logger.Warn(ctx, errors.WithMessage(err, "skip projection entry"), log.Any("index", entry.Index))
+		return nil
+	}
+
+	retryTimeout := p.options.retryTimeout
+	for {
+		if p.closed.Load() || p.rebuildRequested.Load() {
+			return walx.ErrClosed
+		}
+
+		err := handle(ctx)
+		if err == nil {
+			return nil
+		}
+
+		p.logger.Error(ctx, errors.WithMessage(err, "handle projection entry"), log.Any("index", entry.Index))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(retryTimeout):
+		}
+		retryTimeout = min(2*retryTimeout, p.options.maxRetryTimeout)
+	}
+}
@@ -0,0 +1,214 @@
+package projection_test
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/txix-open/isp-kit/log"
+	"github.com/txix-open/walx/v2"
+	"github.com/txix-open/walx/v2/projection"
+	"github.com/txix-open/walx/v2/state/codec/json"
+	"github.com/txix-open/walx/v2/stream"
+)
+
+type Event struct {
+	Value int
+}
+
+type sumHandler struct {
+	lock    sync.Locker
+	sum     int
+	indexes []uint64
+	resets  int
+}
+
+func newSumHandler() *sumHandler {
+	return &sumHandler{
+		lock: &sync.Mutex{},
+	}
+}
+
+func (h *sumHandler) Handle(ctx context.Context, index uint64, event Event) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.sum += event.Value
+	h.indexes = append(h.indexes, index)
+	return nil
+}
+
+func (h *sumHandler) Reset(ctx context.Context) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.sum = 0
+	h.indexes = nil
+	h.resets++
+	return nil
+}
+
+func (h *sumHandler) Sum() int {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	return h.sum
+}
+
+func TestProjection(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	logger, err := log.New()
+	require.NoError(err)
+	wal := createWal(t, require)
+	codec := json.NewCodec()
+	numbers := stream.NewWriter(wal, codec, "numbers")
+	other := stream.NewWriter(wal, codec, "other")
+
+	for i := 1; i <= 10; i++ {
+		err := numbers.WriteEvent(Event{Value: i}, nil)
+		require.NoError(err)
+		err = other.WriteEvent(Event{Value: 1000}, nil)
+		require.NoError(err)
+	}
+
+	checkpointPath := filepath.Join(t.TempDir(), "checkpoint")
+	handler := newSumHandler()
+	p := projection.New[Event](
+		"sum",
+		wal,
+		codec,
+		handler,
+		logger,
+		projection.FilterStreams("numbers"),
+		projection.WithCheckpoint(projection.NewFileCheckpoint(checkpointPath)),
+		projection.BatchSize(3),
+	)
+	require.EqualValues(20, p.Lag())
+	runErr := run(p)
+
+	require.Eventually(func() bool {
+		return p.Lag() == 0
+	}, 2*time.Second, 10*time.Millisecond)
+	require.EqualValues(55, handler.Sum())
+
+	p.Rebuild()
+	require.Eventually(func() bool {
+		handler.lock.Lock()
+		defer handler.lock.Unlock()
+		return handler.resets == 1 && len(handler.indexes) == 10
+	}, 2*time.Second, 10*time.Millisecond)
+	require.EqualValues(55, handler.Sum())
+	p.Close()
+	require.NoError(<-runErr)
+
+	for i := 1; i <= 5; i++ {
+		err := numbers.WriteEvent(Event{Value: 100}, nil)
+		require.NoError(err)
+	}
+
+	restored := projection.New[Event](
+		"sum",
+		wal,
+		codec,
+		handler,
+		logger,
+		projection.FilterStreams("numbers"),
+		projection.WithCheckpoint(projection.NewFileCheckpoint(checkpointPath)),
+	)
+	runErr = run(restored)
+
+	require.Eventually(func() bool {
+		return restored.Lag() == 0
+	}, 2*time.Second, 10*time.Millisecond)
+	require.EqualValues(555, handler.Sum())
+	restored.Close()
+	require.NoError(<-runErr)
+}
+
+type flakyHandler struct {
+	*sumHandler
+	failures int
+}
+
+func (h *flakyHandler) Handle(ctx context.Context, index uint64, event Event) error {
+	h.lock.Lock()
+	if h.failures > 0 {
+		h.failures--
+		h.lock.Unlock()
+		return errors.New("temporary failure")
+	}
+	h.lock.Unlock()
+	return h.sumHandler.Handle(ctx, index, event)
+}
+
+func TestProjection_SkipUndecodable(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	logger, err := log.New()
+	require.NoError(err)
+	wal := createWal(t, require)
+	codec := json.NewCodec()
+	numbers := stream.NewWriter(wal, codec, "numbers")
+	other := stream.NewWriter(wal, codec, "other")
+
+	for i := 1; i <= 5; i++ {
+		err := numbers.WriteEvent(Event{Value: i}, nil)
+		require.NoError(err)
+		err = other.WriteEvent("not an event", nil)
+		require.NoError(err)
+	}
+
+	handler := &flakyHandler{sumHandler: newSumHandler(), failures: 3}
+	p := projection.New[Event](
+		"sum",
+		wal,
+		codec,
+		handler,
+		logger,
+		projection.RetryTimeout(10*time.Millisecond),
+		projection.MaxRetryTimeout(20*time.Millisecond),
+	)
+	runErr := run(p)
+
+	require.Eventually(func() bool {
+		return p.Lag() == 0
+	}, 2*time.Second, 10*time.Millisecond)
+	require.EqualValues(15, handler.Sum())
+	require.EqualValues(5, p.Skipped())
+	p.Close()
+	require.NoError(<-runErr)
+}
+
+func run(p *projection.Projection) <-chan error {
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- p.Run(context.Background())
+	}()
+	return runErr
+}
+
+func dir() string {
+	d := make([]byte, 8)
+	_, _ = rand.Read(d)
+	return hex.EncodeToString(d)
+}
+
+func createWal(t *testing.T, require *require.Assertions) *walx.Log {
+	dir := dir()
+	t.Cleanup(func() {
+		_ = os.RemoveAll(dir)
+	})
+	wal, err := walx.Open(dir)
+	require.NoError(err)
+	return wal
+}
@@ -0,0 +1,310 @@
+package replication_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/txix-open/isp-kit/log"
+	"github.com/txix-open/walx/v2/replication"
+	"github.com/txix-open/walx/v2/state"
+	"github.com/txix-open/walx/v2/state/codec/json"
+)
+
+func TestSyncReplication(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	logger, err := log.New()
+	require.NoError(err)
+
+	leaderCounter := newCounter()
+	leader := state.New(createWal(t, require), leaderCounter, json.NewCodec(), "test")
+	go func() {
+		err := leader.Run(context.Background())
+		require.NoError(err)
+	}()
+	srv := replication.NewServer(leader.Log, logger)
+	lis, addr := listener(require)
+	go func() {
+		err := srv.Serve(lis)
+		require.NoError(err)
+	}()
+	t.Cleanup(func() {
+		_ = srv.Close()
+		_ = leader.Close()
+	})
+
+	followers := make([]*state.State, 0)
+	for _, id := range []string{"follower-1", "follower-2"} {
+		follower := state.New(createWal(t, require), newCounter(), json.NewCodec(), "test")
+		cli := replication.NewClient(follower, "test", addr, []string{"test"}, logger, replication.ReplicaId(id))
+		go func() {
+			err := cli.Run(context.Background())
+			require.NoError(err)
+		}()
+		t.Cleanup(func() {
+			_ = cli.Close()
+			_ = follower.Close()
+		})
+		followers = append(followers, follower)
+	}
+	time.Sleep(100 * time.Millisecond) // we must run state before first write
+
+	err = leader.SetReplication(state.Replication{
+		Mode:     state.SyncReplication,
+		Waiter:   srv,
+		Replicas: 2,
+	})
+	require.ErrorIs(err, state.ErrInvalidReplication)
+	err = leader.SetReplication(state.Replication{
+		Mode:     state.SyncReplication,
+		Waiter:   srv,
+		Replicas: 2,
+		Timeout:  2 * time.Second,
+	})
+	require.NoError(err)
+	for i := 1; i <= 5; i++ {
+		response, err := leader.Apply(AddRequest{Value: 1}, nil)
+		require.NoError(err)
+		require.EqualValues(i, response)
+		for _, follower := range followers {
+			require.EqualValues(leader.LastIndex(), follower.LastIndex())
+		}
+	}
+
+	sync, err := leader.WithReplication(state.Replication{
+		Mode:     state.SyncReplication,
+		Waiter:   srv,
+		Replicas: 3,
+		Timeout:  200 * time.Millisecond,
+	})
+	require.NoError(err)
+	_, err = sync.Apply(AddRequest{Value: 1}, nil)
+	require.ErrorIs(err, state.ErrNotReplicated)
+	require.EqualValues(6, leaderCounter.Value())
+
+	semiSync, err := leader.WithReplication(state.Replication{
+		Mode:     state.SemiSyncReplication,
+		Waiter:   srv,
+		Replicas: 3,
+		Timeout:  200 * time.Millisecond,
+	})
+	require.NoError(err)
+	response, err := semiSync.Apply(AddRequest{Value: 1}, nil)
+	require.NoError(err)
+	require.EqualValues(7, response)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	err = srv.WaitReplicated(ctx, leader.LastIndex(), []byte("test"), 2)
+	require.NoError(err)
+
+	_, err = leader.WithReplication(state.Replication{
+		Mode:    state.SyncReplication,
+		Waiter:  srv,
+		Timeout: time.Second,
+	})
+	require.ErrorIs(err, state.ErrInvalidReplication)
+}
+
+func TestSyncReplication_FilteredFollower(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	logger, err := log.New()
+	require.NoError(err)
+
+	leader := state.New(createWal(t, require), newCounter(), json.NewCodec(), "test")
+	go func() {
+		err := leader.Run(context.Background())
+		require.NoError(err)
+	}()
+	srv := replication.NewServer(leader.Log, logger)
+	lis, addr := listener(require)
+	go func() {
+		err := srv.Serve(lis)
+		require.NoError(err)
+	}()
+	t.Cleanup(func() {
+		_ = srv.Close()
+		_ = leader.Close()
+	})
+
+	follower := state.New(createWal(t, require), newCounter(), json.NewCodec(), "other")
+	cli := replication.NewClient(follower, "other", addr, []string{"other"}, logger, replication.ReplicaId("follower"))
+	go func() {
+		err := cli.Run(context.Background())
+		require.NoError(err)
+	}()
+	t.Cleanup(func() {
+		_ = cli.Close()
+		_ = follower.Close()
+	})
+	time.Sleep(100 * time.Millisecond) // we must run state before first write
+
+	sync, err := leader.WithReplication(state.Replication{
+		Mode:     state.SyncReplication,
+		Waiter:   srv,
+		Replicas: 1,
+		Timeout:  300 * time.Millisecond,
+	})
+	require.NoError(err)
+	_, err = sync.Apply(AddRequest{Value: 1}, nil)
+	require.ErrorIs(err, state.ErrNotReplicated)
+	require.EqualValues(leader.LastIndex(), follower.LastIndex())
+
+	replicas := srv.Replicas()
+	require.Len(replicas, 1)
+	require.Equal([]string{"other"}, replicas[0].FilteredStreams)
+}
+
+func TestReplicaRegistry(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	logger, err := log.New()
+	require.NoError(err)
+
+	leader := state.New(createWal(t, require), newCounter(), json.NewCodec(), "test")
+	go func() {
+		err := leader.Run(context.Background())
+		require.NoError(err)
+	}()
+	srv := replication.NewServer(leader.Log, logger)
+	lis, addr := listener(require)
+	go func() {
+		err := srv.Serve(lis)
+		require.NoError(err)
+	}()
+	t.Cleanup(func() {
+		_ = srv.Close()
+		_ = leader.Close()
+	})
+
+	clients := make([]*replication.Client, 0)
+	for _, id := range []string{"follower-2", "follower-1"} {
+		follower := state.New(createWal(t, require), newCounter(), json.NewCodec(), "test")
+		go func() {
+			err := follower.Run(context.Background())
+			require.NoError(err)
+		}()
+		cli := replication.NewClient(
+			follower,
+			"test",
+			addr,
+			[]string{"test"},
+			logger,
+			replication.ReplicaId(id),
+			replication.AckInterval(50*time.Millisecond),
+		)
+		go func() {
+			err := cli.Run(context.Background())
+			require.NoError(err)
+		}()
+		t.Cleanup(func() {
+			_ = follower.Close()
+		})
+		clients = append(clients, cli)
+	}
+	time.Sleep(100 * time.Millisecond) // we must run state before first write
+
+	for i := 0; i < 5; i++ {
+		_, err := leader.Apply(AddRequest{Value: 1}, nil)
+		require.NoError(err)
+	}
+
+	lastIndex := leader.LastIndex()
+	require.Eventually(func() bool {
+		replicas := srv.Replicas()
+		if len(replicas) != 2 {
+			return false
+		}
+		for _, replica := range replicas {
+			if replica.WrittenIndex != lastIndex || replica.AppliedIndex != lastIndex {
+				return false
+			}
+		}
+		return true
+	}, 2*time.Second, 10*time.Millisecond)
+
+	replicas := srv.Replicas()
+	require.Equal("follower-1", replicas[0].Id)
+	require.Equal("follower-2", replicas[1].Id)
+	for _, replica := range replicas {
+		require.NotEmpty(replica.Address)
+		require.False(replica.ConnectedSince.IsZero())
+		require.EqualValues(lastIndex, replica.SentIndex)
+	}
+
+	require.NoError(clients[0].Close())
+	require.Eventually(func() bool {
+		replicas := srv.Replicas()
+		return len(replicas) == 1 && replicas[0].Id == "follower-1"
+	}, 2*time.Second, 10*time.Millisecond)
+	require.NoError(clients[1].Close())
+}
+
+func TestReplicaRegistry_Eviction(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	logger, err := log.New()
+	require.NoError(err)
+
+	leader := state.New(createWal(t, require), newCounter(), json.NewCodec(), "test")
+	go func() {
+		err := leader.Run(context.Background())
+		require.NoError(err)
+	}()
+	srv := replication.NewServer(leader.Log, logger, replication.ReplicaTtl(200*time.Millisecond))
+	lis, addr := listener(require)
+	go func() {
+		err := srv.Serve(lis)
+		require.NoError(err)
+	}()
+	t.Cleanup(func() {
+		_ = srv.Close()
+		_ = leader.Close()
+	})
+
+	connect := func(id string) *replication.Client {
+		follower := state.New(createWal(t, require), newCounter(), json.NewCodec(), "test")
+		cli := replication.NewClient(follower, "test", addr, []string{"test"}, logger, replication.ReplicaId(id))
+		go func() {
+			err := cli.Run(context.Background())
+			require.NoError(err)
+		}()
+		t.Cleanup(func() {
+			_ = follower.Close()
+		})
+		return cli
+	}
+	waitReplicated := func(replicas int) error {
+		ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+		defer cancel()
+		return srv.WaitReplicated(ctx, leader.LastIndex(), []byte("test"), replicas)
+	}
+
+	cli := connect("follower-1")
+	time.Sleep(100 * time.Millisecond) // we must run state before first write
+	_, err = leader.Apply(AddRequest{Value: 1}, nil)
+	require.NoError(err)
+	require.NoError(waitReplicated(1))
+
+	require.NoError(cli.Close())
+	require.Eventually(func() bool {
+		return len(srv.Replicas()) == 0
+	}, 2*time.Second, 10*time.Millisecond)
+	require.NoError(waitReplicated(1))
+
+	time.Sleep(300 * time.Millisecond)
+	other := connect("follower-2")
+	require.Eventually(func() bool {
+		return len(srv.Replicas()) == 1
+	}, 2*time.Second, 10*time.Millisecond)
+	require.NoError(waitReplicated(1))
+	require.Error(waitReplicated(2))
+	require.NoError(other.Close())
+}
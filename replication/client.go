@@ -11,8 +11,10 @@ import (
 
 	"github.com/pkg/errors"
 	"github.com/txix-open/isp-kit/log"
+	"github.com/txix-open/isp-kit/requestid"
 	"github.com/txix-open/walx/v2"
 	"github.com/txix-open/walx/v2/replication/replicator"
+	"github.com/txix-open/walx/v2/state"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
@@ -24,6 +26,25 @@ type Wal interface {
 	LastIndex() uint64
 }
 
+// AppliedIndexer is implemented by a Wal which also applies written entries, e.g. *state.State.
+// The applied index is reported to the server with acks
+type AppliedIndexer interface {
+	AppliedIndex() uint64
+}
+
+var (
+	ErrDiverged = errors.New("wal diverged from the server")
+
+	errNotApplied = errors.New("written entries are not applied yet")
+)
+
+// Epochs resolves leadership epochs of WAL entries, e.g. journaled by keeper
+type Epochs interface {
+	// EpochAt returns the epoch the entry with index was written in, 0 if it precedes the first known epoch.
+	// ok is false if the entry is not applied yet
+	EpochAt(index uint64) (epoch uint64, ok bool)
+}
+
 type Client struct {
 	wal             Wal
 	state           string
@@ -50,6 +71,9 @@ func NewClient(
 	for _, opt := range opts {
 		opt(options)
 	}
+	if options.replicaId == "" {
+		options.replicaId = requestid.Next()
+	}
 	return &Client{
 		remoteAddr:      remoteAddr,
 		state:           state,
@@ -74,21 +98,38 @@ func (c *Client) Run(ctx context.Context) error {
 			return nil
 		}
 
-		reader, err := c.begin(ctx)
+		reader, ack, err := c.begin(ctx)
+		if errors.Is(err, errNotApplied) {
+			c.logger.Info(ctx, "wait for written entries to be applied before replication", log.Any("lastIndex", c.wal.LastIndex()))
+			<-time.After(c.options.reconnectTimeout)
+			continue
+		}
 		if err != nil {
 			c.logger.Error(ctx, "unexpected error during replication, begin replication", log.Any("error", err), log.Any("lastIndex", c.wal.LastIndex()))
 			<-time.After(c.options.reconnectTimeout)
 			continue
 		}
 
+		written := make(chan struct{}, 1)
+		ackCtx, stopAcks := context.WithCancel(ctx)
+		if ack != nil {
+			go c.runAcks(ackCtx, ack, written)
+		}
+
 		toWrite := make(walx.Entries, 0)
 		for {
 			entries, err := reader.Recv()
 			if status.Code(err) == codes.Canceled || c.closed.Load() {
+				stopAcks()
 				c.logger.Info(ctx, "stop replication, close signal received", log.Any("lastIndex", c.wal.LastIndex()))
 				return nil
 			}
+			if status.Code(err) == codes.FailedPrecondition {
+				stopAcks()
+				return errors.WithMessagef(ErrDiverged, "last index %d: %s", c.wal.LastIndex(), status.Convert(err).Message())
+			}
 			if err != nil {
+				stopAcks()
 				if errors.Is(err, io.EOF) {
 					c.logger.Info(ctx, "pause replication, remote server was closed", log.Any("lastIndex", c.wal.LastIndex()))
 				} else {
@@ -108,9 +149,15 @@ func (c *Client) Run(ctx context.Context) error {
 
 			err = c.wal.WriteEntries(toWrite)
 			if err != nil {
+				stopAcks()
 				return errors.WithMessage(err, "wal write entry")
 			}
 
+			select {
+			case written <- struct{}{}:
+			default:
+			}
+
 			lastIndex := c.wal.LastIndex()
 			if lastIndex%c.options.logIntervalIndex == 0 {
 				c.logger.Info(ctx, "replication in progress", log.Any("lastIndex", lastIndex))
@@ -119,7 +166,38 @@ func (c *Client) Run(ctx context.Context) error {
 	}
 }
 
-func (c *Client) begin(ctx context.Context) (replicator.Replicator_BeginReplicationClient, error) {
+// runAcks reports written and applied indexes to the server after each write
+// and periodically, so the applied index catches up with the written one
+func (c *Client) runAcks(ctx context.Context, ack replicator.Replicator_AckClient, written <-chan struct{}) {
+	ticker := time.NewTicker(c.options.ackInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			_ = ack.CloseSend()
+			return
+		case <-written:
+		case <-ticker.C:
+		}
+
+		request := &replicator.AckRequest{
+			ReplicaId: c.options.replicaId,
+			Index:     c.wal.LastIndex(),
+		}
+		applied, ok := c.wal.(AppliedIndexer)
+		if ok {
+			request.AppliedIndex = applied.AppliedIndex()
+		}
+		err := ack.Send(request)
+		if err != nil {
+			c.logger.Warn(ctx, "acks are disabled until reconnect, send ack", log.Any("error", err))
+			return
+		}
+	}
+}
+
+func (c *Client) begin(ctx context.Context) (replicator.Replicator_BeginReplicationClient, replicator.Replicator_AckClient, error) {
 	c.mu.Lock()
 	if c.grpcCli != nil {
 		_ = c.grpcCli.Close()
@@ -148,22 +226,62 @@ func (c *Client) begin(ctx context.Context) (replicator.Replicator_BeginReplicat
 	)
 	c.mu.Unlock()
 	if err != nil {
-		return nil, errors.WithMessagef(err, "grpc dial to %s", c.remoteAddr)
+		return nil, nil, errors.WithMessagef(err, "grpc dial to %s", c.remoteAddr)
 	}
 	replCli := replicator.NewReplicatorClient(c.grpcCli)
 
 	lastIndex := c.wal.LastIndex()
-	c.logger.Info(ctx, "begin state replication", log.String("remoteAddress", c.remoteAddr), log.Any("lastIndex", lastIndex))
-	reader, err := replCli.BeginReplication(ctx, &replicator.BeginRequest{
+	request := &replicator.BeginRequest{
 		LastIndex:       lastIndex,
 		FilteredStreams: c.filteredStreams,
 		Limit:           c.options.batchSize,
-	})
+		ReplicaId:       c.options.replicaId,
+	}
+	if c.options.epochs != nil {
+		lastEpoch, ok := c.options.epochs.EpochAt(lastIndex)
+		if !ok {
+			return nil, nil, errNotApplied
+		}
+		request.LastEpoch = &lastEpoch
+	}
+	c.logger.Info(ctx, "begin state replication", log.String("remoteAddress", c.remoteAddr), log.Any("lastIndex", lastIndex))
+	reader, err := replCli.BeginReplication(ctx, request)
+	if err != nil {
+		return nil, nil, errors.WithMessage(err, "call begin")
+	}
+
+	ack, err := replCli.Ack(ctx)
 	if err != nil {
-		return nil, errors.WithMessage(err, "call begin")
+		c.logger.Warn(ctx, "acks are disabled until reconnect, call ack", log.Any("error", err))
+		return reader, nil, nil
 	}
 
-	return reader, nil
+	return reader, ack, nil
+}
+
+// Forward writes data on the server, state.ErrNotReplicated is returned
+// if the written entry isn't replicated as required by the server state
+func (c *Client) Forward(data []byte) (uint64, error) {
+	c.mu.Lock()
+	conn := c.grpcCli
+	c.mu.Unlock()
+	if conn == nil {
+		return 0, errors.New("replication client is not connected")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.options.forwardTimeout)
+	defer cancel()
+
+	resp, err := replicator.NewReplicatorClient(conn).Forward(ctx, &replicator.ForwardRequest{
+		Data: data,
+	})
+	if status.Code(err) == codes.Aborted {
+		return 0, errors.WithMessage(state.ErrNotReplicated, status.Convert(err).Message())
+	}
+	if err != nil {
+		return 0, errors.WithMessagef(err, "call forward to %s", c.remoteAddr)
+	}
+	return resp.Index, nil
 }
 
 func (c *Client) logReplicationIndex(ctx context.Context) {
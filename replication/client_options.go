@@ -14,8 +14,12 @@ type clientOptions struct {
 	logIntervalInTime time.Duration
 	logIntervalIndex  uint64
 	batchSize         int32
+	forwardTimeout    time.Duration
 	tls               *tls.Config
 	grpcDialOptions   []grpc.DialOption
+	replicaId         string
+	ackInterval       time.Duration
+	epochs            Epochs
 }
 
 func newClientOptions() *clientOptions {
@@ -24,6 +28,8 @@ func newClientOptions() *clientOptions {
 		logIntervalInTime: 5 * time.Second,
 		logIntervalIndex:  500,
 		batchSize:         100,
+		forwardTimeout:    5 * time.Second,
+		ackInterval:       1 * time.Second,
 	}
 }
 
@@ -51,6 +57,12 @@ func BatchSize(size int32) ClientOption {
 	}
 }
 
+func ForwardTimeout(timeout time.Duration) ClientOption {
+	return func(o *clientOptions) {
+		o.forwardTimeout = timeout
+	}
+}
+
 func ClientTls(cfg *tls.Config) ClientOption {
 	return func(o *clientOptions) {
 		o.tls = cfg
@@ -62,3 +74,26 @@ func GrpcDialOptions(opts ...grpc.DialOption) ClientOption {
 		o.grpcDialOptions = append(o.grpcDialOptions, opts...)
 	}
 }
+
+// ReplicaId sets the identifier the client reports to the server with acks.
+// By default, a random identifier is generated for each client, so the server sees a new replica after restart,
+// set a stable one if replica positions and metrics must survive it.
+func ReplicaId(id string) ClientOption {
+	return func(o *clientOptions) {
+		o.replicaId = id
+	}
+}
+
+// AckInterval sets how often the client reports its positions when there is nothing to replicate
+func AckInterval(interval time.Duration) ClientOption {
+	return func(o *clientOptions) {
+		o.ackInterval = interval
+	}
+}
+
+// ClientEpochs reports the epoch of the last entry to the server, so it can refuse the diverged client
+func ClientEpochs(epochs Epochs) ClientOption {
+	return func(o *clientOptions) {
+		o.epochs = epochs
+	}
+}
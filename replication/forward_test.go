@@ -0,0 +1,225 @@
+package replication_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/txix-open/isp-kit/log"
+	"github.com/txix-open/walx/v2/replication"
+	"github.com/txix-open/walx/v2/state"
+	"github.com/txix-open/walx/v2/state/codec/json"
+)
+
+type AddRequest struct {
+	Value int
+}
+
+type counter struct {
+	lock  sync.Locker
+	value int
+}
+
+func newCounter() *counter {
+	return &counter{
+		lock: &sync.Mutex{},
+	}
+}
+
+func (c *counter) Apply(log state.Log) (any, error) {
+	req, err := state.UnmarshalEvent[AddRequest](log)
+	if err != nil {
+		return nil, err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.value += req.Value
+	return c.value, nil
+}
+
+func (c *counter) Value() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.value
+}
+
+func TestForward(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	logger, err := log.New()
+	require.NoError(err)
+
+	leaderCounter := newCounter()
+	leader := state.New(createWal(t, require), leaderCounter, json.NewCodec(), "test")
+	go func() {
+		err := leader.Run(context.Background())
+		require.NoError(err)
+	}()
+	srv := replication.NewServer(leader.Log, logger, replication.ForwardWritesTo(leader))
+	lis, addr := listener(require)
+	go func() {
+		err := srv.Serve(lis)
+		require.NoError(err)
+	}()
+
+	followerCounter := newCounter()
+	follower := state.New(createWal(t, require), followerCounter, json.NewCodec(), "test")
+	go func() {
+		err := follower.Run(context.Background())
+		require.NoError(err)
+	}()
+	cli := replication.NewClient(follower, "test", addr, []string{"test"}, logger)
+	go func() {
+		err := cli.Run(context.Background())
+		require.NoError(err)
+	}()
+	t.Cleanup(func() {
+		_ = cli.Close()
+		_ = srv.Close()
+		_ = follower.Close()
+		_ = leader.Close()
+	})
+	time.Sleep(100 * time.Millisecond) // we must run wait before first run
+
+	_, err = leader.Apply(AddRequest{Value: 1}, nil)
+	require.NoError(err)
+
+	follower.SetReadOnly(nil)
+	_, err = follower.Apply(AddRequest{Value: 1}, nil)
+	require.ErrorIs(err, state.ErrReadOnly)
+	_, err = follower.Write([]byte("data"), func(index uint64) {})
+	require.ErrorIs(err, state.ErrReadOnly)
+
+	follower.SetReadOnly(cli)
+	require.Eventually(func() bool {
+		return followerCounter.Value() == 1
+	}, 2*time.Second, 10*time.Millisecond)
+	for i := 2; i <= 10; i++ {
+		response, err := follower.Apply(AddRequest{Value: 1}, nil)
+		require.NoError(err)
+		require.EqualValues(i, response)
+		require.EqualValues(i, followerCounter.Value())
+	}
+	require.EqualValues(10, leaderCounter.Value())
+	require.EqualValues(leader.LastIndex(), follower.LastIndex())
+
+	leader.SetReadOnly(nil)
+	_, err = follower.Apply(AddRequest{Value: 1}, nil)
+	require.Error(err)
+}
+
+func TestForward_FilteredStream(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	logger, err := log.New()
+	require.NoError(err)
+
+	leader := state.New(createWal(t, require), newCounter(), json.NewCodec(), "test")
+	go func() {
+		err := leader.Run(context.Background())
+		require.NoError(err)
+	}()
+	srv := replication.NewServer(leader.Log, logger, replication.ForwardWritesTo(leader))
+	lis, addr := listener(require)
+	go func() {
+		err := srv.Serve(lis)
+		require.NoError(err)
+	}()
+
+	follower := state.New(createWal(t, require), newCounter(), json.NewCodec(), "test")
+	go func() {
+		err := follower.Run(context.Background())
+		require.NoError(err)
+	}()
+	cli := replication.NewClient(follower, "test", addr, []string{"other"}, logger)
+	go func() {
+		err := cli.Run(context.Background())
+		require.NoError(err)
+	}()
+	t.Cleanup(func() {
+		_ = cli.Close()
+		_ = srv.Close()
+		_ = follower.Close()
+		_ = leader.Close()
+	})
+	time.Sleep(100 * time.Millisecond) // we must run wait before first run
+
+	_, err = leader.Apply(AddRequest{Value: 1}, nil)
+	require.NoError(err)
+	require.Eventually(func() bool {
+		return follower.LastIndex() == leader.LastIndex()
+	}, 2*time.Second, 10*time.Millisecond)
+
+	follower.SetReadOnly(cli)
+	_, err = follower.Apply(AddRequest{Value: 1}, nil)
+	require.ErrorIs(err, state.ErrForwardFiltered)
+	require.EqualValues(leader.LastIndex(), follower.LastIndex())
+}
+
+func TestForward_SyncReplication(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	logger, err := log.New()
+	require.NoError(err)
+
+	leader := state.New(createWal(t, require), newCounter(), json.NewCodec(), "test")
+	go func() {
+		err := leader.Run(context.Background())
+		require.NoError(err)
+	}()
+	srv := replication.NewServer(leader.Log, logger, replication.ForwardWritesTo(leader))
+	lis, addr := listener(require)
+	go func() {
+		err := srv.Serve(lis)
+		require.NoError(err)
+	}()
+
+	followerCounter := newCounter()
+	follower := state.New(createWal(t, require), followerCounter, json.NewCodec(), "test")
+	go func() {
+		err := follower.Run(context.Background())
+		require.NoError(err)
+	}()
+	cli := replication.NewClient(follower, "test", addr, []string{"test"}, logger)
+	go func() {
+		err := cli.Run(context.Background())
+		require.NoError(err)
+	}()
+	t.Cleanup(func() {
+		_ = cli.Close()
+		_ = srv.Close()
+		_ = follower.Close()
+		_ = leader.Close()
+	})
+	time.Sleep(100 * time.Millisecond) // we must run wait before first run
+	follower.SetReadOnly(cli)
+
+	err = leader.SetReplication(state.Replication{
+		Mode:     state.SyncReplication,
+		Waiter:   srv,
+		Replicas: 2,
+		Timeout:  200 * time.Millisecond,
+	})
+	require.NoError(err)
+	_, err = follower.Apply(AddRequest{Value: 1}, nil)
+	require.ErrorIs(err, state.ErrNotReplicated)
+
+	err = leader.SetReplication(state.Replication{
+		Mode:     state.SyncReplication,
+		Waiter:   srv,
+		Replicas: 1,
+		Timeout:  time.Second,
+	})
+	require.NoError(err)
+	response, err := follower.Apply(AddRequest{Value: 1}, nil)
+	require.NoError(err)
+	require.EqualValues(2, response)
+}
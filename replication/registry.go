@@ -0,0 +1,236 @@
+package replication
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/txix-open/isp-kit/metrics"
+	"github.com/txix-open/walx/v2/stream"
+)
+
+const maxPendingBatches = 1024
+
+// Replica describes a follower connected to the server
+type Replica struct {
+	Id             string
+	Address        string
+	ConnectedSince time.Time
+	// FilteredStreams are streams the replica stores, entries of other streams are replicated empty
+	FilteredStreams []string
+	// SentIndex is the last index sent to the replica
+	SentIndex uint64
+	// WrittenIndex is the last index the replica acknowledged as persisted
+	WrittenIndex uint64
+	// AppliedIndex is the last index the replica acknowledged as applied to its state
+	AppliedIndex uint64
+	LastAckAt    time.Time
+}
+
+type sentBatch struct {
+	index  uint64
+	sentAt time.Time
+}
+
+type replicaEntry struct {
+	Replica
+	matcher     stream.Matcher
+	connections int
+	pending     []sentBatch
+	seenAt      time.Time
+}
+
+type ackWaiter struct {
+	index      uint64
+	streamName []byte
+	replicas   int
+	done       chan struct{}
+}
+
+// registry tracks replica positions reported with acks.
+// Disconnected replicas are kept for ttl, their acks still count towards WaitReplicated.
+// Replicas count only for entries of streams they store, because other entries are replicated without data
+type registry struct {
+	ttl      time.Duration
+	lock     sync.Mutex
+	replicas map[string]*replicaEntry
+	waiters  map[*ackWaiter]struct{}
+
+	ackLatency   *prometheus.SummaryVec
+	writtenGauge *prometheus.GaugeVec
+	appliedGauge *prometheus.GaugeVec
+}
+
+func newRegistry(ttl time.Duration) *registry {
+	return &registry{
+		ttl:      ttl,
+		replicas: make(map[string]*replicaEntry),
+		waiters:  make(map[*ackWaiter]struct{}),
+		ackLatency: metrics.GetOrRegister(metrics.DefaultRegistry, prometheus.NewSummaryVec(prometheus.SummaryOpts{
+			Subsystem:  "wal",
+			Name:       "replication_ack_latency_ms",
+			Help:       "Time in milliseconds from sending entries to follower until it acknowledges they are persisted",
+			Objectives: metrics.DefaultObjectives,
+		}, []string{"replica_id"})),
+		writtenGauge: metrics.GetOrRegister(metrics.DefaultRegistry, prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Subsystem: "wal",
+			Name:      "replica_written_index",
+			Help:      "Last index persisted by connected replica",
+		}, []string{"replica_id"})),
+		appliedGauge: metrics.GetOrRegister(metrics.DefaultRegistry, prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Subsystem: "wal",
+			Name:      "replica_applied_index",
+			Help:      "Last index applied by connected replica",
+		}, []string{"replica_id"})),
+	}
+}
+
+func (r *registry) connect(replicaId string, address string, filteredStreams []string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	replica := r.replica(replicaId)
+	r.evict()
+	replica.Address = address
+	replica.FilteredStreams = filteredStreams
+	replica.matcher = stream.NewMatcher(filteredStreams)
+	replica.ConnectedSince = time.Now()
+	replica.connections++
+}
+
+func (r *registry) disconnect(replicaId string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	replica := r.replica(replicaId)
+	replica.connections--
+	if replica.connections > 0 {
+		return
+	}
+	replica.pending = nil
+	r.writtenGauge.DeleteLabelValues(replicaId)
+	r.appliedGauge.DeleteLabelValues(replicaId)
+	r.evict()
+}
+
+func (r *registry) sent(replicaId string, index uint64) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	replica := r.replica(replicaId)
+	replica.SentIndex = index
+	if len(replica.pending) >= maxPendingBatches {
+		replica.pending = replica.pending[1:]
+	}
+	replica.pending = append(replica.pending, sentBatch{index: index, sentAt: time.Now()})
+}
+
+func (r *registry) ack(replicaId string, writtenIndex uint64, appliedIndex uint64) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	replica := r.replica(replicaId)
+	replica.LastAckAt = time.Now()
+	if writtenIndex > replica.WrittenIndex {
+		replica.WrittenIndex = writtenIndex
+	}
+	if appliedIndex > replica.AppliedIndex {
+		replica.AppliedIndex = appliedIndex
+	}
+	if replica.connections > 0 {
+		r.writtenGauge.WithLabelValues(replicaId).Set(float64(replica.WrittenIndex))
+		r.appliedGauge.WithLabelValues(replicaId).Set(float64(replica.AppliedIndex))
+	}
+
+	acked := 0
+	for _, batch := range replica.pending {
+		if batch.index > writtenIndex {
+			break
+		}
+		r.ackLatency.WithLabelValues(replicaId).Observe(float64(time.Since(batch.sentAt)) / float64(time.Millisecond))
+		acked++
+	}
+	replica.pending = replica.pending[acked:]
+
+	for waiter := range r.waiters {
+		if r.acked(waiter.index, waiter.streamName) >= waiter.replicas {
+			close(waiter.done)
+			delete(r.waiters, waiter)
+		}
+	}
+}
+
+func (r *registry) wait(ctx context.Context, index uint64, streamName []byte, replicas int) error {
+	r.lock.Lock()
+	if r.acked(index, streamName) >= replicas {
+		r.lock.Unlock()
+		return nil
+	}
+	waiter := &ackWaiter{
+		index:      index,
+		streamName: streamName,
+		replicas:   replicas,
+		done:       make(chan struct{}),
+	}
+	r.waiters[waiter] = struct{}{}
+	r.lock.Unlock()
+
+	select {
+	case <-waiter.done:
+		return nil
+	case <-ctx.Done():
+		r.lock.Lock()
+		delete(r.waiters, waiter)
+		r.lock.Unlock()
+		return ctx.Err()
+	}
+}
+
+func (r *registry) connected() []Replica {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	result := make([]Replica, 0, len(r.replicas))
+	for _, replica := range r.replicas {
+		if replica.connections > 0 {
+			result = append(result, replica.Replica)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Id < result[j].Id
+	})
+	return result
+}
+
+func (r *registry) acked(index uint64, streamName []byte) int {
+	count := 0
+	for _, replica := range r.replicas {
+		if replica.WrittenIndex >= index && replica.matcher.MatchStream(streamName) {
+			count++
+		}
+	}
+	return count
+}
+
+func (r *registry) replica(replicaId string) *replicaEntry {
+	replica, ok := r.replicas[replicaId]
+	if !ok {
+		replica = &replicaEntry{Replica: Replica{Id: replicaId}}
+		r.replicas[replicaId] = replica
+	}
+	replica.seenAt = time.Now()
+	return replica
+}
+
+// evict removes replicas disconnected for longer than ttl, so replicas with random ids don't accumulate
+func (r *registry) evict() {
+	for id, replica := range r.replicas {
+		if replica.connections > 0 || time.Since(replica.seenAt) < r.ttl {
+			continue
+		}
+		delete(r.replicas, id)
+		r.ackLatency.DeleteLabelValues(id)
+	}
+}
@@ -1,6 +1,6 @@
 // Code generated by protoc-gen-go. DO NOT EDIT.
 // versions:
-// 	protoc-gen-go v1.36.10
+// 	protoc-gen-go v1.36.11
 // 	protoc        v6.33.2
 // source: replication/replicator.proto
 
@@ -28,6 +28,8 @@ type BeginRequest struct {
 	LastIndex       uint64                 `protobuf:"varint,1,opt,name=lastIndex,proto3" json:"lastIndex,omitempty"`
 	FilteredStreams []string               `protobuf:"bytes,2,rep,name=filteredStreams,proto3" json:"filteredStreams,omitempty"`
 	Limit           int32                  `protobuf:"varint,3,opt,name=limit,proto3" json:"limit,omitempty"`
+	ReplicaId       string                 `protobuf:"bytes,4,opt,name=replicaId,proto3" json:"replicaId,omitempty"`
+	LastEpoch       *uint64                `protobuf:"varint,5,opt,name=lastEpoch,proto3,oneof" json:"lastEpoch,omitempty"`
 	unknownFields   protoimpl.UnknownFields
 	sizeCache       protoimpl.SizeCache
 }
@@ -83,6 +85,20 @@ func (x *BeginRequest) GetLimit() int32 {
 	return 0
 }
 
+func (x *BeginRequest) GetReplicaId() string {
+	if x != nil {
+		return x.ReplicaId
+	}
+	return ""
+}
+
+func (x *BeginRequest) GetLastEpoch() uint64 {
+	if x != nil && x.LastEpoch != nil {
+		return *x.LastEpoch
+	}
+	return 0
+}
+
 type Entry struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Data          []byte                 `protobuf:"bytes,1,opt,name=data,proto3" json:"data,omitempty"`
@@ -267,15 +283,203 @@ func (x *WriteResponse) GetIndex() uint64 {
 	return 0
 }
 
+type ForwardRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Data          []byte                 `protobuf:"bytes,1,opt,name=data,proto3" json:"data,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ForwardRequest) Reset() {
+	*x = ForwardRequest{}
+	mi := &file_replication_replicator_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ForwardRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ForwardRequest) ProtoMessage() {}
+
+func (x *ForwardRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_replication_replicator_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ForwardRequest.ProtoReflect.Descriptor instead.
+func (*ForwardRequest) Descriptor() ([]byte, []int) {
+	return file_replication_replicator_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *ForwardRequest) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+type ForwardResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Index         uint64                 `protobuf:"varint,1,opt,name=index,proto3" json:"index,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ForwardResponse) Reset() {
+	*x = ForwardResponse{}
+	mi := &file_replication_replicator_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ForwardResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ForwardResponse) ProtoMessage() {}
+
+func (x *ForwardResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_replication_replicator_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ForwardResponse.ProtoReflect.Descriptor instead.
+func (*ForwardResponse) Descriptor() ([]byte, []int) {
+	return file_replication_replicator_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *ForwardResponse) GetIndex() uint64 {
+	if x != nil {
+		return x.Index
+	}
+	return 0
+}
+
+type AckRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ReplicaId     string                 `protobuf:"bytes,1,opt,name=replicaId,proto3" json:"replicaId,omitempty"`
+	Index         uint64                 `protobuf:"varint,2,opt,name=index,proto3" json:"index,omitempty"`
+	AppliedIndex  uint64                 `protobuf:"varint,3,opt,name=appliedIndex,proto3" json:"appliedIndex,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AckRequest) Reset() {
+	*x = AckRequest{}
+	mi := &file_replication_replicator_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AckRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AckRequest) ProtoMessage() {}
+
+func (x *AckRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_replication_replicator_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AckRequest.ProtoReflect.Descriptor instead.
+func (*AckRequest) Descriptor() ([]byte, []int) {
+	return file_replication_replicator_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *AckRequest) GetReplicaId() string {
+	if x != nil {
+		return x.ReplicaId
+	}
+	return ""
+}
+
+func (x *AckRequest) GetIndex() uint64 {
+	if x != nil {
+		return x.Index
+	}
+	return 0
+}
+
+func (x *AckRequest) GetAppliedIndex() uint64 {
+	if x != nil {
+		return x.AppliedIndex
+	}
+	return 0
+}
+
+type AckResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AckResponse) Reset() {
+	*x = AckResponse{}
+	mi := &file_replication_replicator_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AckResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AckResponse) ProtoMessage() {}
+
+func (x *AckResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_replication_replicator_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AckResponse.ProtoReflect.Descriptor instead.
+func (*AckResponse) Descriptor() ([]byte, []int) {
+	return file_replication_replicator_proto_rawDescGZIP(), []int{8}
+}
+
 var File_replication_replicator_proto protoreflect.FileDescriptor
 
 const file_replication_replicator_proto_rawDesc = "" +
 	"\n" +
-	"\x1creplication/replicator.proto\x12\vreplication\"l\n" +
+	"\x1creplication/replicator.proto\x12\vreplication\"\xbb\x01\n" +
 	"\fBeginRequest\x12\x1c\n" +
 	"\tlastIndex\x18\x01 \x01(\x04R\tlastIndex\x12(\n" +
 	"\x0ffilteredStreams\x18\x02 \x03(\tR\x0ffilteredStreams\x12\x14\n" +
-	"\x05limit\x18\x03 \x01(\x05R\x05limit\"1\n" +
+	"\x05limit\x18\x03 \x01(\x05R\x05limit\x12\x1c\n" +
+	"\treplicaId\x18\x04 \x01(\tR\treplicaId\x12!\n" +
+	"\tlastEpoch\x18\x05 \x01(\x04H\x00R\tlastEpoch\x88\x01\x01B\f\n" +
+	"\n" +
+	"_lastEpoch\"1\n" +
 	"\x05Entry\x12\x12\n" +
 	"\x04data\x18\x01 \x01(\fR\x04data\x12\x14\n" +
 	"\x05index\x18\x02 \x01(\x04R\x05index\"7\n" +
@@ -284,12 +488,24 @@ const file_replication_replicator_proto_rawDesc = "" +
 	"\fWriteRequest\x12\x12\n" +
 	"\x04data\x18\x01 \x01(\fR\x04data\"%\n" +
 	"\rWriteResponse\x12\x14\n" +
-	"\x05index\x18\x01 \x01(\x04R\x05index2\x98\x01\n" +
+	"\x05index\x18\x01 \x01(\x04R\x05index\"$\n" +
+	"\x0eForwardRequest\x12\x12\n" +
+	"\x04data\x18\x01 \x01(\fR\x04data\"'\n" +
+	"\x0fForwardResponse\x12\x14\n" +
+	"\x05index\x18\x01 \x01(\x04R\x05index\"d\n" +
+	"\n" +
+	"AckRequest\x12\x1c\n" +
+	"\treplicaId\x18\x01 \x01(\tR\treplicaId\x12\x14\n" +
+	"\x05index\x18\x02 \x01(\x04R\x05index\x12\"\n" +
+	"\fappliedIndex\x18\x03 \x01(\x04R\fappliedIndex\"\r\n" +
+	"\vAckResponse2\x9a\x02\n" +
 	"\n" +
 	"Replicator\x12E\n" +
 	"\x10BeginReplication\x12\x19.replication.BeginRequest\x1a\x14.replication.Entries0\x01\x12C\n" +
 	"\n" +
-	"DebugWrite\x12\x19.replication.WriteRequest\x1a\x1a.replication.WriteResponseB\x19Z\x17/replication/replicatorb\x06proto3"
+	"DebugWrite\x12\x19.replication.WriteRequest\x1a\x1a.replication.WriteResponse\x12D\n" +
+	"\aForward\x12\x1b.replication.ForwardRequest\x1a\x1c.replication.ForwardResponse\x12:\n" +
+	"\x03Ack\x12\x17.replication.AckRequest\x1a\x18.replication.AckResponse(\x01B\x19Z\x17/replication/replicatorb\x06proto3"
 
 var (
 	file_replication_replicator_proto_rawDescOnce sync.Once
@@ -303,22 +519,30 @@ func file_replication_replicator_proto_rawDescGZIP() []byte {
 	return file_replication_replicator_proto_rawDescData
 }
 
-var file_replication_replicator_proto_msgTypes = make([]protoimpl.MessageInfo, 5)
+var file_replication_replicator_proto_msgTypes = make([]protoimpl.MessageInfo, 9)
 var file_replication_replicator_proto_goTypes = []any{
-	(*BeginRequest)(nil),  // 0: replication.BeginRequest
-	(*Entry)(nil),         // 1: replication.Entry
-	(*Entries)(nil),       // 2: replication.Entries
-	(*WriteRequest)(nil),  // 3: replication.WriteRequest
-	(*WriteResponse)(nil), // 4: replication.WriteResponse
+	(*BeginRequest)(nil),    // 0: replication.BeginRequest
+	(*Entry)(nil),           // 1: replication.Entry
+	(*Entries)(nil),         // 2: replication.Entries
+	(*WriteRequest)(nil),    // 3: replication.WriteRequest
+	(*WriteResponse)(nil),   // 4: replication.WriteResponse
+	(*ForwardRequest)(nil),  // 5: replication.ForwardRequest
+	(*ForwardResponse)(nil), // 6: replication.ForwardResponse
+	(*AckRequest)(nil),      // 7: replication.AckRequest
+	(*AckResponse)(nil),     // 8: replication.AckResponse
 }
 var file_replication_replicator_proto_depIdxs = []int32{
 	1, // 0: replication.Entries.entries:type_name -> replication.Entry
 	0, // 1: replication.Replicator.BeginReplication:input_type -> replication.BeginRequest
 	3, // 2: replication.Replicator.DebugWrite:input_type -> replication.WriteRequest
-	2, // 3: replication.Replicator.BeginReplication:output_type -> replication.Entries
-	4, // 4: replication.Replicator.DebugWrite:output_type -> replication.WriteResponse
-	3, // [3:5] is the sub-list for method output_type
-	1, // [1:3] is the sub-list for method input_type
+	5, // 3: replication.Replicator.Forward:input_type -> replication.ForwardRequest
+	7, // 4: replication.Replicator.Ack:input_type -> replication.AckRequest
+	2, // 5: replication.Replicator.BeginReplication:output_type -> replication.Entries
+	4, // 6: replication.Replicator.DebugWrite:output_type -> replication.WriteResponse
+	6, // 7: replication.Replicator.Forward:output_type -> replication.ForwardResponse
+	8, // 8: replication.Replicator.Ack:output_type -> replication.AckResponse
+	5, // [5:9] is the sub-list for method output_type
+	1, // [1:5] is the sub-list for method input_type
 	1, // [1:1] is the sub-list for extension type_name
 	1, // [1:1] is the sub-list for extension extendee
 	0, // [0:1] is the sub-list for field type_name
@@ -329,13 +553,14 @@ func file_replication_replicator_proto_init() {
 	if File_replication_replicator_proto != nil {
 		return
 	}
+	file_replication_replicator_proto_msgTypes[0].OneofWrappers = []any{}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_replication_replicator_proto_rawDesc), len(file_replication_replicator_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   5,
+			NumMessages:   9,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
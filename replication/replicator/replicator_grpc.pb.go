@@ -1,6 +1,6 @@
 // Code generated by protoc-gen-go-grpc. DO NOT EDIT.
 // versions:
-// - protoc-gen-go-grpc v1.5.1
+// - protoc-gen-go-grpc v1.6.2
 // - protoc             v6.33.2
 // source: replication/replicator.proto
 
@@ -23,6 +23,8 @@ const _ = grpc.SupportPackageIsVersion9
 const (
 	Replicator_BeginReplication_FullMethodName = "/replication.Replicator/BeginReplication"
 	Replicator_DebugWrite_FullMethodName       = "/replication.Replicator/DebugWrite"
+	Replicator_Forward_FullMethodName          = "/replication.Replicator/Forward"
+	Replicator_Ack_FullMethodName              = "/replication.Replicator/Ack"
 )
 
 // ReplicatorClient is the client API for Replicator service.
@@ -31,6 +33,8 @@ const (
 type ReplicatorClient interface {
 	BeginReplication(ctx context.Context, in *BeginRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Entries], error)
 	DebugWrite(ctx context.Context, in *WriteRequest, opts ...grpc.CallOption) (*WriteResponse, error)
+	Forward(ctx context.Context, in *ForwardRequest, opts ...grpc.CallOption) (*ForwardResponse, error)
+	Ack(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[AckRequest, AckResponse], error)
 }
 
 type replicatorClient struct {
@@ -70,12 +74,37 @@ func (c *replicatorClient) DebugWrite(ctx context.Context, in *WriteRequest, opt
 	return out, nil
 }
 
+func (c *replicatorClient) Forward(ctx context.Context, in *ForwardRequest, opts ...grpc.CallOption) (*ForwardResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ForwardResponse)
+	err := c.cc.Invoke(ctx, Replicator_Forward_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *replicatorClient) Ack(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[AckRequest, AckResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Replicator_ServiceDesc.Streams[1], Replicator_Ack_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[AckRequest, AckResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Replicator_AckClient = grpc.ClientStreamingClient[AckRequest, AckResponse]
+
 // ReplicatorServer is the server API for Replicator service.
 // All implementations must embed UnimplementedReplicatorServer
 // for forward compatibility.
 type ReplicatorServer interface {
 	BeginReplication(*BeginRequest, grpc.ServerStreamingServer[Entries]) error
 	DebugWrite(context.Context, *WriteRequest) (*WriteResponse, error)
+	Forward(context.Context, *ForwardRequest) (*ForwardResponse, error)
+	Ack(grpc.ClientStreamingServer[AckRequest, AckResponse]) error
 	mustEmbedUnimplementedReplicatorServer()
 }
 
@@ -87,10 +116,16 @@ type ReplicatorServer interface {
 type UnimplementedReplicatorServer struct{}
 
 func (UnimplementedReplicatorServer) BeginReplication(*BeginRequest, grpc.ServerStreamingServer[Entries]) error {
-	return status.Errorf(codes.Unimplemented, "method BeginReplication not implemented")
+	return status.Error(codes.Unimplemented, "method BeginReplication not implemented")
 }
 func (UnimplementedReplicatorServer) DebugWrite(context.Context, *WriteRequest) (*WriteResponse, error) {
-	return nil, status.Errorf(codes.Unimplemented, "method DebugWrite not implemented")
+	return nil, status.Error(codes.Unimplemented, "method DebugWrite not implemented")
+}
+func (UnimplementedReplicatorServer) Forward(context.Context, *ForwardRequest) (*ForwardResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method Forward not implemented")
+}
+func (UnimplementedReplicatorServer) Ack(grpc.ClientStreamingServer[AckRequest, AckResponse]) error {
+	return status.Error(codes.Unimplemented, "method Ack not implemented")
 }
 func (UnimplementedReplicatorServer) mustEmbedUnimplementedReplicatorServer() {}
 func (UnimplementedReplicatorServer) testEmbeddedByValue()                    {}
@@ -103,7 +138,7 @@ type UnsafeReplicatorServer interface {
 }
 
 func RegisterReplicatorServer(s grpc.ServiceRegistrar, srv ReplicatorServer) {
-	// If the following call pancis, it indicates UnimplementedReplicatorServer was
+	// If the following call panics, it indicates UnimplementedReplicatorServer was
 	// embedded by pointer and is nil.  This will cause panics if an
 	// unimplemented method is ever invoked, so we test this at initialization
 	// time to prevent it from happening at runtime later due to I/O.
@@ -142,6 +177,31 @@ func _Replicator_DebugWrite_Handler(srv interface{}, ctx context.Context, dec fu
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Replicator_Forward_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ForwardRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ReplicatorServer).Forward(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Replicator_Forward_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ReplicatorServer).Forward(ctx, req.(*ForwardRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Replicator_Ack_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(ReplicatorServer).Ack(&grpc.GenericServerStream[AckRequest, AckResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Replicator_AckServer = grpc.ClientStreamingServer[AckRequest, AckResponse]
+
 // Replicator_ServiceDesc is the grpc.ServiceDesc for Replicator service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -153,6 +213,10 @@ var Replicator_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "DebugWrite",
 			Handler:    _Replicator_DebugWrite_Handler,
 		},
+		{
+			MethodName: "Forward",
+			Handler:    _Replicator_Forward_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -160,6 +224,11 @@ var Replicator_ServiceDesc = grpc.ServiceDesc{
 			Handler:       _Replicator_BeginReplication_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "Ack",
+			Handler:       _Replicator_Ack_Handler,
+			ClientStreams: true,
+		},
 	},
 	Metadata: "replication/replicator.proto",
 }
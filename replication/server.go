@@ -10,9 +10,12 @@ import (
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/txix-open/isp-kit/metrics"
+	"github.com/txix-open/walx/v2/state"
 	"github.com/txix-open/walx/v2/stream"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
 
 	"github.com/pkg/errors"
 	"github.com/txix-open/isp-kit/log"
@@ -32,6 +35,7 @@ type Server struct {
 	cancelFuncs   map[string]context.CancelFunc
 	mu            sync.Mutex
 	indexLagGauge *prometheus.GaugeVec
+	replicas      *registry
 }
 
 func NewServer(wal *walx.Log, log log.Logger, opts ...ServerOption) *Server {
@@ -60,6 +64,7 @@ func NewServer(wal *walx.Log, log log.Logger, opts ...ServerOption) *Server {
 			Help:        "Index lag from current master position",
 			ConstLabels: nil,
 		}, []string{"client_ip"})),
+		replicas: newRegistry(options.replicaTtl),
 	}
 	replicator.RegisterReplicatorServer(srv, s)
 	return s
@@ -84,6 +89,7 @@ func (s *Server) BeginReplication(request *replicator.BeginRequest, server repli
 		"new replication client connected",
 		log.Any("lastIndex", request.LastIndex),
 		log.Any("filteredStreams", request.FilteredStreams),
+		log.String("replicaId", request.ReplicaId),
 	)
 	matcher := stream.NewMatcher(request.GetFilteredStreams())
 
@@ -110,10 +116,19 @@ func (s *Server) BeginReplication(request *replicator.BeginRequest, server repli
 		err = errors.Errorf("replication is not available. possibly lag is too big, max lag = 4GB.\n cause: %v %s\n", err, stack[:length])
 	}()
 
+	err = s.checkEpoch(request)
+	if err != nil {
+		return err
+	}
+
 	reader := s.wal.OpenReader(request.LastIndex)
 	defer reader.Close()
 
 	clientIp := s.getClientIp(ctx)
+	if request.ReplicaId != "" {
+		s.replicas.connect(request.ReplicaId, s.getClientAddress(ctx), request.FilteredStreams)
+		defer s.replicas.disconnect(request.ReplicaId)
+	}
 	gauge := s.indexLagGauge.WithLabelValues(clientIp)
 	toSend := make([]*replicator.Entry, 0)
 	var emptyData []byte
@@ -151,9 +166,68 @@ func (s *Server) BeginReplication(request *replicator.BeginRequest, server repli
 		if err != nil {
 			return errors.WithMessage(err, "send log entry")
 		}
+		if request.ReplicaId != "" {
+			s.replicas.sent(request.ReplicaId, entries.LastIndex())
+		}
+	}
+}
+
+// checkEpoch refuses the client whose last entry is missing in the WAL or was written in another epoch.
+// Entries preceding the first known epoch on either side or not applied on the server yet aren't checked
+func (s *Server) checkEpoch(request *replicator.BeginRequest) error {
+	if s.options.epochs == nil || request.LastEpoch == nil {
+		return nil
+	}
+
+	lastIndex := s.wal.LastIndex()
+	if request.LastIndex > lastIndex {
+		return status.Errorf(
+			codes.FailedPrecondition,
+			"client has entries up to %d, server has entries up to %d",
+			request.LastIndex, lastIndex,
+		)
+	}
+	epoch, ok := s.options.epochs.EpochAt(request.LastIndex)
+	if !ok || epoch == 0 || request.GetLastEpoch() == 0 {
+		return nil
+	}
+	if epoch != request.GetLastEpoch() {
+		return status.Errorf(
+			codes.FailedPrecondition,
+			"entry %d is written in epoch %d on client, in epoch %d on server",
+			request.LastIndex, request.GetLastEpoch(), epoch,
+		)
+	}
+	return nil
+}
+
+func (s *Server) Ack(server replicator.Replicator_AckServer) error {
+	for {
+		request, err := server.Recv()
+		if errors.Is(err, io.EOF) {
+			return server.SendAndClose(&replicator.AckResponse{})
+		}
+		if err != nil {
+			return errors.WithMessage(err, "receive ack")
+		}
+		s.replicas.ack(request.ReplicaId, request.Index, request.AppliedIndex)
 	}
 }
 
+// WaitReplicated blocks until at least replicas followers storing the stream have acknowledged
+// that entries up to index are persisted, or ctx is done.
+func (s *Server) WaitReplicated(ctx context.Context, index uint64, streamName []byte, replicas int) error {
+	if replicas <= 0 {
+		return nil
+	}
+	return s.replicas.wait(ctx, index, streamName, replicas)
+}
+
+// Replicas returns connected replicas which identify themselves, sorted by id
+func (s *Server) Replicas() []Replica {
+	return s.replicas.connected()
+}
+
 func (s *Server) DebugWrite(ctx context.Context, request *replicator.WriteRequest) (*replicator.WriteResponse, error) {
 	index, err := s.wal.Write(request.Data, func(index uint64) {
 
@@ -164,6 +238,26 @@ func (s *Server) DebugWrite(ctx context.Context, request *replicator.WriteReques
 	return &replicator.WriteResponse{Index: index}, nil
 }
 
+func (s *Server) Forward(ctx context.Context, request *replicator.ForwardRequest) (*replicator.ForwardResponse, error) {
+	if s.options.forwardWriter == nil {
+		return nil, status.Error(codes.Unimplemented, "write forwarding is not enabled")
+	}
+
+	index, err := s.options.forwardWriter.Write(request.Data, func(index uint64) {
+
+	})
+	if errors.Is(err, state.ErrReadOnly) {
+		return nil, status.Error(codes.FailedPrecondition, "state is read only, node is not a leader")
+	}
+	if errors.Is(err, state.ErrNotReplicated) {
+		return nil, status.Error(codes.Aborted, err.Error())
+	}
+	if err != nil {
+		return nil, errors.WithMessage(err, "write forwarded entry")
+	}
+	return &replicator.ForwardResponse{Index: index}, nil
+}
+
 func (s *Server) ListenAndServe(addr string) error {
 	lis, err := net.Listen("tcp", addr)
 	if err != nil {
@@ -210,3 +304,11 @@ func (s *Server) getClientIp(ctx context.Context) string {
 	}
 	return host
 }
+
+func (s *Server) getClientAddress(ctx context.Context) string {
+	peer, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	return peer.Addr.String()
+}
@@ -2,20 +2,31 @@ package replication
 
 import (
 	"crypto/tls"
+	"time"
 
 	"google.golang.org/grpc"
 )
 
 type ServerOption func(opts *serverOptions)
 
+type Writer interface {
+	Write(data []byte, nextIndex func(index uint64)) (uint64, error)
+}
+
 type serverOptions struct {
 	tls               *tls.Config
 	minIndexLagToLog  int64
 	grpcServerOptions []grpc.ServerOption
+	forwardWriter     Writer
+	replicaTtl        time.Duration
+	epochs            Epochs
 }
 
 func newServerOptions() *serverOptions {
-	return &serverOptions{minIndexLagToLog: 100_000}
+	return &serverOptions{
+		minIndexLagToLog: 100_000,
+		replicaTtl:       10 * time.Minute,
+	}
 }
 
 func ServerTls(cfg *tls.Config) ServerOption {
@@ -37,3 +48,27 @@ func GrpcServerOptions(opts ...grpc.ServerOption) ServerOption {
 		o.grpcServerOptions = append(o.grpcServerOptions, opts...)
 	}
 }
+
+func ForwardWritesTo(writer Writer) ServerOption {
+	return func(o *serverOptions) {
+		o.forwardWriter = writer
+	}
+}
+
+// ReplicaTtl sets how long disconnected replicas are kept with their positions and metrics.
+// Evicted replicas don't count towards WaitReplicated until they reconnect
+func ReplicaTtl(ttl time.Duration) ServerOption {
+	return func(o *serverOptions) {
+		if ttl > 0 {
+			o.replicaTtl = ttl
+		}
+	}
+}
+
+// ServerEpochs makes the server refuse clients which report the epoch of their last entry
+// different from the epoch of the entry in the server WAL or have entries the server doesn't have
+func ServerEpochs(epochs Epochs) ServerOption {
+	return func(o *serverOptions) {
+		o.epochs = epochs
+	}
+}
@@ -8,14 +8,22 @@ import (
 )
 
 type Codec struct {
-	api jsoniter.API
+	api      jsoniter.API
+	lossless jsoniter.API
 }
 
 func NewCodec() Codec {
+	return Codec{
+		api:      newApi(true),
+		lossless: newApi(false),
+	}
+}
+
+func newApi(marshalFloatWith6Digits bool) jsoniter.API {
 	api := jsoniter.Config{
 		EscapeHTML:                    false,
-		MarshalFloatWith6Digits:       true, // will lose precession
-		ObjectFieldMustBeSimpleString: true, // do not unescape object field
+		MarshalFloatWith6Digits:       marshalFloatWith6Digits, // will lose precession
+		ObjectFieldMustBeSimpleString: true,                    // do not unescape object field
 	}.Froze()
 	timeType := reflect2.TypeByName("time.Time")
 	tc := NewTimeCodec(FullDateFormat)
@@ -26,9 +34,7 @@ func NewCodec() Codec {
 
 	naming := &namingStrategyExtension{jsoniter.DummyExtension{}, lowerCaseFirstChar}
 	api.RegisterExtension(naming)
-	return Codec{
-		api: api,
-	}
+	return api
 }
 
 func (j Codec) Encode(w io.Writer, event any) error {
@@ -40,6 +46,15 @@ func (j Codec) Encode(w io.Writer, event any) error {
 	return stream.Error
 }
 
+// EncodeLossless is the same as Encode, but floats are written with full precision
+func (j Codec) EncodeLossless(w io.Writer, value any) error {
+	stream := j.lossless.BorrowStream(w)
+	defer j.lossless.ReturnStream(stream)
+	stream.WriteVal(value)
+	stream.Flush()
+	return stream.Error
+}
+
 func (j Codec) Decode(data []byte, eventPtr any) error {
 	return j.api.Unmarshal(data, eventPtr)
 }
@@ -16,6 +16,8 @@ var (
 	Separator = []byte("/")
 )
 
+type RawEvent []byte
+
 type Codec interface {
 	Encode(w io.Writer, event any) error
 	Decode(data []byte, eventPtr any) error
@@ -31,6 +33,15 @@ func MarshalEvent(codec Codec, event any) ([]byte, error) {
 }
 
 func EncodeEvent(codec Codec, w io.Writer, event any) error {
+	raw, ok := event.(RawEvent)
+	if ok {
+		_, err := w.Write(raw)
+		if err != nil {
+			return fmt.Errorf("write raw event: %w", err)
+		}
+		return nil
+	}
+
 	err := codec.Encode(w, event)
 	if err != nil {
 		return fmt.Errorf("json marshal: %w", err)
@@ -43,10 +54,13 @@ func UnmarshalEvent[T any](log Log) (T, error) {
 
 	if log.event != nil {
 		event, ok := log.event.(T)
-		if !ok {
-			return empty, fmt.Errorf("unexpected event type. expected %T, got %T", empty, event)
+		switch {
+		case ok:
+			return event, nil
+		case log.serializedEvent != nil:
+			return empty, fmt.Errorf("unexpected event type. expected %T, got %T", empty, log.event)
 		}
-		return event, nil
+		// the derived log has no serialized form, the event is converted through the codec
 	}
 
 	var t T
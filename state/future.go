@@ -1,13 +1,25 @@
 package state
 
+import (
+	"time"
+)
+
 type result struct {
 	response any
 	err      error
 }
 
+// orphan is the result of the forwarded entry applied before the forwarder registered its future
+type orphan struct {
+	index     uint64
+	result    result
+	appliedAt time.Time
+}
+
 type future struct {
-	event any
-	ch    chan result
+	event     any
+	forwarded bool
+	ch        chan result
 }
 
 func newFuture(event any) *future {
@@ -3,6 +3,7 @@ package state
 type Log struct {
 	streamName      []byte
 	serializedEvent []byte
+	index           uint64
 	isInRecovery    bool
 	codec           Codec
 	event           any
@@ -24,14 +25,43 @@ func (l Log) StreamName() []byte {
 	return l.streamName
 }
 
+// Index returns the WAL index of the entry, it is zero for events read outside of the state
+func (l Log) Index() uint64 {
+	return l.index
+}
+
 func (l Log) IsInRecovery() bool {
 	return l.isInRecovery
 }
 
 func (l Log) Unmarshal(eventPtr any) error {
-	return l.codec.Decode(l.serializedEvent, eventPtr)
+	data := l.serializedEvent
+	if data == nil && l.event != nil {
+		encoded, err := MarshalEvent(l.codec, l.event)
+		if err != nil {
+			return err
+		}
+		data = encoded
+	}
+	return l.codec.Decode(data, eventPtr)
+}
+
+func (l Log) Codec() Codec {
+	return l.codec
 }
 
 func (l Log) SerializedEvent() []byte {
 	return l.serializedEvent
 }
+
+// Derive returns the log of the event applied in the same entry, e.g. a command dispatched by saga.
+// The event isn't serialized, Unmarshal encodes it with the codec on demand
+func (l Log) Derive(streamName []byte, event any) Log {
+	return Log{
+		streamName:   streamName,
+		index:        l.index,
+		isInRecovery: l.isInRecovery,
+		codec:        l.codec,
+		event:        event,
+	}
+}
@@ -0,0 +1,107 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrNotReplicated      = errors.New("event is not replicated")
+	ErrInvalidReplication = errors.New("invalid replication")
+)
+
+type ReplicationMode int
+
+const (
+	// AsyncReplication returns as soon as the event is applied locally
+	AsyncReplication ReplicationMode = iota
+	// SemiSyncReplication waits for follower acks until timeout, then falls back to async
+	SemiSyncReplication
+	// SyncReplication waits for follower acks and fails with ErrNotReplicated on timeout
+	SyncReplication
+)
+
+// ReplicationWaiter waits for followers which persisted the entry of the stream
+type ReplicationWaiter interface {
+	WaitReplicated(ctx context.Context, index uint64, streamName []byte, replicas int) error
+}
+
+// Replication describes how many followers must persist an event before Apply returns.
+// Zero Timeout means waiting without a deadline, it is not allowed for SyncReplication
+type Replication struct {
+	Mode     ReplicationMode
+	Waiter   ReplicationWaiter
+	Replicas int
+	Timeout  time.Duration
+}
+
+// Validate rejects SyncReplication without followers to wait for or without timeout,
+// such writes would never fail or would block forever
+func (r Replication) Validate() error {
+	if r.Mode != SyncReplication {
+		return nil
+	}
+	switch {
+	case r.Waiter == nil:
+		return fmt.Errorf("%w: waiter is required for sync replication", ErrInvalidReplication)
+	case r.Replicas <= 0:
+		return fmt.Errorf("%w: replicas must be positive for sync replication", ErrInvalidReplication)
+	case r.Timeout <= 0:
+		return fmt.Errorf("%w: timeout must be positive for sync replication", ErrInvalidReplication)
+	default:
+		return nil
+	}
+}
+
+// SetReplication sets the replication mode used by Apply
+func (s *State) SetReplication(replication Replication) error {
+	err := replication.Validate()
+	if err != nil {
+		return err
+	}
+	s.replication.Store(&replication)
+	return nil
+}
+
+// WithReplication returns a mutator that applies events with the given replication mode
+// instead of the one set by SetReplication
+func (s *State) WithReplication(replication Replication) (Mutator, error) {
+	err := replication.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return replicatedMutator{
+		state:       s,
+		replication: replication,
+	}, nil
+}
+
+type replicatedMutator struct {
+	state       *State
+	replication Replication
+}
+
+func (m replicatedMutator) Apply(event any, streamSuffix []byte) (any, error) {
+	return m.state.apply(event, streamSuffix, m.replication)
+}
+
+func (r Replication) wait(index uint64, streamName []byte) error {
+	if r.Mode == AsyncReplication || r.Waiter == nil || r.Replicas <= 0 {
+		return nil
+	}
+
+	ctx := context.Background()
+	if r.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.Timeout)
+		defer cancel()
+	}
+
+	err := r.Waiter.WaitReplicated(ctx, index, streamName, r.Replicas)
+	if err == nil || r.Mode == SemiSyncReplication {
+		return nil
+	}
+	return fmt.Errorf("%w: index %d: %w", ErrNotReplicated, index, err)
+}
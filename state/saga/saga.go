@@ -0,0 +1,335 @@
+package saga
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/txix-open/isp-kit/log"
+	"github.com/txix-open/walx/v2/state"
+	"github.com/txix-open/walx/v2/state/sub"
+	"github.com/txix-open/walx/v2/unsafe"
+)
+
+const (
+	retryTimeout = 1 * time.Second
+)
+
+var (
+	ErrAlreadyDispatched = errors.New("command already dispatched")
+)
+
+type Command struct {
+	Target  string
+	Event   string
+	Payload any
+}
+
+type Reaction[S any, E any] func(saga *S, event E, result any) (commands []Command, completed bool)
+
+type reaction func(log state.Log, request any, result any) error
+
+type pendingCommand struct {
+	id      string
+	sagaId  string
+	command Command
+}
+
+type dispatchRequest struct {
+	CommandId string
+}
+
+type dispatchResult struct {
+	Response any
+	Err      error
+}
+
+type events struct {
+	Dispatch *dispatchRequest `json:",omitempty"`
+}
+
+type Saga[S any] struct {
+	name      string
+	mutator   state.Mutator
+	targets   map[string]state.FSM
+	reactions map[string]reaction
+	logger    log.Logger
+
+	sagas    map[string]*S
+	seqs     map[string]uint64
+	outbox   []pendingCommand
+	isLeader func() bool
+	lock     sync.Locker
+	notify   chan struct{}
+}
+
+func New[S any](name string, logger log.Logger, targets ...state.NamedState) *Saga[S] {
+	targetByName := make(map[string]state.FSM)
+	for _, target := range targets {
+		targetByName[target.StateName()] = target
+	}
+	return &Saga[S]{
+		name:      name,
+		targets:   targetByName,
+		reactions: make(map[string]reaction),
+		logger:    logger,
+		sagas:     make(map[string]*S),
+		seqs:      make(map[string]uint64),
+		lock:      &sync.Mutex{},
+		notify:    make(chan struct{}, 1),
+	}
+}
+
+func React[S any, E any](
+	s *Saga[S],
+	source string,
+	eventName string,
+	correlate func(event E) string,
+	r Reaction[S, E],
+) {
+	s.reactions[reactionKey(source, eventName)] = func(log state.Log, request any, result any) error {
+		event, ok := request.(E)
+		if !ok {
+			var empty E
+			return errors.Errorf("unexpected event type. expected %T, got %T", empty, request)
+		}
+
+		s.lock.Lock()
+		defer s.lock.Unlock()
+
+		id := correlate(event)
+		saga, ok := s.sagas[id]
+		if !ok {
+			saga = new(S)
+			s.sagas[id] = saga
+		}
+
+		commands, completed := r(saga, event, result)
+		for _, command := range commands {
+			s.seqs[id]++
+			s.outbox = append(s.outbox, pendingCommand{
+				id:      fmt.Sprintf("%s/%d", id, s.seqs[id]),
+				sagaId:  id,
+				command: command,
+			})
+		}
+		if completed {
+			delete(s.sagas, id)
+			s.forgetSeq(id)
+		}
+
+		if len(commands) > 0 && !log.IsInRecovery() {
+			s.wakeUp()
+		}
+		return nil
+	}
+}
+
+func (s *Saga[S]) Observe(source string) sub.Hook {
+	return func(log state.Log, request any, result any, err error) {
+		if err != nil {
+			return
+		}
+		_, eventName, found := bytes.Cut(log.StreamName(), state.Separator)
+		if !found {
+			return
+		}
+		r, ok := s.reactions[reactionKey(source, unsafe.BytesToString(eventName))]
+		if !ok {
+			return
+		}
+
+		err = r(log, request, result)
+		if err != nil && !log.IsInRecovery() {
+			s.logger.Error(context.Background(), errors.WithMessagef(err, "saga %s: react", s.name))
+		}
+	}
+}
+
+func (s *Saga[S]) Get(id string) (S, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	saga, ok := s.sagas[id]
+	if !ok {
+		var empty S
+		return empty, false
+	}
+	return *saga, true
+}
+
+func (s *Saga[S]) Pending() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return len(s.outbox)
+}
+
+// SetLeadership sets the check that the node is the leader, e.g. keeper role.
+// By default, commands are dispatched while the mutator is not read only
+func (s *Saga[S]) SetLeadership(isLeader func() bool) {
+	s.isLeader = isLeader
+}
+
+// Run dispatches pending commands while the node is the leader, see SetLeadership
+func (s *Saga[S]) Run(ctx context.Context) error {
+	ctx = log.ToContext(ctx, log.String("saga", s.name))
+	isLeader := s.isLeader
+	if isLeader == nil {
+		isLeader = mutatorIsWritable(s.mutator)
+	}
+	for {
+		if !isLeader() {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-s.notify:
+			case <-time.After(retryTimeout):
+			}
+			continue
+		}
+
+		err := s.dispatchPending(ctx)
+		if err != nil {
+			s.logger.Error(ctx, errors.WithMessage(err, "dispatch saga commands"))
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(retryTimeout):
+			}
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-s.notify:
+		}
+	}
+}
+
+func (s *Saga[S]) StateName() string {
+	return s.name
+}
+
+func (s *Saga[S]) SetMutator(mutator state.Mutator) {
+	s.mutator = mutator
+}
+
+func (s *Saga[S]) Apply(log state.Log) (any, error) {
+	e, err := state.UnmarshalEvent[events](log)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case e.Dispatch != nil:
+		return s.dispatch(log, *e.Dispatch)
+	default:
+		return nil, errors.New("handler not found")
+	}
+}
+
+func (s *Saga[S]) dispatchPending(ctx context.Context) error {
+	for _, id := range s.pendingIds() {
+		result, err := state.Apply[dispatchResult](s.mutator, events{
+			Dispatch: &dispatchRequest{CommandId: id},
+		})
+		if errors.Is(err, ErrAlreadyDispatched) {
+			continue
+		}
+		if err != nil {
+			return errors.WithMessagef(err, "dispatch command %s", id)
+		}
+		if result.Err != nil {
+			s.logger.Warn(ctx, "saga command was rejected", log.String("commandId", id), log.Any("error", result.Err))
+		}
+	}
+	return nil
+}
+
+func (s *Saga[S]) dispatch(log state.Log, req dispatchRequest) (any, error) {
+	s.lock.Lock()
+	var (
+		pending pendingCommand
+		found   bool
+	)
+	for i, cmd := range s.outbox {
+		if cmd.id == req.CommandId {
+			pending = cmd
+			found = true
+			s.outbox = append(s.outbox[:i], s.outbox[i+1:]...)
+			s.forgetSeq(cmd.sagaId)
+			break
+		}
+	}
+	s.lock.Unlock()
+
+	if !found {
+		return nil, ErrAlreadyDispatched
+	}
+
+	target, ok := s.targets[pending.command.Target]
+	if !ok {
+		return dispatchResult{Err: errors.Errorf("target state '%s' not found", pending.command.Target)}, nil
+	}
+
+	streamName := []byte(s.name)
+	if pending.command.Event != "" {
+		streamName = bytes.Join([][]byte{streamName, []byte(pending.command.Event)}, state.Separator)
+	}
+	response, err := target.Apply(log.Derive(streamName, pending.command.Payload))
+	return dispatchResult{Response: response, Err: err}, nil
+}
+
+func (s *Saga[S]) pendingIds() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	ids := make([]string, 0, len(s.outbox))
+	for _, cmd := range s.outbox {
+		ids = append(ids, cmd.id)
+	}
+	return ids
+}
+
+// forgetSeq drops the command sequence of the completed saga once all its commands are dispatched,
+// the sequence only has to keep ids of pending commands unique
+func (s *Saga[S]) forgetSeq(id string) {
+	_, running := s.sagas[id]
+	if running {
+		return
+	}
+	for _, cmd := range s.outbox {
+		if cmd.sagaId == id {
+			return
+		}
+	}
+	delete(s.seqs, id)
+}
+
+func mutatorIsWritable(mutator state.Mutator) func() bool {
+	readOnly, ok := mutator.(interface{ IsReadOnly() bool })
+	if !ok {
+		return func() bool {
+			return true
+		}
+	}
+	return func() bool {
+		return !readOnly.IsReadOnly()
+	}
+}
+
+func (s *Saga[S]) wakeUp() {
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+func reactionKey(source string, eventName string) string {
+	return source + "/" + eventName
+}
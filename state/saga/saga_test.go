@@ -0,0 +1,260 @@
+package saga_test
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/txix-open/isp-kit/log"
+	"github.com/txix-open/walx/v2"
+	"github.com/txix-open/walx/v2/state"
+	"github.com/txix-open/walx/v2/state/codec/json"
+	"github.com/txix-open/walx/v2/state/saga"
+	"github.com/txix-open/walx/v2/state/sub"
+)
+
+type CreateOrder struct {
+	OrderId string
+	Amount  int
+}
+
+type Charge struct {
+	OrderId string
+	Amount  int
+}
+
+type orders struct {
+	sub.Router
+}
+
+func newOrders() *orders {
+	s := &orders{}
+	sub.On(s, "create", func(req CreateOrder) (any, error) {
+		return req.OrderId, nil
+	})
+	return s
+}
+
+func (s *orders) Create(orderId string, amount int) error {
+	_, err := sub.Emit[string](s, "create", CreateOrder{OrderId: orderId, Amount: amount})
+	return err
+}
+
+func (s *orders) StateName() string {
+	return "orders"
+}
+
+type billing struct {
+	sub.Router
+
+	lock    sync.Locker
+	charged map[string]int
+}
+
+func newBilling() *billing {
+	s := &billing{
+		lock:    &sync.Mutex{},
+		charged: make(map[string]int),
+	}
+	sub.On(s, "charge", func(req Charge) (any, error) {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+
+		s.charged[req.OrderId] += req.Amount
+		return s.charged[req.OrderId], nil
+	})
+	return s
+}
+
+func (s *billing) Charged(orderId string) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.charged[orderId]
+}
+
+func (s *billing) StateName() string {
+	return "billing"
+}
+
+type orderSaga struct {
+	Amount  int
+	Charged bool
+}
+
+type app struct {
+	orders  *orders
+	billing *billing
+	saga    *saga.Saga[orderSaga]
+	state   *state.State
+}
+
+func newApp(t *testing.T, dir string, logger log.Logger) *app {
+	t.Helper()
+	require := require.New(t)
+
+	orders := newOrders()
+	billing := newBilling()
+	s := saga.New[orderSaga]("orderSaga", logger, billing)
+	saga.React(s, "orders", "create", func(event CreateOrder) string {
+		return event.OrderId
+	}, func(s *orderSaga, event CreateOrder, result any) ([]saga.Command, bool) {
+		s.Amount = event.Amount
+		return []saga.Command{{
+			Target:  "billing",
+			Event:   "charge",
+			Payload: Charge{OrderId: event.OrderId, Amount: event.Amount},
+		}}, false
+	})
+	saga.React(s, "billing", "charge", func(event Charge) string {
+		return event.OrderId
+	}, func(s *orderSaga, event Charge, result any) ([]saga.Command, bool) {
+		s.Charged = true
+		return nil, true
+	})
+	orders.SetHook(s.Observe("orders"))
+	billing.SetHook(s.Observe("billing"))
+
+	wal, err := walx.Open(dir)
+	require.NoError(err)
+	composed := state.ComposeV2(orders, billing, s)
+	ss := state.New(wal, composed, json.NewCodec(), "test")
+	composed.SetMutator(ss)
+	err = ss.Recovery(context.Background())
+	require.NoError(err)
+
+	return &app{
+		orders:  orders,
+		billing: billing,
+		saga:    s,
+		state:   ss,
+	}
+}
+
+func (a *app) run(t *testing.T) {
+	go func() {
+		err := a.state.Run(context.Background())
+		require.NoError(t, err)
+	}()
+	time.Sleep(100 * time.Millisecond) // state must be running before saga dispatches commands
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		err := a.saga.Run(ctx)
+		require.NoError(t, err)
+	}()
+	t.Cleanup(cancel)
+}
+
+func TestSaga(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	logger, err := log.New()
+	require.NoError(err)
+	dir := dir()
+	t.Cleanup(func() {
+		_ = os.RemoveAll(dir)
+	})
+
+	app := newApp(t, dir, logger)
+	app.run(t)
+
+	err = app.orders.Create("order1", 100)
+	require.NoError(err)
+	require.Eventually(func() bool {
+		return app.billing.Charged("order1") == 100
+	}, 2*time.Second, 10*time.Millisecond)
+	require.Eventually(func() bool {
+		_, ok := app.saga.Get("order1")
+		return !ok && app.saga.Pending() == 0
+	}, 2*time.Second, 10*time.Millisecond)
+	lastIndex := app.state.LastIndex()
+	err = app.state.Close()
+	require.NoError(err)
+
+	restored := newApp(t, dir, logger)
+	require.EqualValues(100, restored.billing.Charged("order1"))
+	require.EqualValues(0, restored.saga.Pending())
+	restored.run(t)
+	time.Sleep(100 * time.Millisecond)
+	require.EqualValues(lastIndex, restored.state.LastIndex())
+	require.EqualValues(100, restored.billing.Charged("order1"))
+	err = restored.state.Close()
+	require.NoError(err)
+}
+
+func TestSaga_PendingCommandsAfterRecovery(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	logger, err := log.New()
+	require.NoError(err)
+	dir := dir()
+	t.Cleanup(func() {
+		_ = os.RemoveAll(dir)
+	})
+
+	app := newApp(t, dir, logger)
+	go func() {
+		err := app.state.Run(context.Background())
+		require.NoError(err)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	err = app.orders.Create("order2", 50)
+	require.NoError(err)
+	require.EqualValues(1, app.saga.Pending())
+	err = app.state.Close()
+	require.NoError(err)
+
+	restored := newApp(t, dir, logger)
+	require.EqualValues(1, restored.saga.Pending())
+	require.EqualValues(0, restored.billing.Charged("order2"))
+	restored.run(t)
+	require.Eventually(func() bool {
+		return restored.billing.Charged("order2") == 50 && restored.saga.Pending() == 0
+	}, 2*time.Second, 10*time.Millisecond)
+	err = restored.state.Close()
+	require.NoError(err)
+}
+
+func TestSaga_Follower(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	logger, err := log.New()
+	require.NoError(err)
+	dir := dir()
+	t.Cleanup(func() {
+		_ = os.RemoveAll(dir)
+	})
+
+	app := newApp(t, dir, logger)
+	leader := &atomic.Bool{}
+	app.saga.SetLeadership(leader.Load)
+	app.run(t)
+	err = app.orders.Create("order3", 30)
+	require.NoError(err)
+	require.Never(func() bool {
+		return app.saga.Pending() != 1 || app.billing.Charged("order3") != 0
+	}, 300*time.Millisecond, 10*time.Millisecond)
+
+	leader.Store(true)
+	require.Eventually(func() bool {
+		return app.billing.Charged("order3") == 30 && app.saga.Pending() == 0
+	}, 3*time.Second, 10*time.Millisecond)
+	err = app.state.Close()
+	require.NoError(err)
+}
+
+func dir() string {
+	d := make([]byte, 8)
+	_, _ = rand.Read(d)
+	return hex.EncodeToString(d)
+}
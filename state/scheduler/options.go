@@ -0,0 +1,60 @@
+package scheduler
+
+import (
+	"time"
+)
+
+type options struct {
+	clock           Clock
+	retryTimeout    time.Duration
+	maxRetryTimeout time.Duration
+	maxAttempts     int
+	isLeader        func() bool
+}
+
+func newOptions() *options {
+	return &options{
+		clock:           SystemClock{},
+		retryTimeout:    1 * time.Second,
+		maxRetryTimeout: 1 * time.Minute,
+		maxAttempts:     5,
+	}
+}
+
+type Option func(o *options)
+
+func WithClock(clock Clock) Option {
+	return func(o *options) {
+		o.clock = clock
+	}
+}
+
+func RetryTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.retryTimeout = timeout
+	}
+}
+
+// MaxRetryTimeout limits the exponential backoff between deliveries of a rejected timer
+func MaxRetryTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.maxRetryTimeout = timeout
+	}
+}
+
+// MaxAttempts sets how many times a timer is delivered before it is moved to Failed
+func MaxAttempts(attempts int) Option {
+	return func(o *options) {
+		if attempts > 0 {
+			o.maxAttempts = attempts
+		}
+	}
+}
+
+// WithLeadership sets the check that the node is the leader, e.g. keeper role.
+// By default, timers are fired while the target of Run is not read only
+func WithLeadership(isLeader func() bool) Option {
+	return func(o *options) {
+		o.isLeader = isLeader
+	}
+}
@@ -0,0 +1,429 @@
+package scheduler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/txix-open/isp-kit/log"
+	"github.com/txix-open/walx/v2/state"
+)
+
+var (
+	ErrNotFound         = errors.New("timer not found")
+	ErrAlreadyScheduled = errors.New("timer already scheduled")
+)
+
+type Timer struct {
+	Id     string
+	At     time.Time
+	Stream string
+	Event  []byte
+	// Attempts is the number of rejected deliveries, Error is the last rejection
+	Attempts int    `json:",omitempty"`
+	Error    string `json:",omitempty"`
+
+	index int
+}
+
+type cancelRequest struct {
+	Id string
+}
+
+type fireRequest struct {
+	Id string
+}
+
+type retryRequest struct {
+	Id    string
+	At    time.Time
+	Error string
+}
+
+type failRequest struct {
+	Id    string
+	Error string
+}
+
+type replayRequest struct {
+	Id string
+	At time.Time
+}
+
+type events struct {
+	Schedule *Timer         `json:",omitempty"`
+	Cancel   *cancelRequest `json:",omitempty"`
+	Fire     *fireRequest   `json:",omitempty"`
+	Retry    *retryRequest  `json:",omitempty"`
+	Fail     *failRequest   `json:",omitempty"`
+	Replay   *replayRequest `json:",omitempty"`
+}
+
+type nothing struct{}
+
+type Scheduler struct {
+	name    string
+	codec   state.Codec
+	mutator state.Mutator
+	options *options
+	logger  log.Logger
+
+	timers map[string]*Timer
+	failed map[string]*Timer
+	wheel  *wheel
+	lock   sync.Locker
+	notify chan struct{}
+}
+
+func New(name string, codec state.Codec, logger log.Logger, opts ...Option) *Scheduler {
+	options := newOptions()
+	for _, opt := range opts {
+		opt(options)
+	}
+	return &Scheduler{
+		name:    name,
+		codec:   codec,
+		options: options,
+		logger:  logger,
+		timers:  make(map[string]*Timer),
+		failed:  make(map[string]*Timer),
+		wheel:   &wheel{},
+		lock:    &sync.Mutex{},
+		notify:  make(chan struct{}, 1),
+	}
+}
+
+func (s *Scheduler) Schedule(at time.Time, stream string, event any) (string, error) {
+	id, err := newId()
+	if err != nil {
+		return "", errors.WithMessage(err, "generate timer id")
+	}
+	err = s.ScheduleWithId(id, at, stream, event)
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+func (s *Scheduler) ScheduleWithId(id string, at time.Time, stream string, event any) error {
+	data, err := state.MarshalEvent(s.codec, event)
+	if err != nil {
+		return errors.WithMessage(err, "marshal event")
+	}
+
+	_, err = state.Apply[nothing](s.mutator, events{
+		Schedule: &Timer{
+			Id:     id,
+			At:     at,
+			Stream: stream,
+			Event:  data,
+		},
+	})
+	return err
+}
+
+func (s *Scheduler) Cancel(id string) error {
+	_, err := state.Apply[nothing](s.mutator, events{
+		Cancel: &cancelRequest{Id: id},
+	})
+	return err
+}
+
+func (s *Scheduler) Get(id string) *Timer {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	timer, ok := s.timers[id]
+	if !ok {
+		return nil
+	}
+	result := *timer
+	return &result
+}
+
+func (s *Scheduler) Pending() []Timer {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	timers := make([]Timer, 0, len(s.timers))
+	for _, timer := range s.timers {
+		timers = append(timers, *timer)
+	}
+	sort.Slice(timers, func(i, j int) bool {
+		return firesBefore(&timers[i], &timers[j])
+	})
+	return timers
+}
+
+// Failed returns timers whose delivery was rejected MaxAttempts times
+func (s *Scheduler) Failed() []Timer {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	timers := make([]Timer, 0, len(s.failed))
+	for _, timer := range s.failed {
+		timers = append(timers, *timer)
+	}
+	sort.Slice(timers, func(i, j int) bool {
+		return firesBefore(&timers[i], &timers[j])
+	})
+	return timers
+}
+
+// Replay schedules the failed timer to fire again immediately
+func (s *Scheduler) Replay(id string) error {
+	_, err := state.Apply[nothing](s.mutator, events{
+		Replay: &replayRequest{Id: id, At: s.options.clock.Now()},
+	})
+	return err
+}
+
+// Run fires due timers by applying their events to target and journaling the fire afterward.
+// Timers are fired only while the node is the leader, see WithLeadership.
+// A rejected delivery is journaled and retried with backoff, after MaxAttempts the timer is moved to Failed.
+// A timer may be delivered more than once if the process stops between these two writes.
+func (s *Scheduler) Run(ctx context.Context, target state.Mutator) error {
+	ctx = log.ToContext(ctx, log.String("scheduler", s.name))
+	isLeader := s.options.isLeader
+	if isLeader == nil {
+		isLeader = targetIsWritable(target)
+	}
+	for {
+		if !isLeader() {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-s.notify:
+			case <-s.options.clock.After(s.options.retryTimeout):
+			}
+			continue
+		}
+
+		timer, wait := s.next()
+		if timer != nil && wait <= 0 {
+			err := s.fire(ctx, target, *timer)
+			if err != nil {
+				s.logger.Error(ctx, errors.WithMessage(err, "fire timer"), log.String("timerId", timer.Id))
+				wait = s.options.retryTimeout
+			} else {
+				continue
+			}
+		}
+
+		var after <-chan time.Time
+		if timer != nil {
+			after = s.options.clock.After(wait)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-s.notify:
+		case <-after:
+		}
+	}
+}
+
+func (s *Scheduler) StateName() string {
+	return s.name
+}
+
+func (s *Scheduler) SetMutator(mutator state.Mutator) {
+	s.mutator = mutator
+}
+
+func (s *Scheduler) Apply(log state.Log) (any, error) {
+	e, err := state.UnmarshalEvent[events](log)
+	if err != nil {
+		return nil, err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	switch {
+	case e.Schedule != nil:
+		return s.schedule(log, *e.Schedule)
+	case e.Cancel != nil:
+		return s.remove(e.Cancel.Id)
+	case e.Fire != nil:
+		return s.remove(e.Fire.Id)
+	case e.Retry != nil:
+		return s.retry(*e.Retry)
+	case e.Fail != nil:
+		return s.fail(*e.Fail)
+	case e.Replay != nil:
+		return s.replay(*e.Replay)
+	default:
+		return nil, errors.New("handler not found")
+	}
+}
+
+func (s *Scheduler) schedule(log state.Log, timer Timer) (any, error) {
+	_, ok := s.timers[timer.Id]
+	if ok {
+		return nil, ErrAlreadyScheduled
+	}
+
+	s.timers[timer.Id] = &timer
+	s.wheel.add(&timer)
+	if !log.IsInRecovery() {
+		s.wakeUp()
+	}
+	return nothing{}, nil
+}
+
+func (s *Scheduler) remove(id string) (any, error) {
+	timer, ok := s.timers[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	delete(s.timers, id)
+	s.wheel.remove(timer)
+	return nothing{}, nil
+}
+
+func (s *Scheduler) retry(req retryRequest) (any, error) {
+	timer, ok := s.timers[req.Id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	s.wheel.remove(timer)
+	timer.At = req.At
+	timer.Attempts++
+	timer.Error = req.Error
+	s.wheel.add(timer)
+	return nothing{}, nil
+}
+
+func (s *Scheduler) fail(req failRequest) (any, error) {
+	timer, ok := s.timers[req.Id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	delete(s.timers, req.Id)
+	s.wheel.remove(timer)
+	timer.Attempts++
+	timer.Error = req.Error
+	s.failed[req.Id] = timer
+	return nothing{}, nil
+}
+
+func (s *Scheduler) replay(req replayRequest) (any, error) {
+	timer, ok := s.failed[req.Id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	delete(s.failed, req.Id)
+	timer.At = req.At
+	timer.Attempts = 0
+	timer.Error = ""
+	s.timers[req.Id] = timer
+	s.wheel.add(timer)
+	s.wakeUp()
+	return nothing{}, nil
+}
+
+func (s *Scheduler) next() (*Timer, time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	timer := s.wheel.peek()
+	if timer == nil {
+		return nil, 0
+	}
+	result := *timer
+	return &result, result.At.Sub(s.options.clock.Now())
+}
+
+func (s *Scheduler) fire(ctx context.Context, target state.Mutator, timer Timer) error {
+	_, err := target.Apply(state.RawEvent(timer.Event), []byte(timer.Stream))
+	if err != nil {
+		return s.reject(ctx, timer, err)
+	}
+
+	_, err = state.Apply[nothing](s.mutator, events{
+		Fire: &fireRequest{Id: timer.Id},
+	})
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+func (s *Scheduler) reject(ctx context.Context, timer Timer, cause error) error {
+	attempts := timer.Attempts + 1
+	if attempts >= s.options.maxAttempts {
+		s.logger.Error(
+			ctx,
+			"timer event was rejected, timer is failed",
+			log.String("timerId", timer.Id),
+			log.Any("attempts", attempts),
+			log.Any("error", cause),
+		)
+		_, err := state.Apply[nothing](s.mutator, events{
+			Fail: &failRequest{Id: timer.Id, Error: cause.Error()},
+		})
+		if errors.Is(err, ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	backoff := s.options.retryTimeout << min(timer.Attempts, 16)
+	backoff = min(backoff, s.options.maxRetryTimeout)
+	s.logger.Warn(
+		ctx,
+		"timer event was rejected, retry later",
+		log.String("timerId", timer.Id),
+		log.Any("attempts", attempts),
+		log.String("retryIn", backoff.String()),
+		log.Any("error", cause),
+	)
+	_, err := state.Apply[nothing](s.mutator, events{
+		Retry: &retryRequest{
+			Id:    timer.Id,
+			At:    s.options.clock.Now().Add(backoff),
+			Error: cause.Error(),
+		},
+	})
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+func targetIsWritable(target state.Mutator) func() bool {
+	readOnly, ok := target.(interface{ IsReadOnly() bool })
+	if !ok {
+		return func() bool {
+			return true
+		}
+	}
+	return func() bool {
+		return !readOnly.IsReadOnly()
+	}
+}
+
+func (s *Scheduler) wakeUp() {
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+func newId() (string, error) {
+	id := make([]byte, 16)
+	_, err := rand.Read(id)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
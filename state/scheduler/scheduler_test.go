@@ -0,0 +1,334 @@
+package scheduler_test
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/txix-open/isp-kit/log"
+	"github.com/txix-open/walx/v2"
+	"github.com/txix-open/walx/v2/state"
+	"github.com/txix-open/walx/v2/state/codec/json"
+	"github.com/txix-open/walx/v2/state/scheduler"
+	"github.com/txix-open/walx/v2/state/sub"
+)
+
+type ExpireRequest struct {
+	Key string
+}
+
+type reservations struct {
+	sub.Router
+
+	lock     sync.Locker
+	expired  map[string]int
+	failures map[string]int
+}
+
+func newReservations() *reservations {
+	s := &reservations{
+		lock:     &sync.Mutex{},
+		expired:  make(map[string]int),
+		failures: make(map[string]int),
+	}
+	sub.On(s, "expire", func(req ExpireRequest) (any, error) {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+
+		if s.failures[req.Key] > 0 {
+			s.failures[req.Key]--
+			return nil, errors.New("reservation is locked")
+		}
+		s.expired[req.Key]++
+		return nil, nil
+	})
+	return s
+}
+
+func (s *reservations) FailNext(key string, times int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.failures[key] = times
+}
+
+func (s *reservations) Expired(key string) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.expired[key]
+}
+
+func (s *reservations) StateName() string {
+	return "reservations"
+}
+
+type waiter struct {
+	at time.Time
+	ch chan time.Time
+}
+
+type fakeClock struct {
+	lock    sync.Locker
+	now     time.Time
+	waiters []waiter
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{
+		lock: &sync.Mutex{},
+		now:  now,
+	}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.waiters = append(c.waiters, waiter{at: c.now.Add(d), ch: ch})
+	return ch
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.now = c.now.Add(d)
+	waiters := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.at.After(c.now) {
+			waiters = append(waiters, w)
+			continue
+		}
+		w.ch <- c.now
+	}
+	c.waiters = waiters
+}
+
+type app struct {
+	reservations *reservations
+	scheduler    *scheduler.Scheduler
+	state        *state.State
+}
+
+func newApp(t *testing.T, dir string, clock scheduler.Clock, opts ...scheduler.Option) *app {
+	t.Helper()
+	require := require.New(t)
+
+	logger, err := log.New()
+	require.NoError(err)
+	codec := json.NewCodec()
+	reservations := newReservations()
+	s := scheduler.New("scheduler", codec, logger, append([]scheduler.Option{scheduler.WithClock(clock)}, opts...)...)
+
+	wal, err := walx.Open(dir)
+	require.NoError(err)
+	composed := state.ComposeV2(reservations, s)
+	ss := state.New(wal, composed, codec, "test")
+	composed.SetMutator(ss)
+	err = ss.Recovery(context.Background())
+	require.NoError(err)
+
+	go func() {
+		err := ss.Run(context.Background())
+		require.NoError(err)
+	}()
+	time.Sleep(100 * time.Millisecond) // we must run wait before first run
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		err := s.Run(ctx, ss)
+		require.NoError(err)
+	}()
+	t.Cleanup(cancel)
+
+	return &app{
+		reservations: reservations,
+		scheduler:    s,
+		state:        ss,
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	dir := dir()
+	t.Cleanup(func() {
+		_ = os.RemoveAll(dir)
+	})
+	clock := newFakeClock(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	app := newApp(t, dir, clock)
+
+	_, err := app.scheduler.Schedule(clock.Now().Add(15*time.Minute), "reservations/expire", ExpireRequest{Key: "a"})
+	require.NoError(err)
+	cancelledId, err := app.scheduler.Schedule(clock.Now().Add(10*time.Minute), "reservations/expire", ExpireRequest{Key: "b"})
+	require.NoError(err)
+	require.Len(app.scheduler.Pending(), 2)
+
+	err = app.scheduler.Cancel(cancelledId)
+	require.NoError(err)
+	err = app.scheduler.Cancel(cancelledId)
+	require.ErrorIs(err, scheduler.ErrNotFound)
+
+	clock.Advance(14 * time.Minute)
+	time.Sleep(100 * time.Millisecond)
+	require.EqualValues(0, app.reservations.Expired("a"))
+	require.EqualValues(0, app.reservations.Expired("b"))
+
+	clock.Advance(1 * time.Minute)
+	require.Eventually(func() bool {
+		return app.reservations.Expired("a") == 1
+	}, 2*time.Second, 10*time.Millisecond)
+	require.Eventually(func() bool {
+		return len(app.scheduler.Pending()) == 0
+	}, 2*time.Second, 10*time.Millisecond)
+	require.EqualValues(0, app.reservations.Expired("b"))
+
+	err = app.state.Close()
+	require.NoError(err)
+}
+
+func TestScheduler_Recovery(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	dir := dir()
+	t.Cleanup(func() {
+		_ = os.RemoveAll(dir)
+	})
+	clock := newFakeClock(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	app := newApp(t, dir, clock)
+	id, err := app.scheduler.Schedule(clock.Now().Add(time.Hour), "reservations/expire", ExpireRequest{Key: "a"})
+	require.NoError(err)
+	err = app.state.Close()
+	require.NoError(err)
+
+	restored := newApp(t, dir, clock)
+	pending := restored.scheduler.Pending()
+	require.Len(pending, 1)
+	require.EqualValues(id, pending[0].Id)
+
+	clock.Advance(2 * time.Hour)
+	require.Eventually(func() bool {
+		return restored.reservations.Expired("a") == 1 && restored.scheduler.Get(id) == nil
+	}, 2*time.Second, 10*time.Millisecond)
+
+	err = restored.state.Close()
+	require.NoError(err)
+}
+
+func TestScheduler_RejectedTimer(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	dir := dir()
+	t.Cleanup(func() {
+		_ = os.RemoveAll(dir)
+	})
+	clock := newFakeClock(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	app := newApp(
+		t,
+		dir,
+		clock,
+		scheduler.RetryTimeout(time.Minute),
+		scheduler.MaxRetryTimeout(time.Hour),
+		scheduler.MaxAttempts(3),
+	)
+	app.reservations.FailNext("a", 4)
+
+	id, err := app.scheduler.Schedule(clock.Now().Add(time.Minute), "reservations/expire", ExpireRequest{Key: "a"})
+	require.NoError(err)
+
+	clock.Advance(time.Minute)
+	require.Eventually(func() bool {
+		timer := app.scheduler.Get(id)
+		return timer != nil && timer.Attempts == 1
+	}, 2*time.Second, 10*time.Millisecond)
+	require.Equal(clock.Now().Add(time.Minute), app.scheduler.Get(id).At)
+
+	clock.Advance(time.Minute)
+	require.Eventually(func() bool {
+		timer := app.scheduler.Get(id)
+		return timer != nil && timer.Attempts == 2
+	}, 2*time.Second, 10*time.Millisecond)
+	require.Equal(clock.Now().Add(2*time.Minute), app.scheduler.Get(id).At)
+
+	clock.Advance(2 * time.Minute)
+	require.Eventually(func() bool {
+		return len(app.scheduler.Failed()) == 1
+	}, 2*time.Second, 10*time.Millisecond)
+	require.Empty(app.scheduler.Pending())
+	failed := app.scheduler.Failed()[0]
+	require.EqualValues(3, failed.Attempts)
+	require.Equal("reservation is locked", failed.Error)
+
+	err = app.scheduler.Replay(id)
+	require.NoError(err)
+	require.Eventually(func() bool {
+		timer := app.scheduler.Get(id)
+		return timer != nil && timer.Attempts == 1
+	}, 2*time.Second, 10*time.Millisecond)
+	clock.Advance(time.Minute)
+	require.Eventually(func() bool {
+		return app.reservations.Expired("a") == 1 && len(app.scheduler.Pending()) == 0
+	}, 2*time.Second, 10*time.Millisecond)
+	require.Empty(app.scheduler.Failed())
+
+	err = app.state.Close()
+	require.NoError(err)
+}
+
+func TestScheduler_Follower(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	dir := dir()
+	t.Cleanup(func() {
+		_ = os.RemoveAll(dir)
+	})
+	clock := newFakeClock(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	app := newApp(t, dir, clock, scheduler.RetryTimeout(time.Minute))
+
+	_, err := app.scheduler.Schedule(clock.Now().Add(time.Minute), "reservations/expire", ExpireRequest{Key: "a"})
+	require.NoError(err)
+	app.state.SetReadOnly(nil)
+
+	clock.Advance(time.Minute)
+	time.Sleep(100 * time.Millisecond)
+	require.EqualValues(0, app.reservations.Expired("a"))
+	require.Len(app.scheduler.Pending(), 1)
+
+	app.state.SetWritable()
+	clock.Advance(time.Minute)
+	require.Eventually(func() bool {
+		return app.reservations.Expired("a") == 1
+	}, 2*time.Second, 10*time.Millisecond)
+
+	err = app.state.Close()
+	require.NoError(err)
+}
+
+func dir() string {
+	d := make([]byte, 8)
+	_, _ = rand.Read(d)
+	return hex.EncodeToString(d)
+}
@@ -0,0 +1,62 @@
+package scheduler
+
+import (
+	"container/heap"
+)
+
+type wheel []*Timer
+
+func (w wheel) Len() int {
+	return len(w)
+}
+
+func (w wheel) Less(i, j int) bool {
+	return firesBefore(w[i], w[j])
+}
+
+func (w wheel) Swap(i, j int) {
+	w[i], w[j] = w[j], w[i]
+	w[i].index = i
+	w[j].index = j
+}
+
+func (w *wheel) Push(x any) {
+	timer := x.(*Timer)
+	timer.index = len(*w)
+	*w = append(*w, timer)
+}
+
+func (w *wheel) Pop() any {
+	old := *w
+	n := len(old)
+	timer := old[n-1]
+	old[n-1] = nil
+	timer.index = -1
+	*w = old[:n-1]
+	return timer
+}
+
+func (w *wheel) add(timer *Timer) {
+	heap.Push(w, timer)
+}
+
+func (w *wheel) remove(timer *Timer) {
+	if timer.index < 0 {
+		return
+	}
+	heap.Remove(w, timer.index)
+}
+
+func (w wheel) peek() *Timer {
+	if len(w) == 0 {
+		return nil
+	}
+	return w[0]
+}
+
+func firesBefore(a *Timer, b *Timer) bool {
+	if a.At.Equal(b.At) {
+		return a.Id < b.Id
+	}
+	return a.At.Before(b.At)
+}
@@ -1,15 +1,30 @@
 package state
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/txix-open/walx/v2"
 	"github.com/txix-open/walx/v2/pool"
 )
 
+var (
+	ErrReadOnly        = errors.New("state is read only")
+	ErrForwardTimeout  = errors.New("forwarded entry is not replicated in time")
+	ErrForwardFiltered = errors.New("forwarded entry is filtered out by replication")
+	ErrForwardCanceled = errors.New("forwarded entry is canceled")
+)
+
+const (
+	defaultForwardTimeout = 10 * time.Second
+)
+
 type FSM interface {
 	Apply(log Log) (any, error)
 }
@@ -23,22 +38,94 @@ type BusinessState interface {
 	SetMutator(mutator Mutator)
 }
 
+type Forwarder interface {
+	Forward(data []byte) (uint64, error)
+}
+
 type State struct {
 	*walx.Log
 	codec         Codec
 	fsm           FSM
 	futures       *sync.Map
 	primaryStream []byte
+
+	readOnly       *atomic.Bool
+	forwarder      *atomic.Pointer[Forwarder]
+	forwards       *atomic.Int32
+	orphans        []orphan
+	forwardsLock   sync.Locker
+	replication    *atomic.Pointer[Replication]
+	appliedIndex   *atomic.Uint64
+	forwardTimeout *atomic.Int64
 }
 
 func New(log *walx.Log, fsm FSM, codec Codec, primaryStream string) *State {
+	forwardTimeout := &atomic.Int64{}
+	forwardTimeout.Store(int64(defaultForwardTimeout))
 	return &State{
-		Log:           log,
-		codec:         codec,
-		fsm:           fsm,
-		futures:       &sync.Map{},
-		primaryStream: []byte(primaryStream),
+		Log:            log,
+		codec:          codec,
+		fsm:            fsm,
+		futures:        &sync.Map{},
+		primaryStream:  []byte(primaryStream),
+		readOnly:       &atomic.Bool{},
+		forwarder:      &atomic.Pointer[Forwarder]{},
+		forwards:       &atomic.Int32{},
+		forwardsLock:   &sync.Mutex{},
+		replication:    &atomic.Pointer[Replication]{},
+		appliedIndex:   &atomic.Uint64{},
+		forwardTimeout: forwardTimeout,
+	}
+}
+
+func (s *State) SetReadOnly(forwarder Forwarder) {
+	if forwarder == nil {
+		s.forwarder.Store(nil)
+	} else {
+		s.forwarder.Store(&forwarder)
 	}
+	s.readOnly.Store(true)
+}
+
+// SetForwardTimeout limits how long Apply on a follower waits
+// until the forwarded entry is replicated back and applied locally
+func (s *State) SetForwardTimeout(timeout time.Duration) {
+	s.forwardTimeout.Store(int64(timeout))
+}
+
+func (s *State) SetWritable() {
+	s.readOnly.Store(false)
+	s.forwarder.Store(nil)
+}
+
+// AppliedIndex returns the index of the last entry processed by the state
+func (s *State) AppliedIndex() uint64 {
+	return s.appliedIndex.Load()
+}
+
+func (s *State) IsReadOnly() bool {
+	return s.readOnly.Load()
+}
+
+// Write journals the packed event, e.g. forwarded by a follower, and waits until it is replicated
+// as set by SetReplication. It doesn't wait until the event is applied locally.
+// If replication fails the index of the written entry is returned with ErrNotReplicated
+func (s *State) Write(data []byte, nextIndex func(index uint64)) (uint64, error) {
+	if s.readOnly.Load() {
+		return 0, ErrReadOnly
+	}
+
+	replication := s.replication.Load()
+	if replication == nil || replication.Mode == AsyncReplication {
+		return s.Log.Write(data, nextIndex)
+	}
+	name, _ := UnpackEvent(data)
+	streamName := bytes.Clone(name)
+	index, err := s.Log.Write(data, nextIndex)
+	if err != nil {
+		return 0, err
+	}
+	return index, replication.wait(index, streamName)
 }
 
 func (s *State) Recovery(ctx context.Context) error {
@@ -62,24 +149,50 @@ func (s *State) Recovery(ctx context.Context) error {
 
 		streamName, data := UnpackEvent(entry.Data)
 		log := NewLog(streamName, data, s.codec)
+		log.index = entry.Index
 		log.isInRecovery = true
 		if MatchStream(streamName, s.primaryStream) {
 			_, _ = s.fsm.Apply(log)
 		}
 	}
+	s.appliedIndex.Store(lastIndex)
 
 	return nil
 }
 
 func (s *State) Apply(event any, streamSuffix []byte) (any, error) {
+	replication := s.replication.Load()
+	if replication == nil {
+		return s.apply(event, streamSuffix, Replication{})
+	}
+	return s.apply(event, streamSuffix, *replication)
+}
+
+// apply writes the event, waits until it is applied locally and then replicated as configured.
+// If replication fails the event remains applied locally
+func (s *State) apply(event any, streamSuffix []byte, replication Replication) (any, error) {
 	buff := pool.AcquireBuffer()
 	err := PackEvent(s.primaryStream, streamSuffix, event, s.codec, buff)
 	if err != nil {
 		return nil, fmt.Errorf("pack event: %w", err)
 	}
 
+	if s.readOnly.Load() {
+		defer pool.ReleaseBuffer(buff)
+		forwarder := s.forwarder.Load()
+		if forwarder == nil {
+			return nil, ErrReadOnly
+		}
+		return s.forward(*forwarder, buff.Bytes())
+	}
+
+	var streamName []byte
+	if replication.Mode != AsyncReplication {
+		name, _ := UnpackEvent(buff.Bytes())
+		streamName = bytes.Clone(name)
+	}
 	future := newFuture(event)
-	_, err = s.Log.Write(buff.Bytes(), func(index uint64) {
+	index, err := s.Log.Write(buff.Bytes(), func(index uint64) {
 		s.futures.Store(index, future)
 	})
 	if err != nil {
@@ -87,7 +200,16 @@ func (s *State) Apply(event any, streamSuffix []byte) (any, error) {
 	}
 	pool.ReleaseBuffer(buff)
 
-	return future.wait()
+	response, err := future.wait()
+	if err != nil {
+		return response, err
+	}
+
+	err = replication.wait(index, streamName)
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
 }
 
 func (s *State) Run(ctx context.Context) error {
@@ -106,6 +228,10 @@ func (s *State) Run(ctx context.Context) error {
 
 		streamName, data := UnpackEvent(entry.Data)
 		if !MatchStream(streamName, s.primaryStream) {
+			s.appliedIndex.Store(entry.Index)
+			if s.forwards.Load() > 0 {
+				s.completeForwarded(entry.Index, nil, ErrForwardFiltered)
+			}
 			continue
 		}
 
@@ -113,15 +239,123 @@ func (s *State) Run(ctx context.Context) error {
 		future, ok := featureValue.(*future)
 
 		log := NewLog(streamName, data, s.codec)
-		if ok && future.event != nil {
+		log.index = entry.Index
+		if ok && future.event != nil && !isRawEvent(future.event) {
 			log.event = future.event
 		}
 
 		response, err := s.fsm.Apply(log)
+		s.appliedIndex.Store(entry.Index)
 
 		if ok {
 			future.complete(response, err)
+			continue
 		}
+		if s.forwards.Load() > 0 {
+			s.completeForwarded(entry.Index, response, err)
+		}
+	}
+}
+
+// forward writes data on the leader and waits until the entry is replicated and applied locally,
+// so the response is produced by the local FSM
+func (s *State) forward(forwarder Forwarder, data []byte) (any, error) {
+	s.forwards.Add(1)
+	defer s.releaseForward()
+
+	index, err := forwarder.Forward(data)
+	if err != nil {
+		return nil, fmt.Errorf("forward: %w", err)
+	}
+
+	future := newFuture(nil)
+	future.forwarded = true
+	s.forwardsLock.Lock()
+	orphan, applied := s.orphan(index)
+	if !applied {
+		s.futures.Store(index, future)
+	}
+	s.forwardsLock.Unlock()
+
+	if applied {
+		return orphan.result.response, orphan.result.err
+	}
+
+	timer := time.NewTimer(time.Duration(s.forwardTimeout.Load()))
+	defer timer.Stop()
+	select {
+	case result := <-future.ch:
+		return result.response, result.err
+	case <-timer.C:
+		s.forwardsLock.Lock()
+		s.futures.CompareAndDelete(index, future)
+		s.forwardsLock.Unlock()
+		return nil, fmt.Errorf("%w: index %d", ErrForwardTimeout, index)
+	}
+}
+
+// CancelForwards completes all pending forwarded applies with ErrForwardCanceled,
+// e.g. when the node starts replicating from another leader and forwarded entries may never arrive
+func (s *State) CancelForwards() {
+	s.forwardsLock.Lock()
+	defer s.forwardsLock.Unlock()
+
+	s.futures.Range(func(key, value any) bool {
+		future, ok := value.(*future)
+		if ok && future.forwarded {
+			s.futures.Delete(key)
+			future.complete(nil, ErrForwardCanceled)
+		}
+		return true
+	})
+}
+
+func (s *State) completeForwarded(index uint64, response any, err error) {
+	s.forwardsLock.Lock()
+	defer s.forwardsLock.Unlock()
+
+	value, ok := s.futures.LoadAndDelete(index)
+	if ok {
+		value.(*future).complete(response, err)
+		return
+	}
+
+	// the entry may be applied before its forwarder receives the index,
+	// results are kept no longer than the forwarder waits for them
+	now := time.Now()
+	timeout := time.Duration(s.forwardTimeout.Load())
+	expired := sort.Search(len(s.orphans), func(i int) bool {
+		return now.Sub(s.orphans[i].appliedAt) < timeout
+	})
+	s.orphans = append(s.orphans[expired:], orphan{
+		index:     index,
+		result:    result{response: response, err: err},
+		appliedAt: now,
+	})
+}
+
+// orphan returns the result of the entry applied before its future was registered.
+// Orphans are appended in the order of application, so they are sorted by index
+func (s *State) orphan(index uint64) (orphan, bool) {
+	i := sort.Search(len(s.orphans), func(i int) bool {
+		return s.orphans[i].index >= index
+	})
+	if i < len(s.orphans) && s.orphans[i].index == index {
+		return s.orphans[i], true
+	}
+	return orphan{}, false
+}
+
+func (s *State) releaseForward() {
+	if s.forwards.Add(-1) > 0 {
+		return
+	}
+
+	s.forwardsLock.Lock()
+	defer s.forwardsLock.Unlock()
+
+	if s.forwards.Load() == 0 {
+		s.orphans = nil
 	}
 }
 
@@ -142,6 +376,11 @@ func (s *State) Close() error {
 	return nil
 }
 
+func isRawEvent(event any) bool {
+	_, ok := event.(RawEvent)
+	return ok
+}
+
 func Apply[T any](mutator Mutator, event any) (res *T, err error) {
 	return ApplyWithStreamSuffix[T](mutator, event, nil)
 }
@@ -94,6 +94,44 @@ func TestState(t *testing.T) {
 	require.EqualValues(10, s.value)
 }
 
+type stalledLeader struct {
+	index uint64
+}
+
+func (l stalledLeader) Forward(data []byte) (uint64, error) {
+	return l.index, nil
+}
+
+func TestState_ForwardStalledLeader(t *testing.T) {
+	t.Parallel()
+
+	dir := dir()
+	t.Cleanup(func() {
+		_ = os.RemoveAll(dir)
+	})
+	require := require.New(t)
+
+	ss := state.New(createWal(dir, require), &businessState{}, json.NewCodec(), "test")
+	t.Cleanup(func() {
+		_ = ss.Close()
+	})
+	ss.SetReadOnly(stalledLeader{index: 100})
+	ss.SetForwardTimeout(100 * time.Millisecond)
+
+	start := time.Now()
+	_, err := ss.Apply(events{Add: &v{1}}, nil)
+	require.ErrorIs(err, state.ErrForwardTimeout)
+	require.Less(time.Since(start), time.Second)
+
+	ss.SetForwardTimeout(time.Minute)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		ss.CancelForwards()
+	}()
+	_, err = ss.Apply(events{Add: &v{1}}, nil)
+	require.ErrorIs(err, state.ErrForwardCanceled)
+}
+
 func dir() string {
 	d := make([]byte, 8)
 	_, _ = rand.Read(d)
@@ -105,3 +143,21 @@ func createWal(dir string, require *require.Assertions) *walx.Log {
 	require.NoError(err)
 	return wal
 }
+
+func TestLog_Derive(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	log := state.NewLog([]byte("test"), nil, json.NewCodec()).Derive([]byte("test/add"), events{Add: &v{Value: 5}})
+	e := events{}
+	err := log.Unmarshal(&e)
+	require.NoError(err)
+	require.Equal(5, e.Add.Value)
+
+	typed, err := state.UnmarshalEvent[events](log)
+	require.NoError(err)
+	require.Equal(5, typed.Add.Value)
+	converted, err := state.UnmarshalEvent[struct{ Add v }](log)
+	require.NoError(err)
+	require.Equal(5, converted.Add.Value)
+}
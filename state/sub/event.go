@@ -0,0 +1,24 @@
+package sub
+
+import (
+	"github.com/txix-open/walx/v2/state"
+	unsafe2 "github.com/txix-open/walx/v2/unsafe"
+)
+
+type Event[Req any, Resp any] struct {
+	name string
+}
+
+func NewEvent[Req any, Resp any](name string) Event[Req, Resp] {
+	return Event[Req, Resp]{
+		name: name,
+	}
+}
+
+func (e Event[Req, Resp]) Name() string {
+	return e.name
+}
+
+func (e Event[Req, Resp]) Emit(s State, payload Req) (*Resp, error) {
+	return state.ApplyWithStreamSuffix[Resp](s.getMutator(), payload, unsafe2.StringToBytes(e.name))
+}
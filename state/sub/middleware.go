@@ -0,0 +1,119 @@
+package sub
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/txix-open/isp-kit/log"
+	"github.com/txix-open/isp-kit/metrics"
+)
+
+type Validator interface {
+	Validate(value any) (bool, map[string]string)
+}
+
+type ValidationError struct {
+	EventName string
+	Details   map[string]string
+}
+
+func (e ValidationError) Error() string {
+	details := make([]string, 0, len(e.Details))
+	for field, message := range e.Details {
+		details = append(details, fmt.Sprintf("%s: %s", field, message))
+	}
+	sort.Strings(details)
+	return fmt.Sprintf("invalid payload of event '%s': %s", e.EventName, strings.Join(details, "; "))
+}
+
+func Recovery() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(req Request) (result any, err error) {
+			defer func() {
+				r := recover()
+				if r == nil {
+					return
+				}
+				recovered, ok := r.(error)
+				if ok {
+					err = recovered
+				} else {
+					err = fmt.Errorf("%v", r)
+				}
+				stack := make([]byte, 4<<10)
+				length := runtime.Stack(stack, false)
+				result = nil
+				err = errors.Errorf("panic during handling event '%s': %v\n%s", req.EventName, err, stack[:length])
+			}()
+			return next(req)
+		}
+	}
+}
+
+func Metrics() Middleware {
+	durationMetric := metrics.GetOrRegister(
+		metrics.DefaultRegistry,
+		prometheus.NewSummaryVec(prometheus.SummaryOpts{
+			Name:       "sub_event_handle_duration_ms",
+			Help:       "Time to handle event in milliseconds by sub router",
+			Objectives: metrics.DefaultObjectives,
+		}, []string{"event"}),
+	)
+	return func(next HandlerFunc) HandlerFunc {
+		return func(req Request) (any, error) {
+			start := time.Now()
+			result, err := next(req)
+			if !req.Log.IsInRecovery() {
+				elapsed := time.Since(start)
+				durationMetric.WithLabelValues(req.EventName).Observe(float64(elapsed) / float64(time.Millisecond))
+			}
+			return result, err
+		}
+	}
+}
+
+func Logging(logger log.Logger) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(req Request) (any, error) {
+			start := time.Now()
+			result, err := next(req)
+
+			ctx := context.Background()
+			fields := []log.Field{
+				log.String("event", req.EventName),
+				log.Any("inRecovery", req.Log.IsInRecovery()),
+				log.Any("elapsedTime", time.Since(start)),
+			}
+			if err != nil && !req.Log.IsInRecovery() {
+				logger.Error(ctx, errors.WithMessage(err, "handle event"), fields...)
+				return result, err
+			}
+			logger.Debug(ctx, "event handled", fields...)
+			return result, err
+		}
+	}
+}
+
+func Validation(validator Validator) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(req Request) (any, error) {
+			if req.Payload == nil {
+				return next(req)
+			}
+			ok, details := validator.Validate(req.Payload)
+			if !ok {
+				return nil, ValidationError{
+					EventName: req.EventName,
+					Details:   details,
+				}
+			}
+			return next(req)
+		}
+	}
+}
@@ -2,6 +2,8 @@ package sub
 
 import (
 	"bytes"
+	"reflect"
+	"sort"
 
 	"github.com/pkg/errors"
 	"github.com/txix-open/walx/v2/state"
@@ -10,21 +12,42 @@ import (
 
 type Hook func(log state.Log, request any, result any, err error)
 
+type Request struct {
+	Log       state.Log
+	EventName string
+	Payload   any
+}
+
+type HandlerFunc func(req Request) (any, error)
+
+type Middleware func(next HandlerFunc) HandlerFunc
+
 type State interface {
 	on(eventName string, handler handler)
 	getMutator() state.Mutator
+}
 
-	getHook() Hook
+type EventInfo struct {
+	Name     string
+	Request  reflect.Type
+	Response reflect.Type
 }
 
 type handler struct {
-	handler func(log state.Log) (any, error)
+	decode       func(log state.Log) (any, error)
+	handle       HandlerFunc
+	chain        HandlerFunc
+	requestType  reflect.Type
+	responseType reflect.Type
 }
 
 type Router struct {
-	handlers map[string]handler
-	mutator  state.Mutator
-	hook     Hook
+	handlers      map[string]handler
+	middlewares   []Middleware
+	fallback      HandlerFunc
+	fallbackChain HandlerFunc
+	mutator       state.Mutator
+	hook          Hook
 }
 
 func (s *Router) SetMutator(mutator state.Mutator) {
@@ -38,51 +61,116 @@ func (s *Router) Apply(log state.Log) (any, error) {
 	}
 
 	eventName := unsafe2.BytesToString(streamSuffix)
+	req := Request{
+		Log:       log,
+		EventName: eventName,
+	}
+	handle := s.fallbackChain
 	handler, ok := s.handlers[eventName]
-	if !ok {
+	switch {
+	case ok:
+		payload, err := handler.decode(log)
+		if err != nil {
+			return nil, errors.WithMessage(err, "unmarshal event")
+		}
+		req.Payload = payload
+		handle = handler.chain
+	case handle == nil:
 		return nil, errors.Errorf("unknown event: %s", eventName)
 	}
 
-	return handler.handler(log)
+	return handle(req)
 }
 
+func (s *Router) Events() []EventInfo {
+	events := make([]EventInfo, 0, len(s.handlers))
+	for name, handler := range s.handlers {
+		events = append(events, EventInfo{
+			Name:     name,
+			Request:  handler.requestType,
+			Response: handler.responseType,
+		})
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].Name < events[j].Name
+	})
+	return events
+}
+
+// SetHook sets a hook called after a registered handler, it is not called for fallback
+// and for events rejected by middlewares
 func (s *Router) SetHook(hook Hook) {
 	s.hook = hook
 }
 
+func (s *Router) Use(middlewares ...Middleware) {
+	s.middlewares = append(s.middlewares, middlewares...)
+	for name, h := range s.handlers {
+		h.chain = s.chain(s.withHook(h.handle))
+		s.handlers[name] = h
+	}
+	if s.fallback != nil {
+		s.fallbackChain = s.chain(s.fallback)
+	}
+}
+
+func (s *Router) SetFallback(fallback HandlerFunc) {
+	s.fallback = fallback
+	s.fallbackChain = s.chain(fallback)
+}
+
 func (s *Router) on(eventName string, h handler) {
 	if s.handlers == nil {
 		s.handlers = map[string]handler{}
 	}
+	h.chain = s.chain(s.withHook(h.handle))
 	s.handlers[eventName] = h
 }
 
-func (s *Router) getMutator() state.Mutator {
-	return s.mutator
+// chain wraps handle with middlewares, it is built once on registration instead of per event
+func (s *Router) chain(handle HandlerFunc) HandlerFunc {
+	for i := len(s.middlewares) - 1; i >= 0; i-- {
+		handle = s.middlewares[i](handle)
+	}
+	return handle
 }
 
-func (s *Router) getHook() Hook {
-	return s.hook
+func (s *Router) withHook(handle HandlerFunc) HandlerFunc {
+	return func(req Request) (any, error) {
+		result, err := handle(req)
+		if s.hook != nil {
+			s.hook(req.Log, req.Payload, result, err)
+		}
+		return result, err
+	}
 }
 
-func On[T any](s State, eventName string, h func(payload T) (any, error)) {
-	ff := func(log state.Log) (any, error) {
-		request, err := state.UnmarshalEvent[T](log)
-		if err != nil {
-			return nil, errors.WithMessage(err, "unmarshal event")
-		}
+func (s *Router) getMutator() state.Mutator {
+	return s.mutator
+}
 
-		result, err := h(request)
+func On[T any](s State, eventName string, h func(payload T) (any, error)) {
+	s.on(eventName, newHandler(h, nil))
+}
 
-		hook := s.getHook()
-		if hook != nil {
-			hook(log, request, result, err)
-		}
+func Handle[Req any, Resp any](s State, event Event[Req, Resp], h func(payload Req) (Resp, error)) {
+	handle := func(payload Req) (any, error) {
+		return h(payload)
+	}
+	s.on(event.name, newHandler(handle, reflect.TypeFor[Resp]()))
+}
 
-		return result, err
+func newHandler[T any](h func(payload T) (any, error), responseType reflect.Type) handler {
+	return handler{
+		decode: func(log state.Log) (any, error) {
+			return state.UnmarshalEvent[T](log)
+		},
+		handle: func(req Request) (any, error) {
+			return h(req.Payload.(T))
+		},
+		requestType:  reflect.TypeFor[T](),
+		responseType: responseType,
 	}
-	handler := handler{handler: ff}
-	s.on(eventName, handler)
 }
 
 func Emit[T any](s State, eventName string, payload any) (*T, error) {
@@ -1,6 +1,7 @@
 package sub_test
 
 import (
+	"reflect"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -95,3 +96,158 @@ func TestSubState(t *testing.T) {
 
 	require.EqualValues(3, hookCalled.Load())
 }
+
+type SetRequest struct {
+	Key   string
+	Value int
+}
+
+type keyValidator struct{}
+
+func (keyValidator) Validate(value any) (bool, map[string]string) {
+	req, ok := value.(SetRequest)
+	if ok && req.Key == "" {
+		return false, map[string]string{"key": "required"}
+	}
+	return true, nil
+}
+
+type MiddlewareExample struct {
+	sub.Router
+
+	data map[string]int
+	lock sync.Locker
+}
+
+func NewMiddlewareExample() *MiddlewareExample {
+	state := &MiddlewareExample{
+		data: make(map[string]int),
+		lock: &sync.Mutex{},
+	}
+	sub.On(state, "set", state.set)
+	sub.On(state, "panic", func(payload SetRequest) (any, error) {
+		panic("unexpected")
+	})
+	return state
+}
+
+func (s *MiddlewareExample) set(request SetRequest) (any, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.data[request.Key] = request.Value
+	return request.Value, nil
+}
+
+func (s *MiddlewareExample) StateName() string {
+	return "middleware"
+}
+
+func TestRouterMiddlewares(t *testing.T) {
+	t.Parallel()
+	require := require2.New(t)
+
+	s := NewMiddlewareExample()
+	calls := make([]string, 0)
+	hooked := make([]any, 0)
+	s.SetHook(func(log state.Log, request any, result any, err error) {
+		hooked = append(hooked, result)
+	})
+	composed := 0
+	tracing := func(name string) sub.Middleware {
+		return func(next sub.HandlerFunc) sub.HandlerFunc {
+			composed++
+			return func(req sub.Request) (any, error) {
+				calls = append(calls, name+":"+req.EventName)
+				return next(req)
+			}
+		}
+	}
+	s.Use(sub.Recovery(), tracing("first"), tracing("second"), sub.Validation(keyValidator{}))
+	s.SetFallback(func(req sub.Request) (any, error) {
+		return "fallback:" + req.EventName, nil
+	})
+	tstate.ServeState(t, s)
+
+	value, err := sub.Emit[int](s, "set", SetRequest{Key: "a", Value: 5})
+	require.NoError(err)
+	require.EqualValues(5, *value)
+	require.Equal([]string{"first:set", "second:set"}, calls)
+
+	_, err = sub.Emit[int](s, "set", SetRequest{Value: 5})
+	validationErr := sub.ValidationError{}
+	require.ErrorAs(err, &validationErr)
+	require.EqualValues(map[string]string{"key": "required"}, validationErr.Details)
+
+	_, err = sub.Emit[int](s, "panic", SetRequest{Key: "a"})
+	require.ErrorContains(err, "panic during handling event 'panic'")
+
+	fallback, err := sub.Emit[string](s, "unknown", SetRequest{Key: "a"})
+	require.NoError(err)
+	require.EqualValues("fallback:unknown", *fallback)
+
+	_, err = sub.Emit[int](s, "set", SetRequest{Key: "b", Value: 7})
+	require.NoError(err)
+	require.Equal([]any{5, 7}, hooked)
+	require.EqualValues(2*3, composed)
+}
+
+type DecRequest struct {
+	Key string
+	By  int
+}
+
+var (
+	decEvent = sub.NewEvent[DecRequest, int]("dec")
+)
+
+type TypedExample struct {
+	sub.Router
+
+	data map[string]int
+}
+
+func NewTypedExample() *TypedExample {
+	state := &TypedExample{
+		data: make(map[string]int),
+	}
+	sub.Handle(state, decEvent, state.dec)
+	sub.On(state, "reset", func(payload IncRequest) (any, error) {
+		delete(state.data, payload.Key)
+		return nil, nil
+	})
+	return state
+}
+
+func (s *TypedExample) dec(request DecRequest) (int, error) {
+	s.data[request.Key] -= request.By
+	return s.data[request.Key], nil
+}
+
+func (s *TypedExample) StateName() string {
+	return "typed"
+}
+
+func TestTypedEvent(t *testing.T) {
+	t.Parallel()
+	require := require2.New(t)
+
+	s := NewTypedExample()
+	tstate.ServeState(t, s)
+
+	value, err := decEvent.Emit(s, DecRequest{Key: "a", By: 3})
+	require.NoError(err)
+	require.EqualValues(-3, *value)
+	value, err = decEvent.Emit(s, DecRequest{Key: "a", By: 2})
+	require.NoError(err)
+	require.EqualValues(-5, *value)
+
+	require.Equal([]sub.EventInfo{{
+		Name:     "dec",
+		Request:  reflect.TypeFor[DecRequest](),
+		Response: reflect.TypeFor[int](),
+	}, {
+		Name:    "reset",
+		Request: reflect.TypeFor[IncRequest](),
+	}}, s.Events())
+}
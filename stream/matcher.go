@@ -35,6 +35,15 @@ func (m Matcher) Match(entry walx.Entry) bool {
 	}
 
 	streamName, _ := state.UnpackEvent(entry.Data)
+	return m.MatchStream(streamName)
+}
+
+// MatchStream reports whether entries of the stream pass the filter
+func (m Matcher) MatchStream(streamName []byte) bool {
+	if m.matchAllStreams {
+		return true
+	}
+
 	for _, s2 := range m.filteredStreamsInBytes {
 		if state.MatchStream(streamName, s2) {
 			return true
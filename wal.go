@@ -10,7 +10,8 @@ import (
 )
 
 var (
-	ErrClosed = wal.ErrClosed
+	ErrClosed   = wal.ErrClosed
+	ErrNotFound = wal.ErrNotFound
 )
 
 type Log struct {
@@ -216,6 +217,29 @@ func (l *Log) TruncateFront(newFirstIndex uint64) error {
 	return nil
 }
 
+// TruncateBack removes all entries after newLastIndex.
+// Opened readers must not be positioned after newLastIndex
+func (l *Log) TruncateBack(newLastIndex uint64) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	err := l.log.TruncateBack(newLastIndex)
+	if err != nil {
+		return fmt.Errorf("wal back truncate: %w", err)
+	}
+	l.index.Store(newLastIndex)
+	return nil
+}
+
+// Read returns data of the entry with index or ErrNotFound, returned data must not be modified
+func (l *Log) Read(index uint64) ([]byte, error) {
+	data, err := l.log.Read(index)
+	if err != nil {
+		return nil, fmt.Errorf("wal read %d: %w", index, err)
+	}
+	return data, nil
+}
+
 func (l *Log) trySync(bytesWritten int) (bool, error) {
 	l.writtenBytes += bytesWritten
 	if l.writtenBytes < l.fsyncThreshold {
@@ -71,6 +71,36 @@ func TestConcurrent(t *testing.T) {
 	require.NoError(err)
 }
 
+func TestTruncateBack(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	dir := dir()
+	t.Cleanup(func() {
+		_ = os.RemoveAll(dir)
+	})
+	wal, err := walx.Open(dir)
+	require.NoError(err)
+	for _, data := range []string{"a", "b", "c"} {
+		_, err := wal.Write([]byte(data), func(index uint64) {})
+		require.NoError(err)
+	}
+
+	err = wal.TruncateBack(1)
+	require.NoError(err)
+	require.EqualValues(1, wal.LastIndex())
+	data, err := wal.Read(1)
+	require.NoError(err)
+	require.Equal([]byte("a"), data)
+	_, err = wal.Read(2)
+	require.ErrorIs(err, walx.ErrNotFound)
+
+	index, err := wal.Write([]byte("d"), func(index uint64) {})
+	require.NoError(err)
+	require.EqualValues(2, index)
+	require.NoError(wal.Close())
+}
+
 func dir() string {
 	d := make([]byte, 8)
 	_, _ = rand.Read(d)